@@ -0,0 +1,433 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// appleMusicConfig holds the MusicKit key used to sign developer tokens,
+// from the Apple Developer account, and optionally a Music User Token
+// already obtained elsewhere. The base URL can point at a stand-in server.
+type appleMusicConfig struct {
+	TeamID         string `json:"teamId"`
+	KeyID          string `json:"keyId"`
+	PrivateKeyFile string `json:"privateKeyFile"` //the AuthKey_<key id>.p8 file
+	MusicUserToken string `json:"musicUserToken"`
+	Storefront     string `json:"storefront"` //e.g. us, by default the user's own
+	BaseURL        string `json:"baseUrl"`
+}
+
+const (
+	appleMusicDefaultURL    = "https://api.music.apple.com"
+	appleMusicPageLimit     = 100 //the most items the API returns, or takes, at once
+	appleMusicTokenLife     = 12 * time.Hour
+	appleMusicSearchResults = 10
+)
+
+// AppleMusic reads library playlists, catalog playlists and albums from
+// Apple Music, and writes new library playlists or loves songs. Library
+// playlists have IDs starting p., catalog playlists pl. and albums are
+// numbers.
+type AppleMusic struct {
+	ID   string
+	Kind refKind
+}
+
+func NewAppleMusic(ref playlistRef) *AppleMusic {
+	return &AppleMusic{ID: ref.ID, Kind: ref.Kind}
+}
+
+type appleMusicResource struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Attributes struct {
+		Name             string `json:"name"`
+		ArtistName       string `json:"artistName"`
+		AlbumName        string `json:"albumName"`
+		DurationInMillis int64  `json:"durationInMillis"`
+		ISRC             string `json:"isrc"`
+		URL              string `json:"url"`
+		CanEdit          bool   `json:"canEdit"`
+		IsPublic         bool   `json:"isPublic"`
+		Description      struct {
+			Standard string `json:"standard"`
+		} `json:"description"`
+	} `json:"attributes"`
+	Relationships struct {
+		Catalog struct {
+			Data []appleMusicResource `json:"data"`
+		} `json:"catalog"`
+	} `json:"relationships"`
+}
+
+type appleMusicPage struct {
+	Data []appleMusicResource `json:"data"`
+	Next string               `json:"next"`
+}
+
+// appleMusicAPI is a client for the Apple Music API signed in as the user.
+type appleMusicAPI struct {
+	client         *http.Client
+	base           string
+	storefront     string
+	developerToken string
+	signedIn       bool //the Music User Token came from signing in here, so it can be renewed
+}
+
+func (A *AppleMusic) GetTracks() []Track {
+	api := newAppleMusicAPI()
+	var path string
+	switch {
+	case A.ID == likedID:
+		log.Fatalf("Apple Music does not list loved songs, convert the Favourite Songs playlist instead")
+	case A.Kind == albumKind:
+		path = "/v1/catalog/" + api.storefront + "/albums/" + A.ID + "/tracks"
+	case strings.HasPrefix(A.ID, "p."):
+		path = "/v1/me/library/playlists/" + A.ID + "/tracks?include=catalog"
+	default:
+		path = "/v1/catalog/" + api.storefront + "/playlists/" + A.ID + "/tracks"
+	}
+	var tracks []Track
+	for _, song := range api.all(path) {
+		track := appleMusicTrack(song)
+		tracks = append(tracks, track)
+		fmt.Printf("%v, (%v)\n", track.Query(), track.ID)
+	}
+	return tracks
+}
+
+// WritePlaylist creates a new library playlist, or loves the songs when
+// the reference is to the liked songs.
+func (A *AppleMusic) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	switch A.ID {
+	case "":
+		return createAppleMusicPlaylist(name, tracks, matches)
+	case likedID:
+		return loveAppleMusicSongs(tracks, matches)
+	case albumsID:
+		log.Fatalf("Saving albums to an Apple Music library is not supported, convert to applemusic to make a playlist")
+	}
+	log.Fatalf("Adding to an existing Apple Music playlist is not supported")
+	return ConversionResult{}
+}
+
+func appleMusicTrack(song appleMusicResource) Track { //library songs carry their catalog song, which has the ISRC and link
+	track := Track{
+		ID:       song.ID,
+		Title:    song.Attributes.Name,
+		Artist:   song.Attributes.ArtistName,
+		Album:    song.Attributes.AlbumName,
+		ISRC:     song.Attributes.ISRC,
+		Location: song.Attributes.URL,
+		Duration: time.Duration(song.Attributes.DurationInMillis) * time.Millisecond,
+	}
+	if catalog := song.Relationships.Catalog.Data; len(catalog) > 0 {
+		track.ID = catalog[0].ID
+		track.ISRC = catalog[0].Attributes.ISRC
+		track.Location = catalog[0].Attributes.URL
+	}
+	return track
+}
+
+func newAppleMusicAPI() *appleMusicAPI {
+	config := settings.AppleMusic
+	developerToken, err := appleMusicDeveloperToken(config)
+	handleError(err, "Unable to sign Apple Music developer token")
+	api := &appleMusicAPI{
+		base:           strings.TrimSuffix(config.BaseURL, "/"),
+		storefront:     config.Storefront,
+		developerToken: developerToken,
+		signedIn:       config.MusicUserToken == "",
+	}
+	if api.signedIn {
+		api.useUserToken(appleMusicUserToken(developerToken))
+	} else {
+		api.useUserToken(config.MusicUserToken)
+	}
+	if api.base == "" {
+		api.base = appleMusicDefaultURL
+	}
+	if api.storefront == "" {
+		var page appleMusicPage
+		api.request("GET", "/v1/me/storefront", nil, &page)
+		if len(page.Data) == 0 {
+			log.Fatalf("Unable to find the Apple Music storefront of your account")
+		}
+		api.storefront = page.Data[0].ID
+	}
+	return api
+}
+
+func (api *appleMusicAPI) useUserToken(userToken string) {
+	api.client = &http.Client{Transport: &headerTransport{header: http.Header{
+		"Authorization":    {"Bearer " + api.developerToken},
+		"Music-User-Token": {userToken},
+	}}}
+}
+
+// appleMusicDeveloperToken signs the developer token, a JWT signed with
+// ES256 by the MusicKit private key.
+func appleMusicDeveloperToken(config appleMusicConfig) (string, error) {
+	if config.TeamID == "" || config.KeyID == "" || config.PrivateKeyFile == "" {
+		return "", fmt.Errorf("set appleMusic.teamId, keyId and privateKeyFile in %s", configFile)
+	}
+	data, err := os.ReadFile(config.PrivateKeyFile)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", fmt.Errorf("%s is not a .p8 key file", config.PrivateKeyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("%s is not an elliptic curve key", config.PrivateKeyFile)
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": config.KeyID})
+	claims, _ := json.Marshal(map[string]interface{}{"iss": config.TeamID, "iat": now.Unix(), "exp": now.Add(appleMusicTokenLife).Unix()})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	signature := make([]byte, 64) //r and s, each padded to 32 bytes
+	for i, n := range []*big.Int{r, s} {
+		n.FillBytes(signature[32*i : 32*(i+1)])
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+var appleMusicAuthPage = template.Must(template.New("auth").Parse(`<!DOCTYPE html>
+<html><head><title>Sign in to Apple Music</title>
+<script src="https://js-cdn.music.apple.com/musickit/v3/musickit.js" async></script>
+<script>
+document.addEventListener("musickitloaded", async function () {
+	await MusicKit.configure({developerToken: {{.}}, app: {name: "Playlist Converter", build: "1.0"}});
+	const token = await MusicKit.getInstance().authorize();
+	window.location = "/token?code=" + encodeURIComponent(token);
+});
+</script></head>
+<body>Signing in to Apple Music, allow the pop up if your browser blocks it.</body></html>`))
+
+// appleMusicUserToken gets a Music User Token, which only MusicKit JS can
+// ask the user for, by serving a sign in page on localhost:8080. The token
+// is cached like the OAuth tokens of the other services.
+func appleMusicUserToken(developerToken string) string {
+	cacheFile, err := tokenCacheFile("applemusic")
+	if err != nil {
+		log.Fatalf("Unable to get path to cached credential file. %v", err)
+	}
+	if tok, err := tokenFromFile(cacheFile); err == nil && tok.AccessToken != "" {
+		return tok.AccessToken
+	}
+	listener, err := net.Listen("tcp", "localhost:8080")
+	handleError(err, "Unable to start a web server")
+	codeCh := make(chan string, 1)
+	go http.Serve(listener, appleMusicSignIn(developerToken, codeCh))
+	authURL := "http://localhost:8080/"
+	if err := openURL(authURL); err != nil {
+		fmt.Println("Open this page in your browser to sign in to Apple Music:")
+	}
+	fmt.Println(authURL)
+	token := <-codeCh
+	listener.Close()
+	saveToken(cacheFile, &oauth2.Token{AccessToken: token})
+	return token
+}
+
+// appleMusicSignIn serves the sign in page, and sends the token MusicKit
+// JS redirects back with on codeCh. Only the first token is sent, so a
+// reload of the redirect does not block.
+func appleMusicSignIn(developerToken string, codeCh chan<- string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			appleMusicAuthPage.Execute(w, developerToken)
+		case "/token":
+			fmt.Fprint(w, "Signed in to Apple Music, you can now safely close this browser window.")
+			select {
+			case codeCh <- r.FormValue("code"):
+			default:
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// request calls the API. Music User Tokens expire after a few months, so
+// when a token from signing in is refused the cached one is deleted and
+// the user signs in again.
+func (api *appleMusicAPI) request(method string, path string, body interface{}, out interface{}) {
+	err := apiRequest(api.client, method, api.base+path, nil, body, out)
+	var status *apiStatusError
+	if errors.As(err, &status) && status.StatusCode == http.StatusUnauthorized && api.signedIn {
+		fmt.Println("The Apple Music sign in has expired, sign in again")
+		cacheFile, cacheErr := tokenCacheFile("applemusic")
+		handleError(cacheErr, "Unable to get path to cached credential file")
+		os.Remove(cacheFile)
+		api.signedIn = false //only sign in again once
+		api.useUserToken(appleMusicUserToken(api.developerToken))
+		err = apiRequest(api.client, method, api.base+path, nil, body, out)
+	}
+	handleError(err, "Apple Music request failed")
+}
+
+func (api *appleMusicAPI) all(path string) []appleMusicResource { //follows the next links of a paged list
+	var items []appleMusicResource
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	path += fmt.Sprintf("%slimit=%d", separator, appleMusicPageLimit)
+	for path != "" {
+		var page appleMusicPage
+		api.request("GET", path, nil, &page)
+		items = append(items, page.Data...)
+		path = page.Next
+	}
+	return items
+}
+
+// searchAppleMusicSongs finds the catalog song for each track, by ISRC
+// when the track has one, otherwise among the results of searching for the
+// title and artist.
+func searchAppleMusicSongs(api *appleMusicAPI, playlist []Track, result *ConversionResult, matches *matchCache) []string {
+	return matches.find(APPLEMUSIC, playlist, result, func(track Track) string {
+		if track.ISRC != "" {
+			var page appleMusicPage
+			api.request("GET", "/v1/catalog/"+api.storefront+"/songs?filter[isrc]="+url.QueryEscape(track.ISRC), nil, &page)
+			if len(page.Data) > 0 {
+				return page.Data[0].ID
+			}
+		}
+		var search struct {
+			Results struct {
+				Songs appleMusicPage `json:"songs"`
+			} `json:"results"`
+		}
+		term := strings.TrimSpace(track.Title + " " + track.Artist)
+		api.request("GET", fmt.Sprintf("/v1/catalog/%s/search?types=songs&limit=%d&term=%s", api.storefront, appleMusicSearchResults, url.QueryEscape(term)), nil, &search)
+		var candidates []Track
+		for _, song := range search.Results.Songs.Data {
+			candidates = append(candidates, appleMusicTrack(song))
+		}
+		found, _ := newLocalLibrary(candidates).match(track)
+		return found.ID
+	})
+}
+
+type appleMusicTrackData struct {
+	Data []appleMusicReference `json:"data"`
+}
+
+type appleMusicReference struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+func appleMusicSongs(ids []string) appleMusicTrackData {
+	data := appleMusicTrackData{Data: []appleMusicReference{}}
+	for _, id := range ids {
+		data.Data = append(data.Data, appleMusicReference{ID: id, Type: "songs"})
+	}
+	return data
+}
+
+func createAppleMusicPlaylist(name string, playlist []Track, matches *matchCache) ConversionResult { //creates a library playlist called name with the songs found
+	api := newAppleMusicAPI()
+	result := ConversionResult{Name: name, Destination: "applemusic", Total: len(playlist)}
+	songIds := searchAppleMusicSongs(api, playlist, &result, matches)
+	var created appleMusicPage
+	api.request("POST", "/v1/me/library/playlists", map[string]interface{}{
+		"attributes": map[string]string{"name": name},
+	}, &created)
+	if len(created.Data) == 0 {
+		log.Fatalf("Unable to create Apple Music playlist")
+	}
+	playlistId := created.Data[0].ID
+	addInBatches(songIds, appleMusicPageLimit, &result, func(batch []string) {
+		api.request("POST", "/v1/me/library/playlists/"+playlistId+"/tracks", appleMusicSongs(batch), nil)
+	})
+	fmt.Println("Added songs to Apple Music")
+	return result
+}
+
+func loveAppleMusicSongs(playlist []Track, matches *matchCache) ConversionResult { //loves the songs instead of adding them to a playlist
+	api := newAppleMusicAPI()
+	result := ConversionResult{Name: "Loved songs", Destination: "applemusic:liked", Total: len(playlist)}
+	for _, id := range searchAppleMusicSongs(api, playlist, &result, matches) {
+		api.request("PUT", "/v1/me/ratings/songs/"+id, map[string]interface{}{
+			"type":       "rating",
+			"attributes": map[string]int{"value": 1},
+		}, nil)
+		result.Added++
+	}
+	fmt.Println("Loved songs on Apple Music")
+	return result
+}
+
+func appleMusicSummary(item appleMusicResource) playlistSummary {
+	return playlistSummary{
+		ID:          item.ID,
+		Name:        item.Attributes.Name,
+		Description: item.Attributes.Description.Standard,
+		Owned:       item.Attributes.CanEdit,
+		Public:      item.Attributes.IsPublic,
+	}
+}
+
+func listAppleMusicPlaylists(includeFollowed bool) []playlistSummary { //lists the user's library playlists, the ones they can edit unless includeFollowed is set
+	var playlists []playlistSummary
+	for _, item := range newAppleMusicAPI().all("/v1/me/library/playlists") {
+		summary := appleMusicSummary(item)
+		if summary.Owned || includeFollowed {
+			playlists = append(playlists, summary)
+		}
+	}
+	return playlists
+}
+
+func appleMusicPlaylistSummary(ref playlistRef) playlistSummary { //gets the name and details of whatever a reference points at
+	api := newAppleMusicAPI()
+	var page appleMusicPage
+	switch {
+	case ref.Kind == albumKind:
+		api.request("GET", "/v1/catalog/"+api.storefront+"/albums/"+ref.ID, nil, &page)
+	case strings.HasPrefix(ref.ID, "p."):
+		api.request("GET", "/v1/me/library/playlists/"+ref.ID, nil, &page)
+	default:
+		api.request("GET", "/v1/catalog/"+api.storefront+"/playlists/"+ref.ID, nil, &page)
+	}
+	if len(page.Data) == 0 {
+		log.Fatalf("Apple Music %s not found", ref.ID)
+	}
+	summary := appleMusicSummary(page.Data[0])
+	summary.Owner = page.Data[0].Attributes.ArtistName
+	return summary
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeAppleMusic is a stand-in for the Apple Music API with a gb
+// storefront, a library playlist p.lib of two songs over two pages, and
+// songs found by ISRC or by searching for "found", below a cover by
+// another artist.
+type fakeAppleMusic struct {
+	t       *testing.T
+	key     *ecdsa.PublicKey
+	created string     //name of the playlist created
+	added   [][]string //song IDs of each request adding to it
+}
+
+func (f *fakeAppleMusic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Music-User-Token") != "user-token" || !f.validDeveloperToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")) {
+		http.Error(w, `{"errors":[{"status":"401"}]}`, http.StatusUnauthorized)
+		return
+	}
+	song := func(id, name, isrc string) map[string]interface{} {
+		return map[string]interface{}{"id": id, "type": "songs", "attributes": map[string]interface{}{"name": name, "artistName": "Artist", "isrc": isrc, "durationInMillis": 200000}}
+	}
+	var response interface{}
+	switch r.Method + " " + r.URL.Path {
+	case "GET /v1/me/storefront":
+		response = map[string]interface{}{"data": []interface{}{map[string]string{"id": "gb"}}}
+	case "GET /v1/catalog/gb/songs":
+		data := []interface{}{}
+		if isrc := r.URL.Query().Get("filter[isrc]"); isrc == "GBAAA0000001" {
+			data = append(data, song("1001", "By ISRC", isrc))
+		}
+		response = map[string]interface{}{"data": data}
+	case "GET /v1/catalog/gb/search":
+		data := []interface{}{}
+		if r.URL.Query().Get("limit") != fmt.Sprint(appleMusicSearchResults) {
+			f.t.Errorf("searched for %s results, want %d", r.URL.Query().Get("limit"), appleMusicSearchResults)
+		}
+		if term := r.URL.Query().Get("term"); strings.Contains(term, "found") { //a cover at the top, then the song
+			cover := song("9009", strings.TrimSuffix(term, " Artist"), "")
+			cover["attributes"].(map[string]interface{})["artistName"] = "Tribute Band"
+			data = append(data, cover, song("2002", strings.TrimSuffix(term, " Artist"), ""))
+		}
+		response = map[string]interface{}{"results": map[string]interface{}{"songs": map[string]interface{}{"data": data}}}
+	case "GET /v1/me/library/playlists/p.lib/tracks":
+		if r.URL.Query().Get("offset") == "" {
+			library := song("i.1", "First", "")
+			library["relationships"] = map[string]interface{}{"catalog": map[string]interface{}{"data": []interface{}{song("3003", "First", "GBAAA0000003")}}}
+			response = map[string]interface{}{"data": []interface{}{library}, "next": "/v1/me/library/playlists/p.lib/tracks?include=catalog&offset=1"}
+		} else {
+			response = map[string]interface{}{"data": []interface{}{song("i.2", "Second", "")}}
+		}
+	case "POST /v1/me/library/playlists":
+		var body struct {
+			Attributes struct {
+				Name string `json:"name"`
+			} `json:"attributes"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.created = body.Attributes.Name
+		response = map[string]interface{}{"data": []interface{}{map[string]string{"id": "p.new"}}}
+	case "POST /v1/me/library/playlists/p.new/tracks":
+		var body appleMusicTrackData
+		json.NewDecoder(r.Body).Decode(&body)
+		var ids []string
+		for _, item := range body.Data {
+			if item.Type != "songs" {
+				f.t.Errorf("added a %s, want songs", item.Type)
+			}
+			ids = append(ids, item.ID)
+		}
+		f.added = append(f.added, ids)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func (f *fakeAppleMusic) validDeveloperToken(token string) bool { //checks the ES256 signature against the key the test signed with
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		return false
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	return ecdsa.Verify(f.key, digest[:], r, s)
+}
+
+func newFakeAppleMusic(t *testing.T) *fakeAppleMusic {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "AuthKey_KEY123.p8")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeAppleMusic{t: t, key: &key.PublicKey}
+	url := standIn(t, fake)
+	settings.AppleMusic = appleMusicConfig{TeamID: "TEAM123", KeyID: "KEY123", PrivateKeyFile: keyFile, MusicUserToken: "user-token", BaseURL: url}
+	return fake
+}
+
+func TestAppleMusicSearch(t *testing.T) {
+	newFakeAppleMusic(t)
+	api := newAppleMusicAPI()
+	if api.storefront != "gb" {
+		t.Fatalf("storefront = %q, want the account's gb", api.storefront)
+	}
+	playlist := []Track{
+		{Title: "Anything", Artist: "Artist", ISRC: "GBAAA0000001"},
+		{Title: "Unknown ISRC but found", Artist: "Artist", ISRC: "GBAAA0000009"},
+		{Title: "Missing", Artist: "Nobody"},
+	}
+	result := ConversionResult{}
+	matches := newMatchCache()
+	ids := searchAppleMusicSongs(api, playlist, &result, matches)
+	if want := []string{"1001", "2002"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("found %v, want %v", ids, want)
+	}
+	if want := []string{"Missing - Nobody"}; !reflect.DeepEqual(result.NotFound, want) {
+		t.Errorf("not found %v, want %v", result.NotFound, want)
+	}
+	if id, cached := matches.get(APPLEMUSIC, matchKey(playlist[2])); !cached || id != "" {
+		t.Errorf("the failed search was not cached, got %q, %v", id, cached)
+	}
+}
+
+func TestAppleMusicGetTracks(t *testing.T) {
+	newFakeAppleMusic(t)
+	tracks := NewAppleMusic(playlistRef{Service: APPLEMUSIC, ID: "p.lib"}).GetTracks()
+	if len(tracks) != 2 {
+		t.Fatalf("read %d tracks, want 2 across both pages", len(tracks))
+	}
+	if tracks[0].ID != "3003" || tracks[0].ISRC != "GBAAA0000003" {
+		t.Errorf("first track %+v does not carry its catalog song's ID and ISRC", tracks[0])
+	}
+	if tracks[1].ID != "i.2" || tracks[1].Title != "Second" {
+		t.Errorf("second track = %+v", tracks[1])
+	}
+}
+
+func TestAppleMusicCreatePlaylist(t *testing.T) {
+	fake := newFakeAppleMusic(t)
+	var playlist []Track
+	for i := 0; i < appleMusicPageLimit+20; i++ {
+		playlist = append(playlist, Track{Title: fmt.Sprintf("found %d", i), Artist: "Artist"})
+	}
+	playlist = append(playlist, Track{Title: "Missing", Artist: "Nobody"})
+	result := NewAppleMusic(playlistRef{Service: APPLEMUSIC}).WritePlaylist("Road Trip", playlist, newMatchCache())
+	if fake.created != "Road Trip" {
+		t.Errorf("created %q, want Road Trip", fake.created)
+	}
+	if len(fake.added) != 2 || len(fake.added[0]) != appleMusicPageLimit || len(fake.added[1]) != 20 {
+		t.Errorf("added batches of %d songs, want %d then 20", batchSizes(fake.added), appleMusicPageLimit)
+	}
+	if result.Total != len(playlist) || result.Added != appleMusicPageLimit+20 || len(result.NotFound) != 1 {
+		t.Errorf("result = %d of %d added, %d not found", result.Added, result.Total, len(result.NotFound))
+	}
+}
+
+func batchSizes(batches [][]string) []int {
+	var sizes []int
+	for _, batch := range batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+func TestAppleMusicSignIn(t *testing.T) {
+	codeCh := make(chan string, 1)
+	url := standIn(t, appleMusicSignIn("developer-token", codeCh))
+	get := func(path string) int {
+		resp, err := http.Get(url + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := get("/favicon.ico"); status != http.StatusNotFound {
+		t.Errorf("/favicon.ico returned %d, want 404", status)
+	}
+	if status := get("/"); status != http.StatusOK {
+		t.Errorf("the sign in page returned %d", status)
+	}
+	for _, code := range []string{"first", "reload"} { //the second request would block if the send waited
+		if status := get("/token?code=" + code); status != http.StatusOK {
+			t.Errorf("/token returned %d", status)
+		}
+	}
+	if token := <-codeCh; token != "first" {
+		t.Errorf("signed in with %q, want the first token", token)
+	}
+}
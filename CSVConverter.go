@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// csvConfig maps the columns of CSV playlists to track fields. A column is
+// given by its header, or by its number counting from 1 for files without a
+// header row. Fields that are not mapped are found by the headers used by
+// common exporters such as Exportify and TuneMyMusic.
+type csvConfig struct {
+	Delimiter string            `json:"delimiter"`
+	Columns   map[string]string `json:"columns"` //title, artist, album, isrc, duration or url to a column
+}
+
+// csvHeaders are the headers each field is recognised by, lower case.
+var csvHeaders = map[string][]string{
+	"title":    {"track name", "title", "song", "song name", "name", "track"},
+	"artist":   {"artist name(s)", "artist name", "artist", "artists", "creator", "performer"},
+	"album":    {"album name", "album", "release"},
+	"isrc":     {"isrc"},
+	"duration": {"track duration (ms)", "duration (ms)", "duration_ms", "duration", "length", "time"},
+	"url":      {"track uri", "url", "uri", "link", "location", "spotify - id", "spotify url", "youtube url"},
+}
+
+// CSVFile reads and writes playlists as CSV, one track per row.
+type CSVFile struct {
+	Path string
+}
+
+func NewCSVFile(ref playlistRef) *CSVFile {
+	return &CSVFile{Path: ref.ID}
+}
+
+func (C *CSVFile) GetTracks() []Track {
+	f, err := os.Open(C.Path)
+	if err != nil {
+		log.Fatalf("Unable to read CSV playlist: %v", err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.Comma = csvDelimiter()
+	r.FieldsPerRecord = -1 //exports are not always tidy
+	r.LazyQuotes = true
+	rows, err := r.ReadAll()
+	handleError(err, "Unable to parse CSV playlist")
+	if len(rows) == 0 {
+		return nil
+	}
+	rows[0][0] = strings.TrimPrefix(rows[0][0], "\ufeff") //byte order mark
+	columns, hasHeader, err := csvColumns(rows[0], settings.CSV.Columns)
+	handleError(err, "Unable to map CSV columns")
+	durationHeader := ""
+	if hasHeader {
+		if i, ok := columns["duration"]; ok && i < len(rows[0]) {
+			durationHeader = rows[0][i]
+		}
+		rows = rows[1:]
+	}
+
+	var tracks []Track
+	for _, row := range rows {
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+		track := Track{
+			Title:    field("title"),
+			Artist:   field("artist"),
+			Album:    field("album"),
+			ISRC:     field("isrc"),
+			Duration: parseCSVDuration(field("duration"), durationHeader),
+			Location: field("url"),
+		}
+		if track.Title == "" {
+			continue
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+// WritePlaylist writes every track with its metadata, followed by a column
+// for each service that tracks were matched on by other destinations in the
+// same job, holding the ID each track matched. Without a path the file is
+// named after the playlist.
+func (C *CSVFile) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	path := C.Path
+	if path == "" {
+		path = playlistFileName(name, ".csv")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Unable to create CSV playlist: %v", err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Comma = csvDelimiter()
+	services := matches.services()
+	header := []string{"Title", "Artist", "Album", "ISRC", "Duration (ms)", "URL"}
+	for _, service := range services {
+		header = append(header, fmt.Sprintf("%s ID", service))
+	}
+	w.Write(header)
+	for _, track := range tracks {
+		row := []string{track.Title, track.Artist, track.Album, track.ISRC, "", track.Location}
+		if track.Duration > 0 {
+			row[4] = strconv.FormatInt(track.Duration.Milliseconds(), 10)
+		}
+		for _, service := range services {
+			id, _ := matches.get(service, matchKey(track))
+			row = append(row, id)
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	handleError(w.Error(), "Unable to write CSV playlist")
+	fmt.Println("Wrote", path)
+	return ConversionResult{Name: name, Destination: "csv:" + path, Total: len(tracks), Added: len(tracks)}
+}
+
+func csvDelimiter() rune { //the configured delimiter, a comma by default
+	if settings.CSV.Delimiter == "" {
+		return ','
+	}
+	if settings.CSV.Delimiter == `\t` {
+		return '\t'
+	}
+	r, _ := utf8.DecodeRuneInString(settings.CSV.Delimiter)
+	return r
+}
+
+// csvColumns works out which column holds each field, from the configured
+// mapping first and then from the header. It reports whether the first row
+// is a header, which it is unless no column could be found by name.
+func csvColumns(firstRow []string, mapping map[string]string) (map[string]int, bool, error) {
+	columns := make(map[string]int)
+	hasHeader := false
+	headerIndex := make(map[string]int)
+	for i, cell := range firstRow {
+		key := strings.ToLower(strings.TrimSpace(cell))
+		if _, seen := headerIndex[key]; !seen {
+			headerIndex[key] = i
+		}
+	}
+	for field, column := range mapping {
+		if _, known := csvHeaders[field]; !known {
+			return nil, false, fmt.Errorf("unknown field %q, expected title, artist, album, isrc, duration or url", field)
+		}
+		if n, err := strconv.Atoi(column); err == nil && n > 0 {
+			columns[field] = n - 1
+			continue
+		}
+		i, ok := headerIndex[strings.ToLower(strings.TrimSpace(column))]
+		if !ok {
+			return nil, false, fmt.Errorf("no column called %q for %s", column, field)
+		}
+		columns[field] = i
+		hasHeader = true
+	}
+	for field, headers := range csvHeaders {
+		if _, mapped := columns[field]; mapped {
+			continue
+		}
+		for _, header := range headers {
+			if i, ok := headerIndex[header]; ok {
+				columns[field] = i
+				hasHeader = true
+				break
+			}
+		}
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, false, fmt.Errorf("could not find the title column, map it under csv columns in %s", configFile)
+	}
+	return columns, hasHeader, nil
+}
+
+// parseCSVDuration reads a track length written as h:mm:ss or m:ss, or as a
+// number of milliseconds or seconds. Numbers are milliseconds when the header
+// says so or when they are too big to be seconds.
+func parseCSVDuration(value string, header string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if strings.Contains(value, ":") {
+		var seconds int
+		for _, part := range strings.Split(value, ":") {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0
+			}
+			seconds = seconds*60 + n
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	if strings.Contains(strings.ToLower(header), "ms") || n > 36000 {
+		return time.Duration(n) * time.Millisecond
+	}
+	return time.Duration(n * float64(time.Second))
+}
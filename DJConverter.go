@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// DJExport writes playlists for DJ software: Rekordbox collection XML,
+// Traktor NML and Serato crates. DJ software only plays files, so every
+// track is matched against the music folder set in the config file and
+// the playlist refers to the files found. These formats can only be
+// written, not read.
+type DJExport struct {
+	Path   string
+	Format Service //REKORDBOX, TRAKTOR or SERATO
+}
+
+func NewDJExport(ref playlistRef) *DJExport {
+	return &DJExport{Path: ref.ID, Format: ref.Service}
+}
+
+// djExtensions are the file extensions written for each format.
+var djExtensions = map[Service]string{REKORDBOX: ".xml", TRAKTOR: ".nml", SERATO: ".crate"}
+
+func (D *DJExport) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	path := D.Path
+	if path == "" {
+		path = playlistFileName(name, djExtensions[D.Format])
+	}
+	result := ConversionResult{Name: name, Destination: D.Format.String() + ":" + path, Total: len(tracks)}
+	library := openMusicLibrary()
+	var files []Track
+	for _, track := range tracks {
+		file, ok := library.match(track)
+		if !ok {
+			result.NotFound = append(result.NotFound, track.Query())
+			continue
+		}
+		files = append(files, file)
+	}
+	result.Added = len(files)
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Unable to create %s playlist: %v", D.Format, err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	switch D.Format {
+	case REKORDBOX:
+		err = writeRekordbox(w, name, files)
+	case TRAKTOR:
+		err = writeTraktor(w, name, files)
+	case SERATO:
+		err = writeSeratoCrate(w, files)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	handleError(err, fmt.Sprintf("Unable to write %s playlist", D.Format))
+	fmt.Println("Wrote", path)
+	return result
+}
+
+type rekordboxFile struct {
+	XMLName    xml.Name            `xml:"DJ_PLAYLISTS"`
+	Version    string              `xml:"Version,attr"`
+	Product    rekordboxProduct    `xml:"PRODUCT"`
+	Collection rekordboxCollection `xml:"COLLECTION"`
+	Root       rekordboxNode       `xml:"PLAYLISTS>NODE"`
+}
+
+type rekordboxCollection struct {
+	Entries int              `xml:"Entries,attr"`
+	Tracks  []rekordboxTrack `xml:"TRACK"`
+}
+
+type rekordboxProduct struct {
+	Name    string `xml:"Name,attr"`
+	Version string `xml:"Version,attr"`
+	Company string `xml:"Company,attr"`
+}
+
+type rekordboxTrack struct {
+	TrackID   int    `xml:"TrackID,attr,omitempty"`
+	Key       int    `xml:"Key,attr,omitempty"` //a playlist entry refers to a collection track by its ID
+	Name      string `xml:"Name,attr,omitempty"`
+	Artist    string `xml:"Artist,attr,omitempty"`
+	Album     string `xml:"Album,attr,omitempty"`
+	TotalTime int    `xml:"TotalTime,attr,omitempty"` //seconds
+	Location  string `xml:"Location,attr,omitempty"`
+}
+
+type rekordboxNode struct {
+	Type    int              `xml:"Type,attr"` //0 for a folder, 1 for a playlist
+	Name    string           `xml:"Name,attr"`
+	Count   int              `xml:"Count,attr,omitempty"`
+	KeyType *int             `xml:"KeyType,attr"` //0 when tracks are referred to by ID
+	Entries *int             `xml:"Entries,attr"`
+	Nodes   []rekordboxNode  `xml:"NODE"`
+	Tracks  []rekordboxTrack `xml:"TRACK"`
+}
+
+// writeRekordbox writes a collection XML, for File > Import Collection or
+// the Imported Library in rekordbox's preferences, holding the files and
+// one playlist of them.
+func writeRekordbox(w io.Writer, name string, files []Track) error {
+	keyType, entries := 0, len(files)
+	playlist := rekordboxNode{Type: 1, Name: name, KeyType: &keyType, Entries: &entries}
+	doc := rekordboxFile{
+		Version:    "1.0.0",
+		Product:    rekordboxProduct{Name: "rekordbox", Version: "6.0.0", Company: "AlphaTheta"},
+		Collection: rekordboxCollection{Entries: len(files)},
+		Root:       rekordboxNode{Type: 0, Name: "ROOT", Count: 1},
+	}
+	for i, file := range files {
+		doc.Collection.Tracks = append(doc.Collection.Tracks, rekordboxTrack{
+			TrackID:   i + 1,
+			Name:      file.Title,
+			Artist:    file.Artist,
+			Album:     file.Album,
+			TotalTime: int(file.Duration / time.Second),
+			Location:  localFileURL(file.Location),
+		})
+		playlist.Tracks = append(playlist.Tracks, rekordboxTrack{Key: i + 1})
+	}
+	doc.Root.Nodes = []rekordboxNode{playlist}
+	return writeXML(w, doc)
+}
+
+type traktorFile struct {
+	XMLName    xml.Name          `xml:"NML"`
+	Version    int               `xml:"VERSION,attr"`
+	Head       traktorHead       `xml:"HEAD"`
+	Collection traktorCollection `xml:"COLLECTION"`
+	Root       traktorNode       `xml:"PLAYLISTS>NODE"`
+}
+
+type traktorCollection struct {
+	Entries int            `xml:"ENTRIES,attr"`
+	Tracks  []traktorEntry `xml:"ENTRY"`
+}
+
+type traktorHead struct {
+	Company string `xml:"COMPANY,attr"`
+	Program string `xml:"PROGRAM,attr"`
+}
+
+type traktorEntry struct {
+	Title    string          `xml:"TITLE,attr,omitempty"`
+	Artist   string          `xml:"ARTIST,attr,omitempty"`
+	Location traktorLocation `xml:"LOCATION"`
+	Album    *traktorAlbum   `xml:"ALBUM"`
+	Info     *traktorInfo    `xml:"INFO"`
+}
+
+type traktorLocation struct {
+	Dir    string `xml:"DIR,attr"`
+	File   string `xml:"FILE,attr"`
+	Volume string `xml:"VOLUME,attr"`
+}
+
+type traktorAlbum struct {
+	Title string `xml:"TITLE,attr"`
+}
+
+type traktorInfo struct {
+	Playtime int `xml:"PLAYTIME,attr"` //seconds
+}
+
+type traktorNode struct {
+	Type     string           `xml:"TYPE,attr"`
+	Name     string           `xml:"NAME,attr"`
+	Subnodes *traktorSubnodes `xml:"SUBNODES"`
+	Playlist *traktorPlaylist `xml:"PLAYLIST"`
+}
+
+type traktorSubnodes struct {
+	Count int           `xml:"COUNT,attr"`
+	Nodes []traktorNode `xml:"NODE"`
+}
+
+type traktorPlaylist struct {
+	Entries int                    `xml:"ENTRIES,attr"`
+	Type    string                 `xml:"TYPE,attr"`
+	Items   []traktorPlaylistEntry `xml:"ENTRY"`
+}
+
+type traktorPlaylistEntry struct {
+	Key traktorEntryKey `xml:"PRIMARYKEY"`
+}
+
+type traktorEntryKey struct {
+	Type string `xml:"TYPE,attr"`
+	Key  string `xml:"KEY,attr"`
+}
+
+// writeTraktor writes an NML file for Traktor's Import Playlist. Traktor
+// identifies files by volume and a path with every folder prefixed by /:,
+// so on Windows C:\Music\a.mp3 is C: and /:Music/:a.mp3.
+func writeTraktor(w io.Writer, name string, files []Track) error {
+	playlist := &traktorPlaylist{Entries: len(files), Type: "LIST"}
+	doc := traktorFile{
+		Version:    19,
+		Head:       traktorHead{Company: "www.native-instruments.com", Program: "Traktor"},
+		Collection: traktorCollection{Entries: len(files)},
+		Root:       traktorNode{Type: "FOLDER", Name: "$ROOT"},
+	}
+	for _, file := range files {
+		location := traktorPath(file.Location)
+		entry := traktorEntry{Title: file.Title, Artist: file.Artist, Location: location}
+		if file.Album != "" {
+			entry.Album = &traktorAlbum{Title: file.Album}
+		}
+		if file.Duration > 0 {
+			entry.Info = &traktorInfo{Playtime: int(file.Duration / time.Second)}
+		}
+		doc.Collection.Tracks = append(doc.Collection.Tracks, entry)
+		key := traktorEntryKey{Type: "TRACK", Key: location.Volume + location.Dir + location.File}
+		playlist.Items = append(playlist.Items, traktorPlaylistEntry{Key: key})
+	}
+	doc.Root.Subnodes = &traktorSubnodes{Count: 1, Nodes: []traktorNode{{Type: "PLAYLIST", Name: name, Playlist: playlist}}}
+	return writeXML(w, doc)
+}
+
+func traktorPath(path string) traktorLocation {
+	volume := filepath.VolumeName(path)
+	dir := filepath.ToSlash(strings.TrimPrefix(filepath.Dir(path), volume))
+	var location traktorLocation
+	location.Volume = volume
+	location.File = filepath.Base(path)
+	for _, folder := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if folder != "" {
+			location.Dir += "/:" + folder
+		}
+	}
+	location.Dir += "/:"
+	return location
+}
+
+// writeSeratoCrate writes a crate, which goes in the _Serato_/Subcrates
+// folder of the drive the files are on. A crate is a series of fields,
+// each a four letter tag and a big endian length, holding either UTF-16
+// text or more fields. Paths are relative to the root of the drive.
+func writeSeratoCrate(w io.Writer, files []Track) error {
+	var crate []byte
+	crate = append(crate, seratoField("vrsn", seratoText("1.0/Serato ScratchLive Crate"))...)
+	for _, column := range []string{"song", "artist", "album", "length"} {
+		crate = append(crate, seratoField("ovct", append(seratoField("tvcn", seratoText(column)), seratoField("tvcw", seratoText("0"))...))...)
+	}
+	for _, file := range files {
+		path := filepath.ToSlash(strings.TrimPrefix(file.Location, filepath.VolumeName(file.Location)))
+		crate = append(crate, seratoField("otrk", seratoField("ptrk", seratoText(strings.TrimPrefix(path, "/"))))...)
+	}
+	_, err := w.Write(crate)
+	return err
+}
+
+func seratoField(tag string, data []byte) []byte {
+	field := make([]byte, 8, 8+len(data))
+	copy(field, tag)
+	binary.BigEndian.PutUint32(field[4:], uint32(len(data)))
+	return append(field, data...)
+}
+
+func seratoText(text string) []byte { //UTF-16 big endian without a byte order mark
+	units := utf16.Encode([]rune(text))
+	data := make([]byte, 2*len(units))
+	for i, unit := range units {
+		binary.BigEndian.PutUint16(data[2*i:], unit)
+	}
+	return data
+}
+
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// deezerConfig holds the Deezer app used to sign in, from
+// developers.deezer.com, and optionally an access token already obtained
+// elsewhere, which skips the sign in. The URLs can point at a stand-in
+// server.
+type deezerConfig struct {
+	AppID       string `json:"appId"`
+	Secret      string `json:"secret"`
+	AccessToken string `json:"accessToken"`
+	BaseURL     string `json:"baseUrl"`
+	AuthURL     string `json:"authUrl"`
+}
+
+const (
+	deezerDefaultURL     = "https://api.deezer.com"
+	deezerDefaultAuthURL = "https://connect.deezer.com"
+	deezerPermissions    = "basic_access,manage_library,offline_access"
+	deezerAddLimit       = 100 //tracks added to a playlist in one request
+)
+
+// Deezer reads playlists, albums, artists' top tracks and the user's
+// favourite tracks from Deezer, and writes new playlists or favourites.
+type Deezer struct {
+	ID   string
+	Kind refKind
+}
+
+func NewDeezer(ref playlistRef) *Deezer {
+	return &Deezer{ID: ref.ID, Kind: ref.Kind}
+}
+
+type deezerTrack struct {
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	Duration int    `json:"duration"` //seconds
+	ISRC     string `json:"isrc"`
+	Link     string `json:"link"`
+	Artist   struct {
+		Name string `json:"name"`
+	} `json:"artist"`
+	Album struct {
+		Title string `json:"title"`
+	} `json:"album"`
+}
+
+type deezerPlaylist struct {
+	ID          int64  `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Public      bool   `json:"public"`
+	Tracks      int    `json:"nb_tracks"`
+	LovedTracks bool   `json:"is_loved_track"`
+	Creator     struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	} `json:"creator"`
+}
+
+// deezerAPI is a client for the Deezer API, signed in when it has a token.
+type deezerAPI struct {
+	base  string
+	token string
+}
+
+func (D *Deezer) GetTracks() []Track {
+	var api *deezerAPI
+	var path string
+	switch {
+	case D.ID == likedID:
+		api, path = newDeezerAPI(true), "/user/me/tracks"
+	case D.Kind == albumKind:
+		api, path = newDeezerAPI(false), "/album/"+D.ID+"/tracks"
+	case D.Kind == artistKind, D.Kind == discographyKind:
+		api, path = newDeezerAPI(false), "/artist/"+D.ID+"/top"
+	default:
+		api, _ = newDeezerPlaylistAPI(D.ID)
+		path = "/playlist/" + D.ID + "/tracks"
+	}
+	var tracks []Track
+	for _, item := range api.allTracks(path) {
+		if item.ISRC == "" { //lists leave the ISRC out, only the track itself has it
+			api.get("/track/"+strconv.FormatInt(item.ID, 10), nil, &item)
+		}
+		track := Track{
+			ID:       strconv.FormatInt(item.ID, 10),
+			Title:    item.Title,
+			Artist:   item.Artist.Name,
+			Album:    item.Album.Title,
+			ISRC:     item.ISRC,
+			Location: item.Link,
+			Duration: time.Duration(item.Duration) * time.Second,
+		}
+		tracks = append(tracks, track)
+		fmt.Printf("%v, (%v)\n", track.Query(), track.ID)
+	}
+	return tracks
+}
+
+// WritePlaylist creates a new playlist, or adds the tracks to the user's
+// favourites when the reference is to the liked songs.
+func (D *Deezer) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	switch D.ID {
+	case "":
+		return createDeezerPlaylist(name, tracks, matches)
+	case likedID:
+		return favouriteDeezerTracks(tracks, matches)
+	case albumsID:
+		log.Fatalf("Saving albums to a Deezer library is not supported, convert to deezer to make a playlist")
+	}
+	log.Fatalf("Adding to an existing Deezer playlist is not supported")
+	return ConversionResult{}
+}
+
+// newDeezerAPI returns a client for the Deezer API, signing in first when
+// signIn is set. Public playlists, albums and searches need no sign in.
+func newDeezerAPI(signIn bool) *deezerAPI {
+	api := &deezerAPI{base: strings.TrimSuffix(settings.Deezer.BaseURL, "/")}
+	if api.base == "" {
+		api.base = deezerDefaultURL
+	}
+	if signIn {
+		api.token = deezerToken()
+	}
+	return api
+}
+
+// newDeezerPlaylistAPI returns a client that can read a playlist along
+// with the playlist, signing in unless it is public, as private playlists
+// are only visible to their owner.
+func newDeezerPlaylistAPI(id string) (*deezerAPI, deezerPlaylist) {
+	api := newDeezerAPI(false)
+	var playlist deezerPlaylist
+	if err := api.request("GET", "/playlist/"+id, nil, &playlist); err == nil && playlist.Public {
+		return api, playlist
+	}
+	api.token = deezerToken()
+	api.get("/playlist/"+id, nil, &playlist)
+	return api, playlist
+}
+
+// deezerToken signs in with Deezer's own take on OAuth, whose token
+// endpoint takes the app ID and secret as query parameters and never
+// expires tokens granted offline_access.
+func deezerToken() string {
+	config := settings.Deezer
+	if config.AccessToken != "" {
+		return config.AccessToken
+	}
+	if config.AppID == "" || config.Secret == "" {
+		log.Fatalf("Set deezer.appId and deezer.secret in %s", configFile)
+	}
+	cacheFile, err := tokenCacheFile("deezer")
+	if err != nil {
+		log.Fatalf("Unable to get path to cached credential file. %v", err)
+	}
+	if tok, err := tokenFromFile(cacheFile); err == nil && tok.AccessToken != "" {
+		return tok.AccessToken
+	}
+	authBase := strings.TrimSuffix(config.AuthURL, "/")
+	if authBase == "" {
+		authBase = deezerDefaultAuthURL
+	}
+	redirectURL := "http://localhost:8080"
+	authURL := authBase + "/oauth/auth.php?" + url.Values{
+		"app_id":       {config.AppID},
+		"redirect_uri": {redirectURL},
+		"perms":        {deezerPermissions},
+	}.Encode()
+	codeCh, err := startWebServer()
+	handleError(err, "Unable to start a web server")
+	if err := openURL(authURL); err != nil {
+		fmt.Println("Open this link in your browser to sign in to Deezer:")
+	}
+	fmt.Println(authURL)
+	code := <-codeCh
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+	}
+	tokenURL := authBase + "/oauth/access_token.php?" + url.Values{
+		"app_id": {config.AppID},
+		"secret": {config.Secret},
+		"code":   {code},
+		"output": {"json"},
+	}.Encode()
+	err = apiRequest(http.DefaultClient, "GET", tokenURL, nil, nil, &response)
+	handleError(err, "Unable to retrieve Deezer token")
+	if response.AccessToken == "" {
+		log.Fatalf("Deezer did not grant a token")
+	}
+	saveToken(cacheFile, &oauth2.Token{AccessToken: response.AccessToken})
+	return response.AccessToken
+}
+
+// request calls the API, which reports errors as an error object with a
+// 200 status rather than an error status.
+func (api *deezerAPI) request(method string, path string, params url.Values, out interface{}) error {
+	if params == nil {
+		params = url.Values{}
+	}
+	if api.token != "" {
+		params.Set("access_token", api.token)
+	}
+	target := path
+	if !strings.HasPrefix(path, "http") {
+		target = api.base + path
+	}
+	if strings.Contains(target, "?") {
+		target += "&" + params.Encode()
+	} else {
+		target += "?" + params.Encode()
+	}
+	var raw json.RawMessage
+	if err := apiRequest(http.DefaultClient, method, target, nil, nil, &raw); err != nil {
+		return err
+	}
+	var failure struct {
+		Error *struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+			Code    int    `json:"code"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(raw, &failure) == nil && failure.Error != nil {
+		return fmt.Errorf("deezer %s: %s", failure.Error.Type, failure.Error.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, out)
+}
+
+func (api *deezerAPI) get(path string, params url.Values, out interface{}) {
+	handleError(api.request("GET", path, params, out), "Deezer request failed")
+}
+
+func (api *deezerAPI) allTracks(path string) []deezerTrack { //follows the next links of a paged track list
+	var tracks []deezerTrack
+	params := url.Values{"limit": {"100"}}
+	for path != "" {
+		var page struct {
+			Data []deezerTrack `json:"data"`
+			Next string        `json:"next"`
+		}
+		api.get(path, params, &page)
+		tracks = append(tracks, page.Data...)
+		path, params = page.Next, nil //the next link already has the paging parameters
+	}
+	return tracks
+}
+
+// deezerQuery builds an advanced search for a track. Titles of videos,
+// which hold the artist as well, are searched for as they are.
+func deezerQuery(track Track) string {
+	if track.Artist == "" {
+		return cleanVideoTitle(track.Title)
+	}
+	quote := func(s string) string { return `"` + strings.ReplaceAll(s, `"`, "") + `"` }
+	return "artist:" + quote(track.Artist) + " track:" + quote(track.Title)
+}
+
+// searchDeezerTracks finds the Deezer track for each song, by ISRC when it
+// has one, otherwise with an advanced search for the artist and title.
+func searchDeezerTracks(api *deezerAPI, playlist []Track, result *ConversionResult, matches *matchCache) []string {
+	return matches.find(DEEZER, playlist, result, func(track Track) string {
+		var found deezerTrack
+		if track.ISRC != "" && api.request("GET", "/track/isrc:"+url.PathEscape(track.ISRC), nil, &found) == nil && found.ID != 0 {
+			return strconv.FormatInt(found.ID, 10)
+		}
+		var search struct {
+			Data []deezerTrack `json:"data"`
+		}
+		api.get("/search/track", url.Values{"q": {deezerQuery(track)}, "limit": {"1"}}, &search)
+		if len(search.Data) == 0 {
+			return ""
+		}
+		return strconv.FormatInt(search.Data[0].ID, 10)
+	})
+}
+
+func createDeezerPlaylist(name string, playlist []Track, matches *matchCache) ConversionResult { //creates a Deezer playlist called name with the tracks found
+	api := newDeezerAPI(true)
+	result := ConversionResult{Name: name, Destination: "deezer", Total: len(playlist)}
+	trackIds := searchDeezerTracks(api, playlist, &result, matches)
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	err := api.request("POST", "/user/me/playlists", url.Values{"title": {name}}, &created)
+	handleError(err, "Unable to create Deezer playlist")
+	playlistId := strconv.FormatInt(created.ID, 10)
+	addInBatches(trackIds, deezerAddLimit, &result, func(batch []string) {
+		err := api.request("POST", "/playlist/"+playlistId+"/tracks", url.Values{"songs": {strings.Join(batch, ",")}}, nil)
+		handleError(err, "Unable to add tracks to Deezer playlist "+playlistId)
+	})
+	fmt.Println("Added songs to Deezer")
+	return result
+}
+
+func favouriteDeezerTracks(playlist []Track, matches *matchCache) ConversionResult { //adds the tracks to the user's favourites instead of a playlist
+	api := newDeezerAPI(true)
+	result := ConversionResult{Name: "Favourite tracks", Destination: "deezer:liked", Total: len(playlist)}
+	for _, id := range searchDeezerTracks(api, playlist, &result, matches) {
+		err := api.request("POST", "/user/me/tracks", url.Values{"track_id": {id}}, nil)
+		handleError(err, "Unable to add track "+id+" to Deezer favourites")
+		result.Added++
+	}
+	fmt.Println("Added songs to Deezer favourites")
+	return result
+}
+
+func deezerSummary(item deezerPlaylist, userId int64) playlistSummary {
+	return playlistSummary{
+		ID:          strconv.FormatInt(item.ID, 10),
+		Name:        item.Title,
+		Description: item.Description,
+		Owner:       item.Creator.Name,
+		Owned:       item.Creator.ID == userId,
+		Public:      item.Public,
+		Tracks:      item.Tracks,
+	}
+}
+
+func listDeezerPlaylists(includeFollowed bool) []playlistSummary { //lists the user's playlists, the ones they created unless includeFollowed is set
+	api := newDeezerAPI(true)
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	api.get("/user/me", nil, &user)
+	var playlists []playlistSummary
+	path, params := "/user/me/playlists", url.Values{"limit": {"100"}}
+	for path != "" {
+		var page struct {
+			Data []deezerPlaylist `json:"data"`
+			Next string           `json:"next"`
+		}
+		api.get(path, params, &page)
+		for _, item := range page.Data {
+			summary := deezerSummary(item, user.ID)
+			if item.LovedTracks { //the favourites are converted with deezer:liked
+				continue
+			}
+			if summary.Owned || includeFollowed {
+				playlists = append(playlists, summary)
+			}
+		}
+		path, params = page.Next, nil
+	}
+	return playlists
+}
+
+func deezerPlaylistSummary(ref playlistRef) playlistSummary { //gets the name and details of whatever a reference points at
+	api := newDeezerAPI(false)
+	switch {
+	case ref.ID == likedID:
+		return playlistSummary{ID: likedID, Name: "Favourite tracks", Owned: true}
+	case ref.Kind == albumKind:
+		var album struct {
+			Title  string `json:"title"`
+			Tracks int    `json:"nb_tracks"`
+			Artist struct {
+				Name string `json:"name"`
+			} `json:"artist"`
+		}
+		api.get("/album/"+ref.ID, nil, &album)
+		return playlistSummary{ID: ref.ID, Name: album.Title, Owner: album.Artist.Name, Tracks: album.Tracks}
+	case ref.Kind == artistKind, ref.Kind == discographyKind:
+		var artist struct {
+			Name string `json:"name"`
+		}
+		api.get("/artist/"+ref.ID, nil, &artist)
+		return playlistSummary{ID: ref.ID, Name: artist.Name, Owner: artist.Name}
+	}
+	_, playlist := newDeezerPlaylistAPI(ref.ID)
+	return deezerSummary(playlist, -1)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeDeezer is a stand-in for the Deezer API with a public playlist 1, a
+// private playlist 2 that needs the token, and one track found by ISRC and
+// one by an advanced search. Like Deezer it reports errors with a 200.
+type fakeDeezer struct {
+	url      string
+	searches []string   //the q of each search
+	created  string     //title of the playlist created
+	added    [][]string //track IDs of each request adding to it
+}
+
+func (f *fakeDeezer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	signedIn := query.Get("access_token") == "token"
+	failure := func(kind, message string) interface{} {
+		return map[string]interface{}{"error": map[string]interface{}{"type": kind, "message": message, "code": 800}}
+	}
+	track := func(id int64, title string, isrc string) map[string]interface{} {
+		return map[string]interface{}{"id": id, "title": title, "duration": 200, "isrc": isrc, "artist": map[string]string{"name": "Artist"}, "album": map[string]string{"title": "Album"}}
+	}
+	var response interface{}
+	switch r.Method + " " + r.URL.Path {
+	case "GET /playlist/1":
+		response = map[string]interface{}{"id": 1, "title": "Public", "public": true, "nb_tracks": 1}
+	case "GET /playlist/2":
+		response = failure("OAuthException", "An active access token must be used")
+		if signedIn {
+			response = map[string]interface{}{"id": 2, "title": "Private", "public": false, "nb_tracks": 2}
+		}
+	case "GET /playlist/1/tracks":
+		response = map[string]interface{}{"data": []interface{}{track(10, "Public Song", "GBAAA0000010")}}
+	case "GET /playlist/2/tracks":
+		switch {
+		case !signedIn:
+			response = failure("OAuthException", "An active access token must be used")
+		case query.Get("index") == "":
+			response = map[string]interface{}{"data": []interface{}{track(11, "First", "")}, "next": f.url + "/playlist/2/tracks?index=1&limit=100"}
+		default:
+			response = map[string]interface{}{"data": []interface{}{track(12, "Second", "GBAAA0000012")}}
+		}
+	case "GET /track/11": //lists leave the ISRC out
+		response = track(11, "First", "GBAAA0000011")
+	case "GET /track/isrc:GBAAA0000001":
+		response = track(1001, "By ISRC", "GBAAA0000001")
+	case "GET /search/track":
+		f.searches = append(f.searches, query.Get("q"))
+		data := []interface{}{}
+		if query.Get("q") == `artist:"Daft Punk" track:"One More Time"` {
+			data = append(data, track(2002, "One More Time", ""))
+		}
+		response = map[string]interface{}{"data": data}
+	case "POST /user/me/playlists":
+		if !signedIn {
+			response = failure("OAuthException", "An active access token must be used")
+			break
+		}
+		f.created = query.Get("title")
+		response = map[string]interface{}{"id": 99}
+	case "POST /playlist/99/tracks":
+		f.added = append(f.added, strings.Split(query.Get("songs"), ","))
+		response = true
+	default:
+		if strings.HasPrefix(r.URL.Path, "/track/isrc:") {
+			response = failure("DataException", "no data")
+			break
+		}
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func newFakeDeezer(t *testing.T) *fakeDeezer {
+	fake := &fakeDeezer{}
+	fake.url = standIn(t, fake)
+	settings.Deezer = deezerConfig{AccessToken: "token", BaseURL: fake.url}
+	return fake
+}
+
+func TestDeezerQuery(t *testing.T) {
+	tests := []struct {
+		track Track
+		want  string
+	}{
+		{Track{Title: "One More Time", Artist: "Daft Punk"}, `artist:"Daft Punk" track:"One More Time"`},
+		{Track{Title: `The "Real" Slim Shady`, Artist: "Eminem"}, `artist:"Eminem" track:"The Real Slim Shady"`},
+		{Track{Title: "Daft Punk - One More Time (Official Video)"}, cleanVideoTitle("Daft Punk - One More Time (Official Video)")},
+	}
+	for _, test := range tests {
+		if got := deezerQuery(test.track); got != test.want {
+			t.Errorf("deezerQuery(%+v) = %q, want %q", test.track, got, test.want)
+		}
+	}
+}
+
+func TestDeezerGetTracks(t *testing.T) {
+	newFakeDeezer(t)
+	public := NewDeezer(playlistRef{Service: DEEZER, ID: "1"}).GetTracks()
+	if len(public) != 1 || public[0].Title != "Public Song" {
+		t.Errorf("public playlist read as %+v", public)
+	}
+	private := NewDeezer(playlistRef{Service: DEEZER, ID: "2"}).GetTracks()
+	if len(private) != 2 {
+		t.Fatalf("read %d tracks of the private playlist, want 2 across both pages", len(private))
+	}
+	if private[0].ISRC != "GBAAA0000011" || private[0].ID != "11" {
+		t.Errorf("first track %+v was not completed with its ISRC", private[0])
+	}
+	if summary := deezerPlaylistSummary(playlistRef{Service: DEEZER, ID: "2"}); summary.Name != "Private" || summary.Tracks != 2 {
+		t.Errorf("private playlist summary = %+v", summary)
+	}
+}
+
+func TestDeezerCreatePlaylist(t *testing.T) {
+	fake := newFakeDeezer(t)
+	playlist := []Track{
+		{Title: "Anything", Artist: "Artist", ISRC: "GBAAA0000001"},
+		{Title: "One More Time", Artist: "Daft Punk", ISRC: "GBAAA0000009"},
+		{Title: "Missing", Artist: "Nobody"},
+	}
+	for i := 0; i < deezerAddLimit; i++ {
+		playlist = append(playlist, Track{Title: "One More Time", Artist: "Daft Punk", Album: fmt.Sprint(i)})
+	}
+	result := NewDeezer(playlistRef{Service: DEEZER}).WritePlaylist("Road Trip", playlist, newMatchCache())
+	if fake.created != "Road Trip" {
+		t.Errorf("created %q, want Road Trip", fake.created)
+	}
+	if want := []string{`artist:"Daft Punk" track:"One More Time"`, `artist:"Nobody" track:"Missing"`, `artist:"Daft Punk" track:"One More Time"`}; !reflect.DeepEqual(fake.searches, want) {
+		t.Errorf("searched for %q, want %q, each track once and once more without its ISRC", fake.searches, want)
+	}
+	if sizes := batchSizes(fake.added); !reflect.DeepEqual(sizes, []int{deezerAddLimit, 2}) || fake.added[0][0] != "1001" || fake.added[0][1] != "2002" {
+		t.Errorf("added batches of %v starting %v", sizes, fake.added[0][:2])
+	}
+	if result.Added != deezerAddLimit+2 || len(result.NotFound) != 1 {
+		t.Errorf("result = %d added, %d not found", result.Added, len(result.NotFound))
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ITunesLibrary reads playlists from the Library.xml that iTunes and the
+// Apple Music app export, and writes playlists in the same plist format for
+// File > Library > Import Playlist. A playlist is picked from the library
+// with a # after the path, e.g. itunes:Library.xml#Road Trip.
+//
+// Apple Music only imports entries for files on disk, so tracks without a
+// local file are left out of written playlists.
+type ITunesLibrary struct {
+	Path     string
+	Playlist string //name or persistent ID of the playlist to read
+}
+
+func NewITunesLibrary(ref playlistRef) *ITunesLibrary {
+	path, playlist := ref.ID, ""
+	if i := strings.LastIndex(path, "#"); i >= 0 {
+		path, playlist = path[:i], path[i+1:]
+	}
+	return &ITunesLibrary{Path: path, Playlist: playlist}
+}
+
+func (I *ITunesLibrary) GetTracks() []Track {
+	f, err := os.Open(I.Path)
+	if err != nil {
+		log.Fatalf("Unable to read iTunes library: %v", err)
+	}
+	defer f.Close()
+	root, err := decodePlist(f)
+	handleError(err, "Invalid iTunes library")
+	library, _ := root.(map[string]interface{})
+	trackDicts, _ := library["Tracks"].(map[string]interface{})
+	playlist := findITunesPlaylist(library, I.Playlist)
+
+	var tracks []Track
+	items, _ := playlist["Playlist Items"].([]interface{})
+	for _, item := range items {
+		entry, _ := item.(map[string]interface{})
+		id, _ := entry["Track ID"].(int64)
+		if dict, ok := trackDicts[strconv.FormatInt(id, 10)].(map[string]interface{}); ok {
+			tracks = append(tracks, trackFromITunes(dict))
+		}
+	}
+	return tracks
+}
+
+func (I *ITunesLibrary) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	path := I.Path
+	if path == "" {
+		path = playlistFileName(name, ".xml")
+	}
+	result := ConversionResult{Name: name, Destination: "itunes:" + path, Total: len(tracks)}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Unable to create iTunes playlist: %v", err)
+	}
+	defer f.Close()
+
+	var ids []int
+	p := newPlistWriter(f)
+	p.open("dict")
+	p.key("Major Version").integer(1)
+	p.key("Minor Version").integer(1)
+	p.key("Date").date(time.Now())
+	p.key("Tracks").open("dict")
+	for _, track := range tracks {
+		location := localFileURL(track.Location)
+		if location == "" {
+			result.NotFound = append(result.NotFound, track.Query())
+			continue
+		}
+		id := len(ids) + 1
+		ids = append(ids, id)
+		p.key(strconv.Itoa(id)).open("dict")
+		p.key("Track ID").integer(int64(id))
+		p.optionalString("Name", track.Title)
+		p.optionalString("Artist", track.Artist)
+		p.optionalString("Album", track.Album)
+		if track.Duration > 0 {
+			p.key("Total Time").integer(track.Duration.Milliseconds())
+		}
+		p.key("Persistent ID").string(iTunesPersistentID(track.ID, location))
+		p.key("Track Type").string("File")
+		p.key("Location").string(location)
+		p.close("dict")
+		result.Added++
+	}
+	p.close("dict")
+	p.key("Playlists").open("array")
+	p.open("dict")
+	p.key("Name").string(name)
+	p.key("Playlist ID").integer(1)
+	p.key("Playlist Persistent ID").string(iTunesPersistentID("", name))
+	p.key("All Items").boolean(true)
+	p.key("Playlist Items").open("array")
+	for _, id := range ids {
+		p.open("dict")
+		p.key("Track ID").integer(int64(id))
+		p.close("dict")
+	}
+	p.close("array")
+	p.close("dict")
+	p.close("array")
+	p.close("dict")
+	err = p.finish()
+	handleError(err, "Unable to write iTunes playlist")
+	fmt.Println("Wrote", path)
+	return result
+}
+
+// findITunesPlaylist picks a playlist out of a library by name or persistent
+// ID. Without either the library must have only one playlist of the user's
+// own, otherwise the choices are listed.
+func findITunesPlaylist(library map[string]interface{}, want string) map[string]interface{} {
+	playlists, _ := library["Playlists"].([]interface{})
+	var own []map[string]interface{}
+	for _, p := range playlists {
+		playlist, _ := p.(map[string]interface{})
+		name, _ := playlist["Name"].(string)
+		id, _ := playlist["Playlist Persistent ID"].(string)
+		if want != "" && (strings.EqualFold(name, want) || strings.EqualFold(id, want)) {
+			return playlist
+		}
+		_, master := playlist["Master"]
+		_, builtIn := playlist["Distinguished Kind"] //Music, Movies, Podcasts and the like
+		_, folder := playlist["Folder"]
+		if !master && !builtIn && !folder {
+			own = append(own, playlist)
+		}
+	}
+	if want == "" && len(own) == 1 {
+		return own[0]
+	}
+	var names []string
+	for _, playlist := range own {
+		name, _ := playlist["Name"].(string)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want != "" {
+		log.Fatalf("No playlist called %q in the library, it has: %s", want, strings.Join(names, ", "))
+	}
+	log.Fatalf("Pick a playlist from the library with <path>#<name>, it has: %s", strings.Join(names, ", "))
+	return nil
+}
+
+func trackFromITunes(dict map[string]interface{}) Track {
+	var track Track
+	track.Title, _ = dict["Name"].(string)
+	track.Artist, _ = dict["Artist"].(string)
+	track.Album, _ = dict["Album"].(string)
+	track.ID, _ = dict["Persistent ID"].(string)
+	if ms, ok := dict["Total Time"].(int64); ok {
+		track.Duration = time.Duration(ms) * time.Millisecond
+	}
+	if location, ok := dict["Location"].(string); ok {
+		track.Location = m3uLocation(location, "")
+	}
+	return track
+}
+
+func localFileURL(location string) string { //gives the file:// URL of a local file, or nothing for anything else
+	if location == "" || (strings.Contains(location, "://") && !strings.HasPrefix(location, "file://")) {
+		return ""
+	}
+	if strings.HasPrefix(location, "file://") {
+		return location
+	}
+	path, err := filepath.Abs(location)
+	if err != nil {
+		return ""
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") { //a Windows drive letter
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Host: "localhost", Path: path}).String()
+}
+
+func iTunesPersistentID(id string, key string) string { //keeps an iTunes persistent ID, or makes a stable one up from key
+	if _, err := strconv.ParseUint(id, 16, 64); err == nil && len(id) == 16 {
+		return strings.ToUpper(id)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return fmt.Sprintf("%016X", h.Sum64())
+}
+
+// decodePlist reads an XML property list into maps, slices, strings,
+// int64s, float64s, bools and times.
+func decodePlist(r io.Reader) (interface{}, error) {
+	d := xml.NewDecoder(r)
+	d.Strict = false //Library.xml has a DOCTYPE the decoder need not follow
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local != "plist" {
+			return decodePlistValue(d, start)
+		}
+	}
+}
+
+func decodePlistValue(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := make(map[string]interface{})
+		var key string
+		for {
+			token, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					if err := d.DecodeElement(&key, &t); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				value, err := decodePlistValue(d, t)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = value
+			case xml.EndElement:
+				return dict, nil
+			}
+		}
+	case "array":
+		var array []interface{}
+		for {
+			token, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				value, err := decodePlistValue(d, t)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			case xml.EndElement:
+				return array, nil
+			}
+		}
+	}
+	var text string
+	if err := d.DecodeElement(&text, &start); err != nil {
+		return nil, err
+	}
+	text = strings.TrimSpace(text)
+	switch start.Name.Local {
+	case "integer":
+		return strconv.ParseInt(text, 10, 64)
+	case "real":
+		return strconv.ParseFloat(text, 64)
+	case "true", "false":
+		return start.Name.Local == "true", nil
+	case "date":
+		return time.Parse(time.RFC3339, text)
+	}
+	return text, nil //string and data
+}
+
+// plistWriter writes an XML property list. Errors are kept until finish.
+type plistWriter struct {
+	w     *bufio.Writer
+	depth int
+}
+
+func newPlistWriter(w io.Writer) *plistWriter {
+	p := &plistWriter{w: bufio.NewWriter(w)}
+	p.w.WriteString(xml.Header)
+	p.w.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple Computer//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	p.w.WriteString(`<plist version="1.0">` + "\n")
+	return p
+}
+
+func (p *plistWriter) element(tag string, text string) *plistWriter {
+	p.w.WriteString(strings.Repeat("\t", p.depth) + "<" + tag + ">")
+	xml.EscapeText(p.w, []byte(text))
+	p.w.WriteString("</" + tag + ">\n")
+	return p
+}
+
+func (p *plistWriter) open(tag string) *plistWriter {
+	p.w.WriteString(strings.Repeat("\t", p.depth) + "<" + tag + ">\n")
+	p.depth++
+	return p
+}
+
+func (p *plistWriter) close(tag string) {
+	p.depth--
+	p.w.WriteString(strings.Repeat("\t", p.depth) + "</" + tag + ">\n")
+}
+
+func (p *plistWriter) key(key string) *plistWriter { return p.element("key", key) }
+func (p *plistWriter) string(value string)         { p.element("string", value) }
+func (p *plistWriter) integer(value int64)         { p.element("integer", strconv.FormatInt(value, 10)) }
+func (p *plistWriter) date(value time.Time)        { p.element("date", value.UTC().Format(time.RFC3339)) }
+
+func (p *plistWriter) boolean(value bool) {
+	p.w.WriteString(strings.Repeat("\t", p.depth) + "<" + strconv.FormatBool(value) + "/>\n")
+}
+
+func (p *plistWriter) optionalString(key string, value string) { //leaves out empty values, as iTunes does
+	if value != "" {
+		p.key(key).string(value)
+	}
+}
+
+func (p *plistWriter) finish() error {
+	p.w.WriteString("</plist>\n")
+	return p.w.Flush()
+}
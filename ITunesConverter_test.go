@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestITunesLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{"file:///Users/me/Music/iTunes/Daft%20Punk/One%20More%20Time.mp3", filepath.FromSlash("/Users/me/Music/iTunes/Daft Punk/One More Time.mp3")},
+		{"file://localhost/Users/me/Music/AC%23DC/Back%20in%20Black.m4a", filepath.FromSlash("/Users/me/Music/AC#DC/Back in Black.m4a")},
+		{"file://localhost/C:/Users/me/Music/iTunes/Daft%20Punk/One%20More%20Time.mp3", filepath.FromSlash("C:/Users/me/Music/iTunes/Daft Punk/One More Time.mp3")},
+		{"file:///D:/M%C3%BAsica/canci%C3%B3n.mp3", filepath.FromSlash("D:/Música/canción.mp3")},
+		{"https://example.com/song.mp3", "https://example.com/song.mp3"},
+	}
+	for _, test := range tests {
+		track := trackFromITunes(map[string]interface{}{"Name": "Song", "Location": test.location})
+		if track.Location != test.want {
+			t.Errorf("Location %q read as %q, want %q", test.location, track.Location, test.want)
+		}
+	}
+}
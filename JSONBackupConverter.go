@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// backupVersion is the version of the backup format written. It goes up
+// whenever a change would stop an older converter reading the files.
+const backupVersion = 1
+
+// backupFile is a snapshot of one or more playlists, with everything needed
+// to recreate them even after the originals are gone.
+type backupFile struct {
+	Version   int              `json:"version"`
+	Created   time.Time        `json:"created"`
+	Playlists []backupPlaylist `json:"playlists"`
+}
+
+type backupPlaylist struct {
+	Service     string        `json:"service,omitempty"` //where the playlist was backed up from
+	Kind        string        `json:"kind,omitempty"`
+	ID          string        `json:"id,omitempty"`
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Owner       string        `json:"owner,omitempty"`
+	Public      bool          `json:"public"`
+	Tracks      []backupTrack `json:"tracks"`
+}
+
+type backupTrack struct {
+	Title         string `json:"title"`
+	Artist        string `json:"artist,omitempty"`
+	Album         string `json:"album,omitempty"`
+	ISRC          string `json:"isrc,omitempty"`
+	MusicBrainzID string `json:"musicbrainzId,omitempty"`
+	DurationMs    int64  `json:"durationMs,omitempty"`
+	ID            string `json:"id,omitempty"` //ID on the service the playlist was backed up from
+	Location      string `json:"location,omitempty"`
+}
+
+var refKindNames = []string{"playlist", "album", "artist", "discography", "mix"}
+
+// JSONBackup reads and writes backup files as a playlist source or
+// destination. Files holding several playlists, from the backup command,
+// are read with the restore command instead.
+type JSONBackup struct {
+	Path string
+}
+
+func NewJSONBackup(ref playlistRef) *JSONBackup {
+	return &JSONBackup{Path: ref.ID}
+}
+
+func (J *JSONBackup) GetTracks() []Track {
+	backup := readBackup(J.Path)
+	if len(backup.Playlists) != 1 {
+		log.Fatalf("%s holds %d playlists, use the restore command to convert them", J.Path, len(backup.Playlists))
+	}
+	return backup.Playlists[0].tracks()
+}
+
+// WritePlaylist backs up the tracks as a single playlist. Without a path
+// the file is named after the playlist.
+func (J *JSONBackup) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	path := J.Path
+	if path == "" {
+		path = playlistFileName(name, ".json")
+	}
+	writeBackup(path, []backupPlaylist{newBackupPlaylist(playlistSummary{Name: name}, playlistRef{}, tracks)})
+	return ConversionResult{Name: name, Destination: "backup:" + path, Total: len(tracks), Added: len(tracks)}
+}
+
+// newBackupPlaylist snapshots a playlist. ref is where it was read from and
+// is left out of the backup when its service is not set.
+func newBackupPlaylist(summary playlistSummary, ref playlistRef, tracks []Track) backupPlaylist {
+	playlist := backupPlaylist{
+		Name:        summary.Name,
+		Description: summary.Description,
+		Owner:       summary.Owner,
+		Public:      summary.Public,
+		Tracks:      []backupTrack{},
+	}
+	if ref != (playlistRef{}) {
+		playlist.Service = ref.Service.String()
+		playlist.Kind = refKindNames[ref.Kind]
+		playlist.ID = ref.ID
+	}
+	for _, track := range tracks {
+		playlist.Tracks = append(playlist.Tracks, backupTrack{
+			Title:         track.Title,
+			Artist:        track.Artist,
+			Album:         track.Album,
+			ISRC:          track.ISRC,
+			MusicBrainzID: track.MusicBrainzID,
+			DurationMs:    track.Duration.Milliseconds(),
+			ID:            track.ID,
+			Location:      track.Location,
+		})
+	}
+	return playlist
+}
+
+func (p backupPlaylist) tracks() []Track {
+	var tracks []Track
+	for _, item := range p.Tracks {
+		tracks = append(tracks, Track{
+			Title:         item.Title,
+			Artist:        item.Artist,
+			Album:         item.Album,
+			ISRC:          item.ISRC,
+			MusicBrainzID: item.MusicBrainzID,
+			Duration:      time.Duration(item.DurationMs) * time.Millisecond,
+			ID:            item.ID,
+			Location:      item.Location,
+		})
+	}
+	return tracks
+}
+
+func writeBackup(path string, playlists []backupPlaylist) {
+	backup := backupFile{Version: backupVersion, Created: time.Now().UTC(), Playlists: playlists}
+	data, err := json.MarshalIndent(backup, "", "  ")
+	handleError(err, "Unable to encode backup")
+	err = os.WriteFile(path, append(data, '\n'), 0644)
+	handleError(err, "Unable to write backup")
+	fmt.Println("Wrote", path)
+}
+
+func readBackup(path string) backupFile {
+	var backup backupFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Unable to read backup: %v", err)
+	}
+	err = json.Unmarshal(data, &backup)
+	handleError(err, "Unable to parse backup")
+	if backup.Version > backupVersion {
+		log.Fatalf("%s is a version %d backup, this converter only reads up to version %d", path, backup.Version, backupVersion)
+	}
+	return backup
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// jellyfinConfig holds the server and account of a Jellyfin server. Either
+// the password is given to sign in, or an API key from the dashboard along
+// with the username whose playlists are used.
+type jellyfinConfig struct {
+	URL      string `json:"url"`
+	APIKey   string `json:"apiKey"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+const (
+	jellyfinClient        = `MediaBrowser Client="playlist-converter", Device="playlist-converter", DeviceId="playlist-converter", Version="1.0"`
+	jellyfinSearchResults = 20
+	jellyfinBatchSize     = 100 //items added to a playlist per request
+	jellyfinFields        = "ProviderIds,ChildCount,Overview"
+)
+
+// Jellyfin reads and writes the music playlists and favourite songs on a
+// Jellyfin server, see https://api.jellyfin.org
+type Jellyfin struct {
+	ID string
+}
+
+func NewJellyfin(ref playlistRef) *Jellyfin {
+	return &Jellyfin{ID: ref.ID}
+}
+
+type jellyfinItem struct {
+	ID           string            `json:"Id"`
+	Name         string            `json:"Name"`
+	Overview     string            `json:"Overview"`
+	Artists      []string          `json:"Artists"`
+	AlbumArtist  string            `json:"AlbumArtist"`
+	Album        string            `json:"Album"`
+	RunTimeTicks int64             `json:"RunTimeTicks"` //100 nanosecond ticks
+	ChildCount   int               `json:"ChildCount"`
+	ProviderIds  map[string]string `json:"ProviderIds"`
+}
+
+type jellyfinItems struct {
+	Items            []jellyfinItem `json:"Items"`
+	TotalRecordCount int            `json:"TotalRecordCount"`
+}
+
+// jellyfinAPI calls a server as the configured user.
+type jellyfinAPI struct {
+	client *http.Client
+	base   string
+	userId string
+}
+
+func (J *Jellyfin) GetTracks() []Track {
+	api := newJellyfinAPI()
+	var items []jellyfinItem
+	if J.ID == likedID {
+		items = api.items("/Users/"+api.userId+"/Items", url.Values{"Filters": {"IsFavorite"}, "IncludeItemTypes": {"Audio"}, "Recursive": {"true"}})
+	} else {
+		items = api.items("/Playlists/"+url.PathEscape(J.ID)+"/Items", url.Values{"UserId": {api.userId}})
+	}
+	var tracks []Track
+	for _, item := range items {
+		track := jellyfinTrack(item)
+		tracks = append(tracks, track)
+		fmt.Printf("%v, (%v)\n", track.Query(), track.ID)
+	}
+	return tracks
+}
+
+// WritePlaylist creates a new playlist, or marks the songs as favourites
+// when the reference is to the liked songs.
+func (J *Jellyfin) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	switch J.ID {
+	case "":
+		return createJellyfinPlaylist(name, tracks, matches)
+	case likedID:
+		return favouriteJellyfinSongs(tracks, matches)
+	case albumsID:
+		log.Fatalf("Jellyfin has no saved albums, convert to jellyfin to make a playlist of the album")
+	}
+	log.Fatalf("Adding to an existing Jellyfin playlist is not supported")
+	return ConversionResult{}
+}
+
+// jellyfinTrack builds a Track from a library item. Jellyfin keeps the
+// MusicBrainz recording ID, when the files are tagged with one, among the
+// provider IDs.
+func jellyfinTrack(item jellyfinItem) Track {
+	track := Track{
+		Title:         item.Name,
+		Artist:        strings.Join(item.Artists, ", "),
+		Album:         item.Album,
+		MusicBrainzID: item.ProviderIds["MusicBrainzRecording"],
+		Duration:      time.Duration(item.RunTimeTicks) * 100,
+		ID:            item.ID,
+	}
+	if track.Artist == "" {
+		track.Artist = item.AlbumArtist
+	}
+	return track
+}
+
+func newJellyfinAPI() *jellyfinAPI {
+	config := settings.Jellyfin
+	if config.URL == "" || config.Username == "" || (config.APIKey == "" && config.Password == "") {
+		log.Fatalf("Set jellyfin.url, jellyfin.username and either jellyfin.password or jellyfin.apiKey in %s", configFile)
+	}
+	api := &jellyfinAPI{base: strings.TrimSuffix(config.URL, "/")}
+	token := config.APIKey
+	if token == "" {
+		var session struct {
+			AccessToken string `json:"AccessToken"`
+			User        struct {
+				ID string `json:"Id"`
+			} `json:"User"`
+		}
+		err := apiRequest(http.DefaultClient, "POST", api.base+"/Users/AuthenticateByName", http.Header{"Authorization": {jellyfinClient}},
+			map[string]string{"Username": config.Username, "Pw": config.Password}, &session)
+		handleError(err, "Unable to sign in to Jellyfin")
+		token, api.userId = session.AccessToken, session.User.ID
+	}
+	api.client = &http.Client{Transport: &headerTransport{header: http.Header{"Authorization": {jellyfinClient + `, Token="` + token + `"`}}}}
+	if api.userId == "" { //an API key is not tied to a user
+		var users []struct {
+			ID   string `json:"Id"`
+			Name string `json:"Name"`
+		}
+		api.request("GET", "/Users", nil, &users)
+		for _, user := range users {
+			if strings.EqualFold(user.Name, config.Username) {
+				api.userId = user.ID
+			}
+		}
+		if api.userId == "" {
+			log.Fatalf("Jellyfin has no user called %s", config.Username)
+		}
+	}
+	return api
+}
+
+func (api *jellyfinAPI) request(method string, path string, body interface{}, out interface{}) {
+	err := apiRequest(api.client, method, api.base+path, nil, body, out)
+	handleError(err, "Jellyfin request failed")
+}
+
+func (api *jellyfinAPI) items(path string, params url.Values) []jellyfinItem { //pages through a list of items
+	var items []jellyfinItem
+	params.Set("Fields", jellyfinFields)
+	params.Set("Limit", "200")
+	for {
+		params.Set("StartIndex", fmt.Sprint(len(items)))
+		var page jellyfinItems
+		api.request("GET", path+"?"+params.Encode(), nil, &page)
+		items = append(items, page.Items...)
+		if len(page.Items) == 0 || len(items) >= page.TotalRecordCount {
+			return items
+		}
+	}
+}
+
+// searchJellyfinSongs finds each song in the user's library with a search
+// of the audio items by name.
+func searchJellyfinSongs(api *jellyfinAPI, playlist []Track, result *ConversionResult, matches *matchCache) []string {
+	return matches.find(JELLYFIN, playlist, result, func(track Track) string {
+		found, _ := matchSearch(track, func(title string) []Track {
+			var page jellyfinItems
+			api.request("GET", "/Users/"+api.userId+"/Items?"+url.Values{
+				"searchTerm":       {title},
+				"IncludeItemTypes": {"Audio"},
+				"Recursive":        {"true"},
+				"Fields":           {jellyfinFields},
+				"Limit":            {fmt.Sprint(jellyfinSearchResults)},
+			}.Encode(), nil, &page)
+			var candidates []Track
+			for _, item := range page.Items {
+				candidates = append(candidates, jellyfinTrack(item))
+			}
+			return candidates
+		})
+		return found.ID
+	})
+}
+
+// createJellyfinPlaylist creates an empty playlist called name, then adds
+// the songs found to it in batches.
+func createJellyfinPlaylist(name string, playlist []Track, matches *matchCache) ConversionResult {
+	api := newJellyfinAPI()
+	result := ConversionResult{Name: name, Destination: "jellyfin", Total: len(playlist)}
+	itemIds := searchJellyfinSongs(api, playlist, &result, matches)
+	var created struct {
+		ID string `json:"Id"`
+	}
+	api.request("POST", "/Playlists", map[string]interface{}{"Name": name, "UserId": api.userId, "MediaType": "Audio", "Ids": []string{}}, &created)
+	addInBatches(itemIds, jellyfinBatchSize, &result, func(batch []string) {
+		api.request("POST", "/Playlists/"+created.ID+"/Items?"+url.Values{"Ids": {strings.Join(batch, ",")}, "UserId": {api.userId}}.Encode(), nil, nil)
+	})
+	fmt.Println("Added songs to Jellyfin")
+	return result
+}
+
+func favouriteJellyfinSongs(playlist []Track, matches *matchCache) ConversionResult { //marks the songs as favourites instead of adding them to a playlist
+	api := newJellyfinAPI()
+	result := ConversionResult{Name: "Favourites", Destination: "jellyfin:liked", Total: len(playlist)}
+	for _, itemId := range searchJellyfinSongs(api, playlist, &result, matches) {
+		api.request("POST", "/Users/"+api.userId+"/FavoriteItems/"+itemId, nil, nil)
+		result.Added++
+	}
+	fmt.Println("Added songs to Jellyfin favourites")
+	return result
+}
+
+func jellyfinSummary(api *jellyfinAPI, item jellyfinItem) playlistSummary {
+	return playlistSummary{
+		ID:          item.ID,
+		Name:        item.Name,
+		Description: item.Overview,
+		Owned:       !api.sharedWithUser(item.ID),
+		Tracks:      item.ChildCount,
+	}
+}
+
+// sharedWithUser reports whether a playlist another user shared with this
+// one. Jellyfin lists those along with the user's own, and only tells them
+// apart by the users a playlist is shared with, which never includes its
+// owner. Servers before 10.9, which have no sharing, do not answer.
+func (api *jellyfinAPI) sharedWithUser(playlistId string) bool {
+	var playlist struct {
+		Shares []struct {
+			UserID string `json:"UserId"`
+		} `json:"Shares"`
+	}
+	if err := apiRequest(api.client, "GET", api.base+"/Playlists/"+url.PathEscape(playlistId), nil, nil, &playlist); err != nil {
+		return false
+	}
+	for _, share := range playlist.Shares {
+		if strings.EqualFold(strings.ReplaceAll(share.UserID, "-", ""), strings.ReplaceAll(api.userId, "-", "")) {
+			return true
+		}
+	}
+	return false
+}
+
+func listJellyfinPlaylists(includeFollowed bool) []playlistSummary { //lists the user's music playlists, and the ones shared with them when includeFollowed is set
+	api := newJellyfinAPI()
+	var playlists []playlistSummary
+	for _, item := range api.items("/Users/"+api.userId+"/Items", url.Values{"IncludeItemTypes": {"Playlist"}, "MediaTypes": {"Audio"}, "Recursive": {"true"}}) {
+		if summary := jellyfinSummary(api, item); summary.Owned || includeFollowed {
+			playlists = append(playlists, summary)
+		}
+	}
+	return playlists
+}
+
+func jellyfinPlaylistSummary(ref playlistRef) playlistSummary { //gets the name and details of whatever a reference points at
+	if ref.ID == likedID {
+		return playlistSummary{ID: likedID, Name: "Favourites", Owned: true}
+	}
+	api := newJellyfinAPI()
+	var item jellyfinItem
+	api.request("GET", "/Users/"+api.userId+"/Items/"+url.PathEscape(ref.ID), nil, &item)
+	return jellyfinSummary(api, item)
+}
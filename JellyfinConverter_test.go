@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// TestListJellyfinPlaylists lists a playlist of the user's own, one another
+// user shared with them and one from a server too old to share playlists,
+// which only answers the playlist list.
+func TestListJellyfinPlaylists(t *testing.T) {
+	url := standIn(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/Users/AuthenticateByName":
+			json.NewEncoder(w).Encode(map[string]interface{}{"AccessToken": "token", "User": map[string]string{"Id": "0a1b2c3d4e5f"}})
+		case "/Users/0a1b2c3d4e5f/Items":
+			json.NewEncoder(w).Encode(map[string]interface{}{"TotalRecordCount": 3, "Items": []map[string]interface{}{
+				{"Id": "own", "Name": "Own", "ChildCount": 2},
+				{"Id": "shared", "Name": "Shared", "ChildCount": 3},
+				{"Id": "old", "Name": "Old", "ChildCount": 4},
+			}})
+		case "/Playlists/own":
+			json.NewEncoder(w).Encode(map[string]interface{}{"OpenAccess": false, "Shares": []map[string]interface{}{{"UserId": "ffff", "CanEdit": true}}})
+		case "/Playlists/shared":
+			json.NewEncoder(w).Encode(map[string]interface{}{"OpenAccess": false, "Shares": []map[string]interface{}{{"UserId": "0A1B-2C3D-4E5F", "CanEdit": false}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	settings.Jellyfin = jellyfinConfig{URL: url, Username: "alice", Password: "sesame"}
+	names := func(playlists []playlistSummary) []string {
+		var names []string
+		for _, playlist := range playlists {
+			names = append(names, playlist.Name)
+		}
+		return names
+	}
+	if got := names(listJellyfinPlaylists(false)); !reflect.DeepEqual(got, []string{"Own", "Old"}) {
+		t.Errorf("own playlists are %v, want [Own Old]", got)
+	}
+	if got := names(listJellyfinPlaylists(true)); !reflect.DeepEqual(got, []string{"Own", "Shared", "Old"}) {
+		t.Errorf("playlists with followed are %v, want [Own Shared Old]", got)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lastFMConfig holds the Last.fm API key and the user whose history is read
+// unless a reference names another. The base URL can point at another
+// server that answers the same API, such as Libre.fm.
+type lastFMConfig struct {
+	APIKey   string `json:"apiKey"`
+	Username string `json:"username"`
+	BaseURL  string `json:"baseUrl"`
+}
+
+const (
+	lastFMDefaultURL = "https://ws.audioscrobbler.com"
+	lastFMPageLimit  = 200
+)
+
+// lastFMKinds are the lists read from Last.fm: loved tracks, the most
+// played tracks of a period, and the scrobbles of a period.
+var lastFMKinds = []string{"loved", "top", "listens"}
+
+// lastFMPeriods are the periods Last.fm keeps top lists for, others are
+// ranked from the scrobbles of the period.
+var lastFMPeriods = map[string]bool{"overall": true, "7day": true, "1month": true, "3month": true, "6month": true, "12month": true}
+
+// LastFM reads a playlist from a Last.fm user's listening history, from
+// the API or from an export: a JSON file of saved API responses, or a CSV
+// of scrobbles as written by export tools, with the columns artist, album,
+// track and date.
+type LastFM struct {
+	Query string
+}
+
+func NewLastFM(ref playlistRef) *LastFM {
+	return &LastFM{Query: ref.ID}
+}
+
+type lastFMText struct {
+	Name string `json:"name"`
+	Text string `json:"#text"`
+	MBID string `json:"mbid"`
+}
+
+func (t lastFMText) String() string { //Last.fm gives names as name in some lists and #text in others
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Text
+}
+
+// lastFMNumber is a number that Last.fm usually, but not always, gives as
+// a string.
+type lastFMNumber int64
+
+func (n *lastFMNumber) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		value = 0 //an empty string
+	}
+	*n = lastFMNumber(value)
+	return nil
+}
+
+type lastFMTrack struct {
+	Name     string       `json:"name"`
+	MBID     string       `json:"mbid"`
+	URL      string       `json:"url"`
+	Duration lastFMNumber `json:"duration"` //seconds
+	Artist   lastFMText   `json:"artist"`
+	Album    lastFMText   `json:"album"`
+	Date     *struct {
+		UTS lastFMNumber `json:"uts"`
+	} `json:"date"`
+	Attr struct {
+		NowPlaying string `json:"nowplaying"`
+	} `json:"@attr"`
+}
+
+type lastFMList struct {
+	Track []lastFMTrack `json:"track"`
+	Attr  struct {
+		TotalPages lastFMNumber `json:"totalPages"`
+	} `json:"@attr"`
+}
+
+// lastFMResponse holds whichever list a method returns.
+type lastFMResponse struct {
+	Error        int         `json:"error"`
+	Message      string      `json:"message"`
+	LovedTracks  *lastFMList `json:"lovedtracks"`
+	TopTracks    *lastFMList `json:"toptracks"`
+	RecentTracks *lastFMList `json:"recenttracks"`
+}
+
+func (r lastFMResponse) list() *lastFMList {
+	for _, list := range []*lastFMList{r.LovedTracks, r.TopTracks, r.RecentTracks} {
+		if list != nil {
+			return list
+		}
+	}
+	return &lastFMList{}
+}
+
+func (L *LastFM) GetTracks() []Track {
+	ref := L.Query
+	if ref == likedID {
+		ref = "loved"
+	}
+	query, err := parseHistoryQuery(ref, lastFMKinds, settings.LastFM.Username)
+	handleError(err, "Invalid Last.fm reference")
+	from, to, err := historyRange(query.Period, time.Now())
+	if err != nil && query.Kind != "loved" {
+		handleError(err, "Invalid Last.fm period")
+	}
+	if query.File != "" {
+		return lastFMExportTracks(query, from, to)
+	}
+	if query.User == "" {
+		log.Fatalf("Set lastfm.username in %s, or name the user as lastfm:<user>:%s", configFile, query.Kind)
+	}
+	switch query.Kind {
+	case "loved":
+		fmt.Printf("Loved tracks of %s\n", query.User)
+		return lastFMTracks(lastFMPages("user.getlovedtracks", url.Values{"user": {query.User}}, query.Limit), query.Limit)
+	case "top":
+		period := strings.ToLower(query.Period)
+		if period == "" || period == "all_time" {
+			period = "overall"
+		}
+		fmt.Printf("Top %d tracks of %s, %s\n", query.Limit, query.User, period)
+		if lastFMPeriods[period] {
+			response := lastFMCall("user.gettoptracks", url.Values{"user": {query.User}, "period": {period}, "limit": {strconv.Itoa(query.Limit)}})
+			return lastFMTracks(response.list().Track, query.Limit)
+		}
+		items := lastFMPages("user.getrecenttracks", url.Values{ //every scrobble of the period is needed to rank them
+			"user": {query.User},
+			"from": {strconv.FormatInt(from.Unix(), 10)},
+			"to":   {strconv.FormatInt(to.Unix(), 10)},
+		}, 0)
+		return topTracks(lastFMListens(items), from, to, query.Limit)
+	}
+	fmt.Printf("Scrobbles of %s\n", query.User)
+	params := url.Values{"user": {query.User}}
+	if !from.IsZero() {
+		params.Set("from", strconv.FormatInt(from.Unix(), 10))
+		params.Set("to", strconv.FormatInt(to.Unix(), 10))
+	}
+	return lastFMTracks(lastFMPages("user.getrecenttracks", params, query.Limit), query.Limit)
+}
+
+func lastFMTrackFrom(item lastFMTrack) Track {
+	return Track{
+		Title:         item.Name,
+		Artist:        item.Artist.String(),
+		Album:         item.Album.String(),
+		MusicBrainzID: item.MBID,
+		Duration:      time.Duration(item.Duration) * time.Second,
+		Location:      item.URL,
+	}
+}
+
+func lastFMTracks(items []lastFMTrack, limit int) []Track { //the tracks of a list in order, leaving out the song playing right now
+	var tracks []Track
+	for _, item := range items {
+		if item.Attr.NowPlaying == "true" {
+			continue
+		}
+		if limit > 0 && len(tracks) >= limit {
+			break
+		}
+		track := lastFMTrackFrom(item)
+		tracks = append(tracks, track)
+		fmt.Println(track.Query())
+	}
+	return tracks
+}
+
+func lastFMListens(items []lastFMTrack) []listen { //the scrobbles of a list with their time, leaving out the song playing right now
+	var listens []listen
+	for _, item := range items {
+		if item.Attr.NowPlaying == "true" {
+			continue
+		}
+		l := listen{Track: lastFMTrackFrom(item)}
+		if item.Date != nil {
+			l.At = time.Unix(int64(item.Date.UTS), 0)
+		}
+		listens = append(listens, l)
+	}
+	return listens
+}
+
+// lastFMCall calls a method of the API. Last.fm reports some errors in the
+// body of a 200 response.
+func lastFMCall(method string, params url.Values) lastFMResponse {
+	if settings.LastFM.APIKey == "" {
+		log.Fatalf("Set lastfm.apiKey in %s to read from Last.fm, or give an export file", configFile)
+	}
+	base := strings.TrimSuffix(settings.LastFM.BaseURL, "/")
+	if base == "" {
+		base = lastFMDefaultURL
+	}
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+	query.Set("method", method)
+	query.Set("api_key", settings.LastFM.APIKey)
+	query.Set("format", "json")
+	var response lastFMResponse
+	err := apiRequest(http.DefaultClient, "GET", base+"/2.0/?"+query.Encode(), nil, nil, &response)
+	handleError(err, "Last.fm request failed")
+	if response.Error != 0 {
+		log.Fatalf("Last.fm %s failed: %s (error %d)", method, response.Message, response.Error)
+	}
+	return response
+}
+
+func lastFMPages(method string, params url.Values, limit int) []lastFMTrack { //pages through a list until it ends or there are limit tracks
+	var items []lastFMTrack
+	params.Set("limit", strconv.Itoa(lastFMPageLimit))
+	for page := 1; ; page++ {
+		params.Set("page", strconv.Itoa(page))
+		list := lastFMCall(method, params).list()
+		items = append(items, list.Track...)
+		if len(list.Track) == 0 || page >= int(list.Attr.TotalPages) || (limit > 0 && len(items) >= limit) {
+			return items
+		}
+	}
+}
+
+// lastFMExportTracks reads the tracks of an export. Saved API responses
+// are read in order, scrobbles are cut to the period and ranked when a top
+// list is asked for.
+func lastFMExportTracks(query historyQuery, from time.Time, to time.Time) []Track {
+	file, err := os.Open(query.File)
+	handleError(err, "Unable to read Last.fm export")
+	defer file.Close()
+	if !strings.HasSuffix(strings.ToLower(query.File), ".csv") {
+		var responses []lastFMResponse
+		data, err := io.ReadAll(file)
+		handleError(err, "Unable to read Last.fm export")
+		if err := json.Unmarshal(data, &responses); err != nil { //a single response rather than a list of pages
+			var response lastFMResponse
+			err = json.Unmarshal(data, &response)
+			handleError(err, "Unable to parse Last.fm export")
+			responses = []lastFMResponse{response}
+		}
+		var listens []listen
+		for _, response := range responses {
+			listens = append(listens, lastFMListens(response.list().Track)...)
+		}
+		if len(responses) == 0 {
+			log.Fatalf("%s has no Last.fm tracks in it", query.File)
+		}
+		if response := responses[0]; response.RecentTracks == nil { //already a list, loved or ranked
+			var tracks []Track
+			for _, l := range listens {
+				tracks = append(tracks, l.Track)
+			}
+			return lastFMListTracks(tracks, query.Limit)
+		}
+		return historyTracks(query, listens, from, to)
+	}
+	return historyTracks(query, readScrobbleCSV(file), from, to)
+}
+
+func lastFMListTracks(tracks []Track, limit int) []Track {
+	if limit > 0 && len(tracks) > limit {
+		tracks = tracks[:limit]
+	}
+	for _, track := range tracks {
+		fmt.Println(track.Query())
+	}
+	return tracks
+}
+
+// historyTracks turns the listens of an export into a top list or the
+// recent listens, the latter being what an export without a kind reads as.
+func historyTracks(query historyQuery, listens []listen, from time.Time, to time.Time) []Track {
+	switch query.Kind {
+	case "top":
+		return topTracks(listens, from, to, query.Limit)
+	case "", "listens":
+		return recentListens(listens, from, to, query.Limit)
+	}
+	log.Fatalf("A listening history export has no %s list, use top or listens", query.Kind)
+	return nil
+}
+
+// scrobbleTimeLayouts are the date formats export tools write.
+var scrobbleTimeLayouts = []string{"02 Jan 2006 15:04", "2 Jan 2006, 15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05Z07:00"}
+
+// readScrobbleCSV reads scrobbles from a CSV export, with a header naming
+// the artist, album, track and date or uts columns, or without one in that
+// order.
+func readScrobbleCSV(r io.Reader) []listen {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	handleError(err, "Unable to parse Last.fm export")
+	columns := map[string]int{"artist": 0, "album": 1, "track": 2, "date": 3}
+	if len(rows) > 0 {
+		header := make(map[string]int)
+		for i, name := range rows[0] {
+			header[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		if _, ok := header["artist"]; ok {
+			columns = map[string]int{"artist": -1, "album": -1, "track": -1, "date": -1}
+			for key, names := range map[string][]string{"artist": {"artist"}, "album": {"album"}, "track": {"track", "title", "song"}, "date": {"uts", "date", "timestamp", "time"}} {
+				for _, name := range names {
+					if i, ok := header[name]; ok && columns[key] < 0 {
+						columns[key] = i
+					}
+				}
+			}
+			rows = rows[1:]
+		}
+	}
+	field := func(row []string, key string) string {
+		if i := columns[key]; i >= 0 && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	var listens []listen
+	for _, row := range rows {
+		l := listen{Track: Track{Artist: field(row, "artist"), Album: field(row, "album"), Title: field(row, "track")}}
+		if l.Track.Title == "" {
+			continue
+		}
+		date := field(row, "date")
+		if seconds, err := strconv.ParseInt(date, 10, 64); err == nil {
+			l.At = time.Unix(seconds, 0)
+		}
+		for _, layout := range scrobbleTimeLayouts {
+			if at, err := time.Parse(layout, date); err == nil {
+				l.At = at
+				break
+			}
+		}
+		listens = append(listens, l)
+	}
+	return listens
+}
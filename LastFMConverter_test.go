@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestLastFMTopOfYear checks that a top list of a year is ranked from the
+// scrobbles of that year, paged through with from and to, rather than from
+// a weekly chart, which only takes the weeks Last.fm lists.
+func TestLastFMTopOfYear(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	scrobble := func(title string, day int) map[string]interface{} {
+		at := from.AddDate(0, 0, day).Unix()
+		return map[string]interface{}{"name": title, "artist": map[string]string{"#text": "Artist"}, "date": map[string]string{"uts": strconv.FormatInt(at, 10)}}
+	}
+	pages := map[string][]interface{}{
+		"1": {map[string]interface{}{"name": "Playing", "artist": map[string]string{"#text": "Artist"}, "@attr": map[string]string{"nowplaying": "true"}}, scrobble("Less", 300), scrobble("Most", 200)},
+		"2": {scrobble("Most", 100), scrobble("Less", 50), scrobble("Most", 10)},
+	}
+	var requests []string
+	url := standIn(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		requests = append(requests, query.Get("method"))
+		if query.Get("method") != "user.getrecenttracks" || query.Get("user") != "alice" || query.Get("api_key") != "key" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": 6, "message": "Invalid parameters"})
+			return
+		}
+		if query.Get("from") != strconv.FormatInt(from.Unix(), 10) || query.Get("to") != strconv.FormatInt(from.AddDate(1, 0, 0).Unix(), 10) {
+			t.Errorf("read scrobbles from %s to %s, want the whole of 2024", query.Get("from"), query.Get("to"))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"recenttracks": map[string]interface{}{"track": pages[query.Get("page")], "@attr": map[string]string{"totalPages": "2"}}})
+	}))
+	settings.LastFM = lastFMConfig{APIKey: "key", Username: "alice", BaseURL: url}
+
+	tracks := NewLastFM(playlistRef{Service: LASTFM, ID: "top:2024:1"}).GetTracks()
+	if len(tracks) != 1 || tracks[0].Title != "Most" {
+		t.Errorf("top track of 2024 = %+v, want Most", tracks)
+	}
+	if want := []string{"user.getrecenttracks", "user.getrecenttracks"}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("called %v, want both pages of scrobbles", requests)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenBrainzConfig holds the user whose history is read unless a
+// reference names another, and optionally their token, which is only
+// needed for private data. The base URL can point at another server that
+// answers the same API.
+type listenBrainzConfig struct {
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	BaseURL  string `json:"baseUrl"`
+}
+
+const (
+	listenBrainzDefaultURL  = "https://api.listenbrainz.org"
+	listenBrainzListenLimit = 1000 //the most listens the API returns at once
+	listenBrainzStatsLimit  = 100  //the most statistics the API returns at once
+)
+
+// listenBrainzKinds are the lists read from ListenBrainz: listens, the
+// most played recordings of a period, the playlists generated for the
+// user, picked by name, and any playlist by its MBID.
+var listenBrainzKinds = []string{"listens", "top", "recommendations", "playlist"}
+
+// listenBrainzRanges are the periods ListenBrainz keeps statistics for,
+// others are ranked from the listens.
+var listenBrainzRanges = map[string]bool{"this_week": true, "this_month": true, "this_year": true, "week": true, "month": true, "quarter": true, "year": true, "half_yearly": true, "all_time": true}
+
+// ListenBrainz reads a playlist from a ListenBrainz user's listens, top
+// recordings or recommendation playlists, from the API or from a listens
+// export: the ZIP file ListenBrainz exports, or the JSON or JSON lines
+// listens files in it.
+type ListenBrainz struct {
+	Query string
+}
+
+func NewListenBrainz(ref playlistRef) *ListenBrainz {
+	return &ListenBrainz{Query: ref.ID}
+}
+
+type listenBrainzListen struct {
+	ListenedAt    int64 `json:"listened_at"`
+	TrackMetadata struct {
+		ArtistName     string `json:"artist_name"`
+		TrackName      string `json:"track_name"`
+		ReleaseName    string `json:"release_name"`
+		AdditionalInfo struct {
+			RecordingMBID string `json:"recording_mbid"`
+			ISRC          string `json:"isrc"`
+			DurationMs    int64  `json:"duration_ms"`
+		} `json:"additional_info"`
+		MBIDMapping *struct {
+			RecordingMBID string `json:"recording_mbid"`
+		} `json:"mbid_mapping"`
+	} `json:"track_metadata"`
+}
+
+func (L *ListenBrainz) GetTracks() []Track {
+	query, err := parseHistoryQuery(L.Query, listenBrainzKinds, settings.ListenBrainz.Username)
+	handleError(err, "Invalid ListenBrainz reference")
+	if query.File != "" {
+		from, to, err := historyRange(query.Period, time.Now())
+		handleError(err, "Invalid ListenBrainz period")
+		return historyTracks(query, readListenBrainzExport(query.File), from, to)
+	}
+	api := newListenBrainzAPI()
+	switch query.Kind {
+	case "playlist":
+		return api.playlist(query.Period)
+	case "recommendations":
+		return api.playlist(api.recommendation(query.User, query.Period))
+	}
+	if query.User == "" {
+		log.Fatalf("Set listenbrainz.username in %s, or name the user as listenbrainz:<user>:%s", configFile, query.Kind)
+	}
+	period := strings.ToLower(query.Period)
+	if query.Kind == "top" && (period == "" || period == "overall" || listenBrainzRanges[period]) {
+		if period == "" || period == "overall" {
+			period = "all_time"
+		}
+		return api.topRecordings(query.User, period, query.Limit)
+	}
+	from, to, err := historyRange(query.Period, time.Now())
+	handleError(err, "Invalid ListenBrainz period")
+	limit := query.Limit
+	if query.Kind == "top" {
+		limit = 0 //every listen of the period is needed to rank them
+	}
+	return historyTracks(query, api.listens(query.User, from, to, limit), from, to)
+}
+
+func listenBrainzTrack(item listenBrainzListen) listen {
+	metadata := item.TrackMetadata
+	track := Track{
+		Title:         metadata.TrackName,
+		Artist:        metadata.ArtistName,
+		Album:         metadata.ReleaseName,
+		ISRC:          metadata.AdditionalInfo.ISRC,
+		MusicBrainzID: metadata.AdditionalInfo.RecordingMBID,
+		Duration:      time.Duration(metadata.AdditionalInfo.DurationMs) * time.Millisecond,
+	}
+	if track.MusicBrainzID == "" && metadata.MBIDMapping != nil { //the recording ListenBrainz matched the listen to
+		track.MusicBrainzID = metadata.MBIDMapping.RecordingMBID
+	}
+	return listen{Track: track, At: time.Unix(item.ListenedAt, 0)}
+}
+
+// listenBrainzAPI calls the ListenBrainz API, with the user's token if
+// there is one.
+type listenBrainzAPI struct {
+	base   string
+	header http.Header
+}
+
+func newListenBrainzAPI() *listenBrainzAPI {
+	api := &listenBrainzAPI{base: strings.TrimSuffix(settings.ListenBrainz.BaseURL, "/"), header: http.Header{}}
+	if api.base == "" {
+		api.base = listenBrainzDefaultURL
+	}
+	if settings.ListenBrainz.Token != "" {
+		api.header.Set("Authorization", "Token "+settings.ListenBrainz.Token)
+	}
+	return api
+}
+
+func (api *listenBrainzAPI) request(path string, out interface{}) {
+	err := apiRequest(http.DefaultClient, "GET", api.base+path, api.header, nil, out)
+	handleError(err, "ListenBrainz request failed")
+}
+
+// listens pages back through a user's listens from to, newest first, until
+// from or until there are limit of them.
+func (api *listenBrainzAPI) listens(user string, from time.Time, to time.Time, limit int) []listen {
+	fmt.Printf("Listens of %s\n", user)
+	var listens []listen
+	maxTs := int64(0)
+	if !to.IsZero() {
+		maxTs = to.Unix()
+	}
+	for limit == 0 || len(listens) < limit {
+		params := url.Values{"count": {strconv.Itoa(listenBrainzListenLimit)}}
+		if maxTs > 0 {
+			params.Set("max_ts", strconv.FormatInt(maxTs, 10))
+		}
+		var response struct {
+			Payload struct {
+				Listens []listenBrainzListen `json:"listens"`
+			} `json:"payload"`
+		}
+		api.request("/1/user/"+url.PathEscape(user)+"/listens?"+params.Encode(), &response)
+		page := response.Payload.Listens
+		if len(page) == 0 {
+			break
+		}
+		for _, item := range page {
+			listens = append(listens, listenBrainzTrack(item))
+		}
+		maxTs = page[len(page)-1].ListenedAt
+		if !from.IsZero() && maxTs < from.Unix() {
+			break
+		}
+	}
+	return listens
+}
+
+// topRecordings reads the statistics ListenBrainz keeps of a user's most
+// played recordings.
+func (api *listenBrainzAPI) topRecordings(user string, period string, limit int) []Track {
+	fmt.Printf("Top %d recordings of %s, %s\n", limit, user, period)
+	var tracks []Track
+	for len(tracks) < limit {
+		count := limit - len(tracks)
+		if count > listenBrainzStatsLimit {
+			count = listenBrainzStatsLimit
+		}
+		var response struct {
+			Payload struct {
+				Recordings []struct {
+					TrackName     string `json:"track_name"`
+					ArtistName    string `json:"artist_name"`
+					ReleaseName   string `json:"release_name"`
+					RecordingMBID string `json:"recording_mbid"`
+					ListenCount   int    `json:"listen_count"`
+				} `json:"recordings"`
+			} `json:"payload"`
+		}
+		api.request(fmt.Sprintf("/1/stats/user/%s/recordings?range=%s&count=%d&offset=%d", url.PathEscape(user), url.QueryEscape(period), count, len(tracks)), &response)
+		for _, item := range response.Payload.Recordings {
+			track := Track{Title: item.TrackName, Artist: item.ArtistName, Album: item.ReleaseName, MusicBrainzID: item.RecordingMBID}
+			tracks = append(tracks, track)
+			fmt.Printf("%d plays: %v\n", item.ListenCount, track.Query())
+		}
+		if len(response.Payload.Recordings) < count { //the last of them, or statistics that are not calculated yet, which come back empty
+			break
+		}
+	}
+	return tracks
+}
+
+// recommendation finds the newest playlist generated for a user whose
+// title contains name, such as Weekly Jams or Weekly Exploration, or the
+// newest of them all without a name.
+func (api *listenBrainzAPI) recommendation(user string, name string) string {
+	if user == "" {
+		log.Fatalf("Set listenbrainz.username in %s, or name the user as listenbrainz:<user>:recommendations", configFile)
+	}
+	var response struct {
+		Playlists []struct {
+			Playlist xspfPlaylistInfo `json:"playlist"`
+		} `json:"playlists"`
+	}
+	api.request("/1/user/"+url.PathEscape(user)+"/playlists/createdfor", &response)
+	for _, item := range response.Playlists {
+		if strings.Contains(strings.ToLower(item.Playlist.Title), strings.ToLower(name)) {
+			return path.Base(item.Playlist.Identifier)
+		}
+	}
+	log.Fatalf("ListenBrainz has no recommendation playlist called %q for %s", name, user)
+	return ""
+}
+
+// xspfPlaylistInfo is the title and identifier of a JSPF playlist, which
+// ListenBrainz lists playlists by.
+type xspfPlaylistInfo struct {
+	Title      string `json:"title"`
+	Identifier string `json:"identifier"`
+}
+
+func (api *listenBrainzAPI) playlist(mbid string) []Track { //reads a playlist, which ListenBrainz gives as JSPF
+	if mbid == "" {
+		log.Fatalf("Give the playlist as listenbrainz:playlist:<mbid>")
+	}
+	var response struct {
+		Playlist xspfPlaylist `json:"playlist"`
+	}
+	api.request("/1/playlist/"+url.PathEscape(mbid), &response)
+	fmt.Printf("Tracks in %s\n", response.Playlist.Title)
+	var tracks []Track
+	for _, item := range response.Playlist.Tracks {
+		track := trackFromXSPF(item, "")
+		tracks = append(tracks, track)
+		fmt.Println(track.Query())
+	}
+	return tracks
+}
+
+// readListenBrainzExport reads the listens in an export, the ZIP file or
+// a JSON or JSON lines file of listens from it.
+func readListenBrainzExport(file string) []listen {
+	if strings.HasSuffix(strings.ToLower(file), ".zip") {
+		archive, err := zip.OpenReader(file)
+		handleError(err, "Unable to open ListenBrainz export")
+		defer archive.Close()
+		var listens []listen
+		for _, entry := range archive.File {
+			name := path.Base(entry.Name)
+			if !strings.HasPrefix(entry.Name, "listens/") && name != "listens.json" && name != "listens.jsonl" { //also in the export are feedback and other files
+				continue
+			}
+			r, err := entry.Open()
+			handleError(err, "Unable to read ListenBrainz export")
+			data, err := io.ReadAll(r)
+			r.Close()
+			handleError(err, "Unable to read ListenBrainz export")
+			listens = append(listens, parseListenBrainzListens(data)...)
+		}
+		return listens
+	}
+	data, err := os.ReadFile(file)
+	handleError(err, "Unable to read ListenBrainz export")
+	return parseListenBrainzListens(data)
+}
+
+func parseListenBrainzListens(data []byte) []listen { //a JSON array of listens, or one listen per line
+	var items []listenBrainzListen
+	if err := json.Unmarshal(data, &items); err != nil {
+		items = nil
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var item listenBrainzListen
+			err := json.Unmarshal(line, &item)
+			handleError(err, "Unable to parse ListenBrainz export")
+			items = append(items, item)
+		}
+	}
+	var listens []listen
+	for _, item := range items {
+		if item.TrackMetadata.TrackName != "" {
+			listens = append(listens, listenBrainzTrack(item))
+		}
+	}
+	return listens
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestListenBrainzListens checks that listens are paged back through with
+// max_ts set to the oldest listen of the page before, stopping once the
+// start of the period, the limit or the end of the listens is reached.
+func TestListenBrainzListens(t *testing.T) {
+	listenedAt := []int64{600, 500, 400, 300, 200, 100}
+	var requests []string
+	url := standIn(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1/user/alice/listens" || r.Header.Get("Authorization") != "Token token" {
+			http.NotFound(w, r)
+			return
+		}
+		maxTs := r.URL.Query().Get("max_ts")
+		requests = append(requests, maxTs)
+		var page []map[string]interface{}
+		for _, at := range listenedAt {
+			if before, err := strconv.ParseInt(maxTs, 10, 64); len(page) < 2 && (err != nil || at < before) {
+				page = append(page, map[string]interface{}{"listened_at": at, "track_metadata": map[string]string{"artist_name": "Artist", "track_name": strconv.FormatInt(at, 10)}})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"payload": map[string]interface{}{"listens": page}})
+	}))
+	settings.ListenBrainz = listenBrainzConfig{Token: "token", Username: "alice", BaseURL: url + "/"}
+	titles := func(listens []listen) []string {
+		var titles []string
+		for _, l := range listens {
+			titles = append(titles, l.Track.Title)
+		}
+		return titles
+	}
+	api := newListenBrainzAPI()
+
+	tests := []struct {
+		from, to int64
+		limit    int
+		titles   []string
+		requests []string
+	}{
+		{250, 550, 0, []string{"500", "400", "300", "200"}, []string{"550", "400"}},
+		{0, 0, 3, []string{"600", "500", "400", "300"}, []string{"", "500"}},
+		{0, 0, 0, []string{"600", "500", "400", "300", "200", "100"}, []string{"", "500", "300", "100"}},
+	}
+	for _, test := range tests {
+		requests = nil
+		var from, to time.Time
+		if test.from > 0 {
+			from, to = time.Unix(test.from, 0), time.Unix(test.to, 0)
+		}
+		got := titles(api.listens("alice", from, to, test.limit))
+		if !reflect.DeepEqual(got, test.titles) || !reflect.DeepEqual(requests, test.requests) {
+			t.Errorf("listens from %d to %d, limit %d = %v with max_ts %q, want %v with max_ts %q", test.from, test.to, test.limit, got, requests, test.titles, test.requests)
+		}
+	}
+}
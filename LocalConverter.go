@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalMusic treats audio files on disk as a music service. As a source it
+// reads a folder of audio files, or a playlist file of them, using the
+// metadata in the files' tags. As a destination it writes an M3U playlist
+// of the files in the music folder that match the tracks, by default into
+// the music folder itself.
+type LocalMusic struct {
+	Path string
+}
+
+func NewLocalMusic(ref playlistRef) *LocalMusic {
+	return &LocalMusic{Path: ref.ID}
+}
+
+func (L *LocalMusic) GetTracks() []Track {
+	info, err := os.Stat(L.Path)
+	handleError(err, "Unable to read local music")
+	if info.IsDir() {
+		library, err := scanLibrary(L.Path)
+		handleError(err, "Unable to scan music folder")
+		return library.Tracks
+	}
+	service, ok := fileExtensions[strings.ToLower(filepath.Ext(L.Path))]
+	if !ok || !service.isFile() || service == LOCAL {
+		handleError(fmt.Errorf("%s is not a folder or a playlist file", L.Path), "Unable to read local music")
+	}
+	tracks := newSource(playlistRef{Service: service, ID: L.Path}).GetTracks()
+	for i, track := range tracks {
+		if track.Location == "" || strings.Contains(track.Location, "://") {
+			continue
+		}
+		tags, err := readTags(track.Location)
+		if err != nil {
+			continue
+		}
+		tracks[i] = mergeTags(track, tags)
+	}
+	return tracks
+}
+
+func (L *LocalMusic) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	library := openMusicLibrary()
+	path := L.Path
+	if path == "" {
+		path = settings.Library.Folder
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, playlistFileName(name, ".m3u8"))
+	}
+	var files []Track
+	var notFound []string
+	for _, track := range tracks {
+		file, ok := library.match(track)
+		if !ok {
+			notFound = append(notFound, track.Query())
+			continue
+		}
+		files = append(files, file)
+	}
+	result := (&M3UFile{Path: path}).WritePlaylist(name, files, matches)
+	result.Destination = "local:" + path
+	result.Total = len(tracks)
+	result.NotFound = append(notFound, result.NotFound...)
+	return result
+}
+
+func mergeTags(track Track, tags Track) Track { //prefers what the file's tags say over what a playlist file said
+	for _, field := range []struct{ from, to *string }{
+		{&tags.Title, &track.Title}, {&tags.Artist, &track.Artist}, {&tags.Album, &track.Album},
+		{&tags.ISRC, &track.ISRC}, {&tags.MusicBrainzID, &track.MusicBrainzID},
+	} {
+		if *field.from != "" {
+			*field.to = *field.from
+		}
+	}
+	if tags.Duration > 0 {
+		track.Duration = tags.Duration
+	}
+	return track
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// M3UFile reads and writes playlist files in the M3U format, either plain lists
+// of paths or extended M3U with #EXTINF lines. Files are always written as
+// UTF-8, which makes them valid M3U8 as well.
+type M3UFile struct {
+	Path string
+}
+
+func NewM3UFile(ref playlistRef) *M3UFile {
+	return &M3UFile{Path: ref.ID}
+}
+
+func (M *M3UFile) GetTracks() []Track {
+	f, err := os.Open(M.Path)
+	if err != nil {
+		log.Fatalf("Unable to read M3U playlist: %v", err)
+	}
+	defer f.Close()
+	tracks, err := parseM3U(f, filepath.Dir(M.Path))
+	handleError(err, "Unable to parse M3U playlist")
+	return tracks
+}
+
+// WritePlaylist writes the tracks that have a location, a service link or
+// a local path, to the file. Without a path the file is named after the
+// playlist.
+func (M *M3UFile) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	path := M.Path
+	if path == "" {
+		path = playlistFileName(name, ".m3u8")
+	}
+	result := ConversionResult{Name: name, Destination: "m3u:" + path, Total: len(tracks)}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Unable to create M3U playlist: %v", err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#EXTM3U")
+	fmt.Fprintf(w, "#PLAYLIST:%s\n", name)
+	for _, track := range tracks {
+		if track.Location == "" { //an M3U entry is its location, there is nothing to write without one
+			result.NotFound = append(result.NotFound, track.Query())
+			continue
+		}
+		seconds := -1 //unknown length
+		if track.Duration > 0 {
+			seconds = int(track.Duration.Round(time.Second) / time.Second)
+		}
+		fmt.Fprintf(w, "#EXTINF:%d,%s\n", seconds, m3uDisplayTitle(track))
+		if track.Album != "" {
+			fmt.Fprintf(w, "#EXTALB:%s\n", track.Album)
+		}
+		fmt.Fprintln(w, track.Location)
+		result.Added++
+	}
+	err = w.Flush()
+	handleError(err, "Unable to write M3U playlist")
+	fmt.Println("Wrote", path)
+	return result
+}
+
+func m3uDisplayTitle(track Track) string { //the "Artist - Title" part of an #EXTINF line
+	if track.Artist == "" {
+		return track.Title
+	}
+	return track.Artist + " - " + track.Title
+}
+
+// parseM3U reads the entries of an M3U playlist. #EXTINF, #EXTART and
+// #EXTALB lines describe the entry that follows them, other comments are
+// skipped. Relative paths are resolved against dir, the playlist's folder.
+func parseM3U(r io.Reader, dir string) ([]Track, error) {
+	var tracks []Track
+	var next Track //details of the next entry, from the #EXT lines before it
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 0; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if lineNumber == 0 {
+			line = strings.TrimPrefix(line, "\ufeff") //byte order mark
+		}
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			parseExtinf(strings.TrimPrefix(line, "#EXTINF:"), &next)
+		case strings.HasPrefix(line, "#EXTART:"):
+			next.Artist = strings.TrimSpace(strings.TrimPrefix(line, "#EXTART:"))
+		case strings.HasPrefix(line, "#EXTALB:"):
+			next.Album = strings.TrimSpace(strings.TrimPrefix(line, "#EXTALB:"))
+		case strings.HasPrefix(line, "#"):
+		default:
+			track := next
+			track.Location = m3uLocation(line, dir)
+			if track.Title == "" {
+				track.Artist, track.Title = titleFromFileName(track.Location, track.Artist)
+			}
+			tracks = append(tracks, track)
+			next = Track{}
+		}
+	}
+	return tracks, scanner.Err()
+}
+
+// parseExtinf reads "<seconds> [attributes],<artist> - <title>" from an
+// #EXTINF line. Attributes such as tvg-name="a, b" may contain commas, so
+// the title starts at the first comma outside quotes.
+func parseExtinf(info string, track *Track) {
+	quoted := false
+	split := -1
+	for i, c := range info {
+		if c == '"' {
+			quoted = !quoted
+		}
+		if c == ',' && !quoted {
+			split = i
+			break
+		}
+	}
+	if split < 0 {
+		return
+	}
+	length := strings.Fields(info[:split])
+	if len(length) > 0 {
+		if seconds, err := strconv.ParseFloat(length[0], 64); err == nil && seconds > 0 {
+			track.Duration = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	display := strings.TrimSpace(info[split+1:])
+	if artist, title, found := strings.Cut(display, " - "); found {
+		track.Artist = strings.TrimSpace(artist)
+		track.Title = strings.TrimSpace(title)
+	} else {
+		track.Title = display
+	}
+}
+
+func m3uLocation(entry string, dir string) string { //turns an entry into a link or a path usable from the current folder
+	if strings.HasPrefix(entry, "file://") {
+		if u, err := url.Parse(entry); err == nil { //the path comes back percent-decoded
+			path := u.Path
+			if windowsDrivePath.MatchString(path) { //file://localhost/C:/Users/... from Windows
+				path = path[1:]
+			}
+			return filepath.FromSlash(path)
+		}
+	}
+	if strings.Contains(entry, "://") || filepath.IsAbs(entry) {
+		return entry
+	}
+	return filepath.Join(dir, filepath.FromSlash(entry))
+}
+
+// windowsDrivePath matches the path of a file:// URL to a file on a
+// Windows drive, which starts with a slash before the drive letter.
+var windowsDrivePath = regexp.MustCompile(`^/[A-Za-z]:/`)
+
+var trackNumberPrefix = regexp.MustCompile(`^\d{1,3}[ ._-]+`)
+
+// titleFromFileName guesses the title of an entry without an #EXTINF line
+// from its file name, which is often "Artist - Title" or "01 Title".
+func titleFromFileName(location string, artist string) (string, string) {
+	name := strings.TrimSuffix(filepath.Base(location), filepath.Ext(location))
+	name = trackNumberPrefix.ReplaceAllString(name, "")
+	if fileArtist, title, found := strings.Cut(name, " - "); found && artist == "" {
+		return strings.TrimSpace(fileArtist), strings.TrimSpace(title)
+	}
+	return artist, name
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mpdConfig holds the address of the MPD server, host:port or the path of
+// its Unix socket, and its password if it has one.
+type mpdConfig struct {
+	Address  string `json:"address"`
+	Password string `json:"password"`
+}
+
+const mpdDefaultAddress = "localhost:6600"
+
+// MPDPlaylist reads and writes the stored playlists of a Music Player
+// Daemon, see https://mpd.readthedocs.io/en/latest/protocol.html.
+// References are the name of the stored playlist.
+type MPDPlaylist struct {
+	Name string
+}
+
+func NewMPDPlaylist(ref playlistRef) *MPDPlaylist {
+	return &MPDPlaylist{Name: ref.ID}
+}
+
+// mpdConn is a connection to MPD. Commands are sent one line at a time and
+// answered with "key: value" lines ending in OK, or an ACK line on error.
+type mpdConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+type mpdPair struct {
+	Key   string
+	Value string
+}
+
+func (M *MPDPlaylist) GetTracks() []Track {
+	if M.Name == likedID || M.Name == albumsID {
+		log.Fatalf("MPD has no liked songs, convert from a stored playlist with mpd:<name>")
+	}
+	conn := dialMPD()
+	defer conn.close()
+	tracks := mpdTracks(conn.command("listplaylistinfo", M.Name))
+	for _, track := range tracks {
+		fmt.Printf("%v, (%v)\n", track.Query(), track.Location)
+	}
+	return tracks
+}
+
+// WritePlaylist creates a new stored playlist.
+func (M *MPDPlaylist) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	switch M.Name {
+	case "":
+		return createMPDPlaylist(name, tracks, matches)
+	case likedID, albumsID:
+		log.Fatalf("MPD has no liked songs or saved albums, convert to mpd to make a stored playlist")
+	}
+	log.Fatalf("Adding to an existing MPD playlist is not supported")
+	return ConversionResult{}
+}
+
+// mpdTracks builds the songs in a response, each of which starts with its
+// file. MPD names the MusicBrainz recording ID tag MUSICBRAINZ_TRACKID.
+func mpdTracks(pairs []mpdPair) []Track {
+	var tracks []Track
+	for _, pair := range pairs {
+		if pair.Key == "file" {
+			tracks = append(tracks, Track{Location: pair.Value, ID: pair.Value})
+			continue
+		}
+		if len(tracks) == 0 {
+			continue
+		}
+		track := &tracks[len(tracks)-1]
+		switch pair.Key {
+		case "Title":
+			track.Title = pair.Value
+		case "Artist":
+			if track.Artist == "" { //songs with several artists have a line for each
+				track.Artist = pair.Value
+			} else {
+				track.Artist += ", " + pair.Value
+			}
+		case "Album":
+			track.Album = pair.Value
+		case "MUSICBRAINZ_TRACKID":
+			track.MusicBrainzID = pair.Value
+		case "duration":
+			seconds, _ := strconv.ParseFloat(pair.Value, 64)
+			track.Duration = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	for i := range tracks { //files without tags, such as streams, are known by their name
+		if tracks[i].Title == "" {
+			tracks[i].Artist, tracks[i].Title = titleFromFileName(tracks[i].Location, tracks[i].Artist)
+		}
+	}
+	return tracks
+}
+
+func dialMPD() *mpdConn {
+	address := settings.MPD.Address
+	if address == "" {
+		address = mpdDefaultAddress
+	}
+	network := "tcp"
+	if strings.HasPrefix(address, "/") || strings.HasPrefix(address, "@") { //a socket path, or an abstract socket on Linux
+		network = "unix"
+	}
+	conn, err := net.Dial(network, address)
+	handleError(err, "Unable to connect to MPD at "+address)
+	c := &mpdConn{conn: conn, reader: bufio.NewReader(conn)}
+	greeting, err := c.reader.ReadString('\n')
+	handleError(err, "Unable to read from MPD")
+	if !strings.HasPrefix(greeting, "OK MPD ") {
+		log.Fatalf("%s is not an MPD server", address)
+	}
+	if settings.MPD.Password != "" {
+		c.command("password", settings.MPD.Password)
+	}
+	return c
+}
+
+func (c *mpdConn) close() {
+	c.conn.Close()
+}
+
+// mpdQuote quotes a command argument, escaping backslashes and quotes.
+func mpdQuote(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+func mpdCommandLine(name string, args []string) string {
+	line := name
+	for _, arg := range args {
+		line += " " + mpdQuote(arg)
+	}
+	return line + "\n"
+}
+
+func (c *mpdConn) command(name string, args ...string) []mpdPair {
+	pairs, err := c.call(name, args...)
+	handleError(err, "MPD request failed")
+	return pairs
+}
+
+// call sends a command and returns the lines of its response, or the error
+// of its ACK line.
+func (c *mpdConn) call(name string, args ...string) ([]mpdPair, error) {
+	if _, err := c.conn.Write([]byte(mpdCommandLine(name, args))); err != nil {
+		return nil, err
+	}
+	return c.response(name)
+}
+
+// commandList sends several commands at once, which MPD answers with a
+// single OK once all of them succeeded.
+func (c *mpdConn) commandList(name string, argLists [][]string) {
+	var lines strings.Builder
+	lines.WriteString("command_list_begin\n")
+	for _, args := range argLists {
+		lines.WriteString(mpdCommandLine(name, args))
+	}
+	lines.WriteString("command_list_end\n")
+	_, err := c.conn.Write([]byte(lines.String()))
+	if err == nil {
+		_, err = c.response(name)
+	}
+	handleError(err, "MPD request failed")
+}
+
+func (c *mpdConn) response(name string) ([]mpdPair, error) {
+	var pairs []mpdPair
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "OK" {
+			return pairs, nil
+		}
+		if strings.HasPrefix(line, "ACK ") {
+			return nil, fmt.Errorf("%s failed: %s", name, strings.TrimPrefix(line, "ACK "))
+		}
+		if key, value, found := strings.Cut(line, ": "); found {
+			pairs = append(pairs, mpdPair{Key: key, Value: value})
+		}
+	}
+}
+
+// searchMPDSongs finds each song in MPD's database by its title and
+// returns the files, which is what stored playlists hold.
+func searchMPDSongs(conn *mpdConn, playlist []Track, result *ConversionResult, matches *matchCache) []string {
+	return matches.find(MPD, playlist, result, func(track Track) string {
+		found, _ := matchSearch(track, func(title string) []Track {
+			return mpdTracks(conn.command("search", "title", title))
+		})
+		return found.Location
+	})
+}
+
+// createMPDPlaylist creates a stored playlist called name with the songs
+// found. Stored playlists are known by their name, so a number is added to
+// it if there already is a playlist called name.
+func createMPDPlaylist(name string, playlist []Track, matches *matchCache) ConversionResult {
+	conn := dialMPD()
+	defer conn.close()
+	existing := make(map[string]bool)
+	for _, pair := range conn.command("listplaylists") {
+		if pair.Key == "playlist" {
+			existing[pair.Value] = true
+		}
+	}
+	for n := 2; existing[name]; n++ {
+		name = fmt.Sprintf("%s #%d", strings.TrimSuffix(name, fmt.Sprintf(" #%d", n-1)), n)
+	}
+	result := ConversionResult{Name: name, Destination: "mpd", Total: len(playlist)}
+	files := searchMPDSongs(conn, playlist, &result, matches)
+	var adds [][]string
+	for _, file := range files {
+		adds = append(adds, []string{name, file})
+	}
+	if len(adds) > 0 {
+		conn.commandList("playlistadd", adds)
+	}
+	result.Added = len(adds)
+	fmt.Println("Added songs to MPD playlist " + name)
+	return result
+}
+
+func listMPDPlaylists(includeFollowed bool) []playlistSummary { //lists the stored playlists, which all belong to the server
+	conn := dialMPD()
+	defer conn.close()
+	var playlists []playlistSummary
+	for _, pair := range conn.command("listplaylists") {
+		if pair.Key == "playlist" {
+			playlists = append(playlists, mpdSummary(conn, pair.Value))
+		}
+	}
+	return playlists
+}
+
+func mpdSummary(conn *mpdConn, name string) playlistSummary {
+	tracks := 0
+	for _, pair := range conn.command("listplaylist", name) {
+		if pair.Key == "file" {
+			tracks++
+		}
+	}
+	return playlistSummary{ID: name, Name: name, Owned: true, Tracks: tracks}
+}
+
+func mpdPlaylistSummary(ref playlistRef) playlistSummary { //gets the name and length of a stored playlist
+	conn := dialMPD()
+	defer conn.close()
+	return mpdSummary(conn, ref.ID)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMPD is a stand-in for MPD that records every command line it is sent
+// and answers them from responses, by the whole line. Lines of a command
+// list are answered with a single OK at its end.
+type fakeMPD struct {
+	mu        sync.Mutex
+	lines     []string
+	responses map[string]string
+}
+
+func (f *fakeMPD) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.Write([]byte("OK MPD 0.23.5\n"))
+	reader := bufio.NewReader(conn)
+	inList := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+		f.mu.Lock()
+		f.lines = append(f.lines, line)
+		response, known := f.responses[line]
+		f.mu.Unlock()
+		switch {
+		case line == "command_list_begin":
+			inList = true
+		case line == "command_list_end":
+			inList = false
+			conn.Write([]byte("OK\n"))
+		case inList:
+		case known:
+			conn.Write([]byte(response + "OK\n"))
+		default:
+			conn.Write([]byte("ACK [5@0] {} unknown command \"" + line + "\"\n"))
+		}
+	}
+}
+
+func (f *fakeMPD) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines := f.lines
+	f.lines = nil
+	return lines
+}
+
+func newFakeMPD(t *testing.T) *fakeMPD {
+	fake := &fakeMPD{responses: map[string]string{
+		`password "pa\"ss\\word"`: "",
+		"listplaylists":           "playlist: Mix\nLast-Modified: 2025-01-01T00:00:00Z\nplaylist: Mix #2\n",
+		`listplaylistinfo "Mix"`: "file: a/song.flac\nTitle: Song\nArtist: Artist\nArtist: Guest\nMUSICBRAINZ_TRACKID: d3b2a0b6-1f9d-4b46-9a3c-6a2b5e1c4f10\nduration: 201.500\n" +
+			"file: b/Someone - Other Song.mp3\n",
+		`search "title" "song"`:    "file: a/song.flac\nTitle: Song\nArtist: Artist\nArtist: Guest\nfile: c/other.flac\nTitle: Other Song\nArtist: Someone\n",
+		`search "title" "Nothing"`: "",
+	}}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fake.serve(conn)
+		}
+	}()
+	withSettings(t)
+	settings.MPD = mpdConfig{Address: listener.Addr().String(), Password: `pa"ss\word`}
+	return fake
+}
+
+func TestMPDGetTracks(t *testing.T) {
+	fake := newFakeMPD(t)
+	tracks := NewMPDPlaylist(playlistRef{Service: MPD, ID: "Mix"}).GetTracks()
+	want := []Track{
+		{Title: "Song", Artist: "Artist, Guest", MusicBrainzID: "d3b2a0b6-1f9d-4b46-9a3c-6a2b5e1c4f10", Duration: 201500 * time.Millisecond, ID: "a/song.flac", Location: "a/song.flac"},
+		{Title: "Other Song", Artist: "Someone", ID: "b/Someone - Other Song.mp3", Location: "b/Someone - Other Song.mp3"},
+	}
+	if !reflect.DeepEqual(tracks, want) {
+		t.Errorf("read %+v, want %+v", tracks, want)
+	}
+	if lines := fake.sent(); !reflect.DeepEqual(lines, []string{`password "pa\"ss\\word"`, `listplaylistinfo "Mix"`}) {
+		t.Errorf("sent %q", lines)
+	}
+}
+
+func TestMPDCreatePlaylist(t *testing.T) {
+	fake := newFakeMPD(t)
+	playlist := []Track{{Title: "Song (feat. Guest)", Artist: "Artist"}, {Title: "Nothing", Artist: "Nobody"}}
+	result := NewMPDPlaylist(playlistRef{Service: MPD}).WritePlaylist("Mix", playlist, newMatchCache())
+	want := []string{
+		`password "pa\"ss\\word"`,
+		"listplaylists",
+		`search "title" "song"`,
+		`search "title" "Nothing"`,
+		"command_list_begin",
+		`playlistadd "Mix #3" "a/song.flac"`,
+		"command_list_end",
+	}
+	if lines := fake.sent(); !reflect.DeepEqual(lines, want) {
+		t.Errorf("sent %q, want %q", lines, want)
+	}
+	if result.Name != "Mix #3" || result.Added != 1 || !reflect.DeepEqual(result.NotFound, []string{"Nothing - Nobody"}) {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestMPDErrors(t *testing.T) {
+	newFakeMPD(t)
+	conn := dialMPD()
+	defer conn.close()
+	if _, err := conn.call("listplaylistinfo", "Missing"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("an ACK response gave %v", err)
+	}
+	if pairs, err := conn.call("listplaylists"); err != nil || len(pairs) != 3 {
+		t.Errorf("after an ACK the connection read %v, %v", pairs, err)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// plexConfig holds the server and account token of a Plex Media Server.
+// Section is the key of the music library, the first one is used when it
+// is not set.
+type plexConfig struct {
+	URL     string `json:"url"`
+	Token   string `json:"token"`
+	Section string `json:"section"`
+}
+
+const (
+	plexTrackType = "10" //the metadata type of tracks
+	plexBatchSize = 100  //items added to a playlist per request
+)
+
+// Plex reads and writes the audio playlists on a Plex Media Server.
+type Plex struct {
+	ID string
+}
+
+func NewPlex(ref playlistRef) *Plex {
+	return &Plex{ID: ref.ID}
+}
+
+type plexMetadata struct {
+	RatingKey        string `json:"ratingKey"`
+	Title            string `json:"title"`
+	Summary          string `json:"summary"`
+	GrandparentTitle string `json:"grandparentTitle"` //the album artist of a track
+	OriginalTitle    string `json:"originalTitle"`    //the track artist, when it differs from the album artist
+	ParentTitle      string `json:"parentTitle"`      //the album of a track
+	Duration         int64  `json:"duration"`         //milliseconds
+	LeafCount        int    `json:"leafCount"`
+	Smart            bool   `json:"smart"`
+	Guid             []struct {
+		ID string `json:"id"`
+	} `json:"Guid"`
+}
+
+type plexContainer struct {
+	MediaContainer struct {
+		MachineIdentifier string         `json:"machineIdentifier"`
+		Metadata          []plexMetadata `json:"Metadata"`
+		Directory         []struct {
+			Key   string `json:"key"`
+			Type  string `json:"type"`
+			Title string `json:"title"`
+		} `json:"Directory"`
+	} `json:"MediaContainer"`
+}
+
+// plexAPI calls a server with the configured token.
+type plexAPI struct {
+	client *http.Client
+	base   string
+}
+
+func (P *Plex) GetTracks() []Track {
+	if P.ID == likedID || P.ID == albumsID {
+		log.Fatalf("Plex has no liked songs, convert from a plex playlist")
+	}
+	api := newPlexAPI()
+	var items plexContainer
+	api.request("GET", "/playlists/"+url.PathEscape(P.ID)+"/items?includeGuids=1", &items)
+	var tracks []Track
+	for _, item := range items.MediaContainer.Metadata {
+		track := plexTrack(item)
+		tracks = append(tracks, track)
+		fmt.Printf("%v, (%v)\n", track.Query(), track.ID)
+	}
+	return tracks
+}
+
+// WritePlaylist creates a new playlist.
+func (P *Plex) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	switch P.ID {
+	case "":
+		return createPlexPlaylist(name, tracks, matches)
+	case likedID, albumsID:
+		log.Fatalf("Plex has no liked songs or saved albums, convert to plex to make a playlist")
+	}
+	log.Fatalf("Adding to an existing Plex playlist is not supported")
+	return ConversionResult{}
+}
+
+// plexTrack builds a Track from a library item. Tracks matched by Plex's
+// music agent carry their MusicBrainz ID as an mbid:// GUID.
+func plexTrack(item plexMetadata) Track {
+	track := Track{
+		Title:    item.Title,
+		Artist:   item.OriginalTitle,
+		Album:    item.ParentTitle,
+		Duration: time.Duration(item.Duration) * time.Millisecond,
+		ID:       item.RatingKey,
+	}
+	if track.Artist == "" {
+		track.Artist = item.GrandparentTitle
+	}
+	for _, guid := range item.Guid {
+		if id := strings.TrimPrefix(guid.ID, "mbid://"); id != guid.ID {
+			track.MusicBrainzID = id
+		}
+	}
+	return track
+}
+
+func newPlexAPI() *plexAPI {
+	config := settings.Plex
+	if config.URL == "" || config.Token == "" {
+		log.Fatalf("Set plex.url and plex.token in %s", configFile)
+	}
+	return &plexAPI{
+		client: &http.Client{Transport: &headerTransport{header: http.Header{"X-Plex-Token": {config.Token}}}},
+		base:   strings.TrimSuffix(config.URL, "/"),
+	}
+}
+
+func (api *plexAPI) request(method string, path string, out interface{}) {
+	err := apiRequest(api.client, method, api.base+path, nil, nil, out)
+	handleError(err, "Plex request failed")
+}
+
+func (api *plexAPI) musicSection() string { //the key of the configured music library, or of the first one
+	if settings.Plex.Section != "" {
+		return settings.Plex.Section
+	}
+	var sections plexContainer
+	api.request("GET", "/library/sections", &sections)
+	for _, section := range sections.MediaContainer.Directory {
+		if section.Type == "artist" {
+			return section.Key
+		}
+	}
+	log.Fatalf("Plex has no music library")
+	return ""
+}
+
+// searchPlexTracks finds each song in the music library with a title
+// filter on its tracks, looking up the library the first time it is needed.
+func searchPlexTracks(api *plexAPI, playlist []Track, result *ConversionResult, matches *matchCache) []string {
+	section := ""
+	return matches.find(PLEX, playlist, result, func(track Track) string {
+		if section == "" {
+			section = api.musicSection()
+		}
+		match, _ := matchSearch(track, func(title string) []Track {
+			var found plexContainer
+			api.request("GET", "/library/sections/"+section+"/all?"+url.Values{
+				"type":         {plexTrackType},
+				"title":        {title},
+				"includeGuids": {"1"},
+			}.Encode(), &found)
+			var candidates []Track
+			for _, item := range found.MediaContainer.Metadata {
+				candidates = append(candidates, plexTrack(item))
+			}
+			return candidates
+		})
+		return match.ID
+	})
+}
+
+// createPlexPlaylist creates a playlist called name. Plex playlists cannot
+// be created empty, so it is created with the first batch of tracks and
+// the rest are added to it.
+func createPlexPlaylist(name string, playlist []Track, matches *matchCache) ConversionResult {
+	api := newPlexAPI()
+	result := ConversionResult{Name: name, Destination: "plex", Total: len(playlist)}
+	ratingKeys := searchPlexTracks(api, playlist, &result, matches)
+	if len(ratingKeys) == 0 {
+		fmt.Println("None of the songs are in the Plex library, no playlist was created")
+		return result
+	}
+	var server plexContainer
+	api.request("GET", "/", &server)
+	playlistId := ""
+	addInBatches(ratingKeys, plexBatchSize, &result, func(batch []string) {
+		uri := fmt.Sprintf("server://%s/com.plexapp.plugins.library/library/metadata/%s", server.MediaContainer.MachineIdentifier, strings.Join(batch, ","))
+		if playlistId == "" {
+			var created plexContainer
+			api.request("POST", "/playlists?"+url.Values{"type": {"audio"}, "title": {name}, "smart": {"0"}, "uri": {uri}}.Encode(), &created)
+			if len(created.MediaContainer.Metadata) == 0 {
+				log.Fatalf("Plex did not return the new playlist")
+			}
+			playlistId = created.MediaContainer.Metadata[0].RatingKey
+			return
+		}
+		api.request("PUT", "/playlists/"+playlistId+"/items?"+url.Values{"uri": {uri}}.Encode(), nil)
+	})
+	fmt.Println("Added songs to Plex")
+	return result
+}
+
+func plexSummary(item plexMetadata) playlistSummary {
+	return playlistSummary{
+		ID:          item.RatingKey,
+		Name:        item.Title,
+		Description: item.Summary,
+		Owned:       true, //Plex copies a playlist shared with another account, so every playlist listed is the account's own
+		Tracks:      item.LeafCount,
+	}
+}
+
+func listPlexPlaylists(includeFollowed bool) []playlistSummary { //lists the audio playlists, leaving out smart playlists, which are rules rather than songs
+	api := newPlexAPI()
+	var found plexContainer
+	api.request("GET", "/playlists?playlistType=audio", &found)
+	var playlists []playlistSummary
+	for _, item := range found.MediaContainer.Metadata {
+		if !item.Smart {
+			playlists = append(playlists, plexSummary(item))
+		}
+	}
+	return playlists
+}
+
+func plexPlaylistSummary(ref playlistRef) playlistSummary { //gets the name and details of whatever a reference points at
+	api := newPlexAPI()
+	var found plexContainer
+	api.request("GET", "/playlists/"+url.PathEscape(ref.ID), &found)
+	if len(found.MediaContainer.Metadata) == 0 {
+		log.Fatalf("Plex playlist %s not found", ref.ID)
+	}
+	return plexSummary(found.MediaContainer.Metadata[0])
+}
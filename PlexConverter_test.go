@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestPlexSearchCleansTitles checks that songs are searched for without
+// featured artists and video tags, since Plex's title filter only matches
+// part of a title, so "Song (feat. Guest)" and an "Artist - Song (Official
+// Video)" video still find the track tagged "Song".
+func TestPlexSearchCleansTitles(t *testing.T) {
+	library := []plexMetadata{{RatingKey: "1", Title: "Song", GrandparentTitle: "Artist"}, {RatingKey: "2", Title: "Other Song", GrandparentTitle: "Someone"}}
+	var searches []string
+	url := standIn(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.Header.Get("X-Plex-Token") != "token" || r.URL.Path != "/library/sections/3/all" || query.Get("type") != plexTrackType {
+			http.NotFound(w, r)
+			return
+		}
+		title := query.Get("title")
+		searches = append(searches, title)
+		var found plexContainer
+		for _, item := range library {
+			if strings.Contains(strings.ToLower(item.Title), strings.ToLower(title)) {
+				found.MediaContainer.Metadata = append(found.MediaContainer.Metadata, item)
+			}
+		}
+		json.NewEncoder(w).Encode(found)
+	}))
+	settings.Plex = plexConfig{URL: url, Token: "token", Section: "3"}
+
+	playlist := []Track{{Title: "Song (feat. Guest)", Artist: "Artist"}, {Title: "Artist - Song (Official Video)"}, {Title: "Missing", Artist: "Nobody"}}
+	result := ConversionResult{Total: len(playlist)}
+	ids := searchPlexTracks(newPlexAPI(), playlist, &result, newMatchCache())
+	if !reflect.DeepEqual(ids, []string{"1", "1"}) || !reflect.DeepEqual(result.NotFound, []string{"Missing - Nobody"}) {
+		t.Errorf("found %v, not found %v", ids, result.NotFound)
+	}
+	if want := []string{"song", "song", "Missing"}; !reflect.DeepEqual(searches, want) {
+		t.Errorf("searched for %q, want %q", searches, want)
+	}
+}
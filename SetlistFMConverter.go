@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// setlistFMConfig holds the setlist.fm API key. The base URL can point at
+// another server that answers the same API, such as a local mirror.
+type setlistFMConfig struct {
+	APIKey       string `json:"apiKey"`
+	BaseURL      string `json:"baseUrl"`
+	IncludeTapes bool   `json:"includeTapes"` //also convert the music played from tape, e.g. intros
+}
+
+const setlistFMDefaultURL = "https://api.setlist.fm/rest"
+
+type setlistFMArtist struct {
+	MBID string `json:"mbid"`
+	Name string `json:"name"`
+}
+
+type setlistFMSetlist struct {
+	ID        string          `json:"id"`
+	EventDate string          `json:"eventDate"`
+	Artist    setlistFMArtist `json:"artist"`
+	Venue     struct {
+		Name string `json:"name"`
+		City struct {
+			Name string `json:"name"`
+		} `json:"city"`
+	} `json:"venue"`
+	Sets struct {
+		Set []struct {
+			Name   string          `json:"name"`
+			Encore int             `json:"encore"`
+			Song   []setlistFMSong `json:"song"`
+		} `json:"set"`
+	} `json:"sets"`
+}
+
+type setlistFMSong struct {
+	Name  string           `json:"name"`
+	Cover *setlistFMArtist `json:"cover"` //the original artist of a cover
+	With  *setlistFMArtist `json:"with"`  //a guest performer
+	Info  string           `json:"info"`
+	Tape  bool             `json:"tape"`
+}
+
+// SetlistFM reads the songs played at a concert from setlist.fm, by the
+// setlist ID from its link, or from a setlist saved from the API as JSON.
+// Covers are credited to the original artist, since that is the recording
+// the services have, and tapes are left out unless includeTapes is set.
+type SetlistFM struct {
+	ID string
+}
+
+func NewSetlistFM(ref playlistRef) *SetlistFM {
+	return &SetlistFM{ID: ref.ID}
+}
+
+func (S *SetlistFM) GetTracks() []Track {
+	var data []byte
+	var err error
+	if _, statErr := os.Stat(S.ID); statErr == nil {
+		data, err = os.ReadFile(S.ID)
+	} else {
+		data, err = fetchSetlist(S.ID)
+	}
+	handleError(err, "Unable to read setlist")
+	setlist, err := parseSetlist(data)
+	handleError(err, "Invalid setlist")
+	fmt.Printf("%s at %s, %s on %s\n", setlist.Artist.Name, setlist.Venue.Name, setlist.Venue.City.Name, setlist.EventDate)
+	return setlistTracks(setlist, settings.SetlistFM.IncludeTapes)
+}
+
+func fetchSetlist(id string) ([]byte, error) { //gets a setlist from the API
+	if settings.SetlistFM.APIKey == "" {
+		log.Fatalf("Set setlistfm.apiKey in %s to read setlists from setlist.fm, or give a saved setlist file", configFile)
+	}
+	base := settings.SetlistFM.BaseURL
+	if base == "" {
+		base = setlistFMDefaultURL
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(base, "/")+"/1.0/setlist/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-api-key", settings.SetlistFM.APIKey)
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("setlist.fm returned %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseSetlist reads a single setlist, or the first of a search result
+// saved from the API.
+func parseSetlist(data []byte) (setlistFMSetlist, error) {
+	var search struct {
+		Setlist []setlistFMSetlist `json:"setlist"`
+	}
+	if err := json.Unmarshal(data, &search); err == nil && len(search.Setlist) > 0 {
+		if len(search.Setlist) > 1 {
+			fmt.Printf("The file has %d setlists, converting the first\n", len(search.Setlist))
+		}
+		return search.Setlist[0], nil
+	}
+	var setlist setlistFMSetlist
+	err := json.Unmarshal(data, &setlist)
+	if err == nil && setlist.Artist.Name == "" {
+		err = fmt.Errorf("no setlist found")
+	}
+	return setlist, err
+}
+
+func setlistTracks(setlist setlistFMSetlist, includeTapes bool) []Track { //the songs played in order, encores included
+	var tracks []Track
+	for _, set := range setlist.Sets.Set {
+		if set.Encore > 0 {
+			fmt.Printf("Encore %d\n", set.Encore)
+		}
+		for _, song := range set.Song {
+			if song.Name == "" || (song.Tape && !includeTapes) { //unidentified songs have no name
+				continue
+			}
+			track := Track{Title: song.Name, Artist: setlist.Artist.Name}
+			if song.Cover != nil && song.Cover.Name != "" {
+				track.Artist = song.Cover.Name
+			}
+			tracks = append(tracks, track)
+			fmt.Println(track.Query())
+		}
+	}
+	return tracks
+}
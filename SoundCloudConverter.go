@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// soundCloudConfig holds the SoundCloud app used to sign in.
+type soundCloudConfig struct {
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	BaseURL      string `json:"baseUrl"`
+	AuthURL      string `json:"authUrl"`
+}
+
+const (
+	soundCloudDefaultURL     = "https://api.soundcloud.com"
+	soundCloudDefaultAuthURL = "https://secure.soundcloud.com"
+	soundCloudPageLimit      = 200
+	soundCloudSetLimit       = 500 //the most tracks a set can hold
+	soundCloudSearchResults  = 5   //results compared to pick the best match
+)
+
+// soundCloudTitleNoise is what uploaders add to track titles on top of the
+// tags cleanVideoTitle already removes.
+var soundCloudTitleNoise = regexp.MustCompile(`(?i)\s*[\[(](free (download|dl)|out now|buy\s*=?\s*free( download)?|premiere|exclusive)[^\])]*[\])]|\s*\|\s*free (download|dl).*$`)
+
+// SoundCloud reads sets, a user's tracks and the user's likes from
+// SoundCloud, and writes new sets or likes. References are the path of a
+// link, such as <user>/sets/<set>, or a numeric set ID.
+type SoundCloud struct {
+	ID   string
+	Kind refKind
+}
+
+func NewSoundCloud(ref playlistRef) *SoundCloud {
+	return &SoundCloud{ID: ref.ID, Kind: ref.Kind}
+}
+
+type soundCloudTrack struct {
+	ID           int64  `json:"id"`
+	Title        string `json:"title"`
+	Duration     int64  `json:"duration"` //milliseconds
+	PermalinkURL string `json:"permalink_url"`
+	User         struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	PublisherMetadata *struct {
+		Artist     string `json:"artist"`
+		ISRC       string `json:"isrc"`
+		AlbumTitle string `json:"album_title"`
+	} `json:"publisher_metadata"`
+}
+
+type soundCloudPlaylist struct {
+	ID          int64  `json:"id"`
+	Kind        string `json:"kind"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Sharing     string `json:"sharing"`
+	TrackCount  int    `json:"track_count"`
+	User        struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+// soundCloudAPI is a client for the SoundCloud API signed in as the user.
+type soundCloudAPI struct {
+	client *http.Client
+	base   string
+}
+
+func (S *SoundCloud) GetTracks() []Track {
+	api := newSoundCloudAPI()
+	var path string
+	switch {
+	case S.ID == likedID:
+		path = "/me/likes/tracks"
+	case strings.Trim(S.ID, "0123456789") == "":
+		path = "/playlists/" + S.ID + "/tracks"
+	default:
+		var resource soundCloudPlaylist
+		api.request("GET", "/resolve?url="+url.QueryEscape("https://soundcloud.com/"+S.ID), nil, &resource)
+		path = fmt.Sprintf("/playlists/%d/tracks", resource.ID)
+		if resource.Kind == "user" {
+			path = fmt.Sprintf("/users/%d/tracks", resource.ID)
+		}
+	}
+	var tracks []Track
+	for _, item := range api.allTracks(path) {
+		track := soundCloudTrackFrom(item)
+		tracks = append(tracks, track)
+		fmt.Printf("%v, (%v)\n", track.Query(), track.ID)
+	}
+	return tracks
+}
+
+// WritePlaylist creates a new set, or likes the tracks when the reference
+// is to the liked songs.
+func (S *SoundCloud) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	switch S.ID {
+	case "":
+		return createSoundCloudSet(name, tracks, matches)
+	case likedID:
+		return likeSoundCloudTracks(tracks, matches)
+	case albumsID:
+		log.Fatalf("SoundCloud has no saved albums, convert to soundcloud to make a set")
+	}
+	log.Fatalf("Adding to an existing SoundCloud set is not supported")
+	return ConversionResult{}
+}
+
+// soundCloudTrackFrom builds a Track from an upload. Releases from labels
+// and distributors have publisher metadata, anything else is split into
+// artist and title the way most uploaders title tracks, "Artist - Title",
+// or credited to the uploader.
+func soundCloudTrackFrom(item soundCloudTrack) Track {
+	track := Track{
+		ID:       strconv.FormatInt(item.ID, 10),
+		Location: item.PermalinkURL,
+		Duration: time.Duration(item.Duration) * time.Millisecond,
+	}
+	title := strings.TrimSpace(soundCloudTitleNoise.ReplaceAllString(item.Title, ""))
+	if meta := item.PublisherMetadata; meta != nil && meta.Artist != "" {
+		track.Artist, track.ISRC, track.Album = meta.Artist, meta.ISRC, meta.AlbumTitle
+		if artist, rest, found := strings.Cut(title, " - "); found && strings.EqualFold(artist, meta.Artist) {
+			title = rest
+		}
+		track.Title = title
+		return track
+	}
+	if artist, rest, found := strings.Cut(title, " - "); found {
+		track.Artist, track.Title = strings.TrimSpace(artist), strings.TrimSpace(rest)
+		return track
+	}
+	track.Artist, track.Title = item.User.Username, title
+	return track
+}
+
+func newSoundCloudAPI() *soundCloudAPI {
+	config := settings.SoundCloud
+	if config.ClientID == "" {
+		log.Fatalf("Set soundcloud.clientId and soundcloud.clientSecret in %s", configFile)
+	}
+	authBase := strings.TrimSuffix(config.AuthURL, "/")
+	if authBase == "" {
+		authBase = soundCloudDefaultAuthURL
+	}
+	oauthConfig := &oauth2.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		Endpoint:     oauth2.Endpoint{AuthURL: authBase + "/authorize", TokenURL: authBase + "/oauth/token", AuthStyle: oauth2.AuthStyleInParams},
+		RedirectURL:  "http://localhost:8080",
+	}
+	cacheFile, err := tokenCacheFile("soundcloud")
+	if err != nil {
+		log.Fatalf("Unable to get path to cached credential file. %v", err)
+	}
+	tok, err := tokenFromFile(cacheFile)
+	if err != nil {
+		tok = soundCloudToken(oauthConfig)
+		saveToken(cacheFile, tok)
+	}
+	api := &soundCloudAPI{client: soundCloudClient(oauthConfig, tok), base: strings.TrimSuffix(config.BaseURL, "/")}
+	if api.base == "" {
+		api.base = soundCloudDefaultURL
+	}
+	return api
+}
+
+// soundCloudClient sends requests with the access token, refreshing it when
+// it expires. The token is added by the oauth2 transport first, so the
+// header is rewritten after it.
+func soundCloudClient(config *oauth2.Config, tok *oauth2.Token) *http.Client {
+	return &http.Client{Transport: &oauth2.Transport{
+		Source: config.TokenSource(context.Background(), tok),
+		Base:   &soundCloudAuthTransport{base: http.DefaultTransport},
+	}}
+}
+
+// soundCloudToken signs in with the authorization code flow and PKCE, which
+// SoundCloud requires: the code is only exchanged along with the random
+// verifier whose hash went with the sign in link. A separate random state
+// ties the redirect back to this sign in.
+func soundCloudToken(config *oauth2.Config) *oauth2.Token {
+	verifier, err := randomToken(32)
+	handleError(err, "Unable to generate PKCE verifier")
+	state, err := randomToken(16)
+	handleError(err, "Unable to generate OAuth state")
+	challenge := sha256.Sum256([]byte(verifier))
+	authURL := config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+
+	listener, err := net.Listen("tcp", "localhost:8080")
+	handleError(err, "Unable to start a web server")
+	codeCh := make(chan string)
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("state") != state { //not the redirect of this sign in
+			http.Error(w, "This sign in link has expired, sign in again from the link in the terminal.", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "Signed in to SoundCloud, you can now safely close this browser window.")
+		codeCh <- r.FormValue("code")
+	}))
+	if err := openURL(authURL); err != nil {
+		fmt.Println("Open this link in your browser to sign in to SoundCloud:")
+	}
+	fmt.Println(authURL)
+	code := <-codeCh
+	listener.Close()
+	tok, err := config.Exchange(context.Background(), code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	handleError(err, "Unable to retrieve SoundCloud token")
+	return tok
+}
+
+func randomToken(size int) (string, error) { //size random bytes, base64url encoded
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// soundCloudAuthTransport sends the access token the way SoundCloud's
+// documentation asks, as OAuth rather than Bearer.
+type soundCloudAuthTransport struct {
+	base http.RoundTripper
+}
+
+func (t *soundCloudAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "); token != req.Header.Get("Authorization") {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "OAuth "+token)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (api *soundCloudAPI) request(method string, path string, body interface{}, out interface{}) {
+	err := apiRequest(api.client, method, api.base+path, nil, body, out)
+	handleError(err, "SoundCloud request failed")
+}
+
+func (api *soundCloudAPI) allTracks(path string) []soundCloudTrack { //follows the next links of a paged list
+	var tracks []soundCloudTrack
+	target := fmt.Sprintf("%s%s?linked_partitioning=true&limit=%d", api.base, path, soundCloudPageLimit)
+	for target != "" {
+		var page struct {
+			Collection []soundCloudTrack `json:"collection"`
+			NextHref   string            `json:"next_href"`
+		}
+		err := apiRequest(api.client, "GET", target, nil, nil, &page)
+		handleError(err, "SoundCloud request failed")
+		tracks = append(tracks, page.Collection...)
+		target = page.NextHref
+	}
+	return tracks
+}
+
+// bestSoundCloudMatch picks the search result that is most likely the song
+// rather than a remix, cover or mix that happens to match the search: the
+// title has to hold the song's title, and the artist has to be in the
+// title or be the uploader.
+func bestSoundCloudMatch(track Track, results []soundCloudTrack) (soundCloudTrack, bool) {
+	title, artist := normaliseName(track.Title), normaliseName(leadArtist(track.Artist))
+	best, bestScore := soundCloudTrack{}, 0
+	for _, result := range results {
+		candidate := soundCloudTrackFrom(result)
+		candidateTitle := normaliseName(candidate.Title)
+		score := 0
+		switch {
+		case candidateTitle == title:
+			score = 3
+		case strings.Contains(candidateTitle, title):
+			score = 1
+		case artist == "" && strings.Contains(title, candidateTitle): //a video title, holding the artist as well
+			score = 1
+		}
+		if score > 0 && artist != "" && (strings.Contains(normaliseName(candidate.Artist), artist) || strings.Contains(normaliseName(result.User.Username), artist)) {
+			score += 2
+		}
+		if score > bestScore {
+			best, bestScore = result, score
+		}
+	}
+	return best, bestScore > 0
+}
+
+// searchSoundCloudTracks finds the SoundCloud track for each song, picking
+// the best of the first few search results.
+func searchSoundCloudTracks(api *soundCloudAPI, playlist []Track, result *ConversionResult, matches *matchCache) []int64 {
+	found := matches.find(SOUNDCLOUD, playlist, result, func(track Track) string {
+		var search struct {
+			Collection []soundCloudTrack `json:"collection"`
+		}
+		term := cleanVideoTitle(strings.TrimSpace(track.Artist + " " + track.Title))
+		api.request("GET", fmt.Sprintf("/tracks?linked_partitioning=true&limit=%d&q=%s", soundCloudSearchResults, url.QueryEscape(term)), nil, &search)
+		if best, ok := bestSoundCloudMatch(track, search.Collection); ok {
+			return strconv.FormatInt(best.ID, 10)
+		}
+		return ""
+	})
+	var trackIds []int64
+	for _, trackId := range found {
+		id, _ := strconv.ParseInt(trackId, 10, 64)
+		trackIds = append(trackIds, id)
+	}
+	return trackIds
+}
+
+// createSoundCloudSet creates a private set called name with the tracks
+// found, splitting them over several sets when there are more than a set
+// can hold. Each set is created empty and then updated with its tracks.
+func createSoundCloudSet(name string, playlist []Track, matches *matchCache) ConversionResult {
+	api := newSoundCloudAPI()
+	result := ConversionResult{Name: name, Destination: "soundcloud", Total: len(playlist)}
+	trackIds := searchSoundCloudTracks(api, playlist, &result, matches)
+	for part := 1; part == 1 || len(trackIds) > 0; part++ {
+		batch := trackIds
+		if len(batch) > soundCloudSetLimit {
+			batch = batch[:soundCloudSetLimit]
+		}
+		title := name
+		if part > 1 {
+			title = fmt.Sprintf("%s #%d", name, part)
+		}
+		var created soundCloudPlaylist
+		api.request("POST", "/playlists", map[string]interface{}{
+			"playlist": map[string]string{"title": title, "sharing": "private"},
+		}, &created)
+		tracks := []map[string]int64{}
+		for _, id := range batch {
+			tracks = append(tracks, map[string]int64{"id": id})
+		}
+		api.request("PUT", fmt.Sprintf("/playlists/%d", created.ID), map[string]interface{}{
+			"playlist": map[string]interface{}{"tracks": tracks},
+		}, nil)
+		result.Added += len(batch)
+		trackIds = trackIds[len(batch):]
+	}
+	fmt.Println("Added songs to SoundCloud")
+	return result
+}
+
+func likeSoundCloudTracks(playlist []Track, matches *matchCache) ConversionResult { //likes the tracks instead of adding them to a set
+	api := newSoundCloudAPI()
+	result := ConversionResult{Name: "Likes", Destination: "soundcloud:liked", Total: len(playlist)}
+	for _, id := range searchSoundCloudTracks(api, playlist, &result, matches) {
+		api.request("POST", fmt.Sprintf("/likes/tracks/%d", id), nil, nil)
+		result.Added++
+	}
+	fmt.Println("Liked songs on SoundCloud")
+	return result
+}
+
+func soundCloudSummary(item soundCloudPlaylist, userId int64) playlistSummary {
+	return playlistSummary{
+		ID:          strconv.FormatInt(item.ID, 10),
+		Name:        item.Title,
+		Description: item.Description,
+		Owner:       item.User.Username,
+		Owned:       item.User.ID == userId,
+		Public:      item.Sharing == "public",
+		Tracks:      item.TrackCount,
+	}
+}
+
+func listSoundCloudPlaylists(includeFollowed bool) []playlistSummary { //lists the user's sets, and the sets they liked when includeFollowed is set
+	api := newSoundCloudAPI()
+	var me struct {
+		ID int64 `json:"id"`
+	}
+	api.request("GET", "/me", nil, &me)
+	paths := []string{"/me/playlists"}
+	if includeFollowed {
+		paths = append(paths, "/me/likes/playlists")
+	}
+	var playlists []playlistSummary
+	for _, path := range paths {
+		target := fmt.Sprintf("%s%s?linked_partitioning=true&show_tracks=false&limit=%d", api.base, path, soundCloudPageLimit)
+		for target != "" {
+			var page struct {
+				Collection []soundCloudPlaylist `json:"collection"`
+				NextHref   string               `json:"next_href"`
+			}
+			err := apiRequest(api.client, "GET", target, nil, nil, &page)
+			handleError(err, "SoundCloud request failed")
+			for _, item := range page.Collection {
+				playlists = append(playlists, soundCloudSummary(item, me.ID))
+			}
+			target = page.NextHref
+		}
+	}
+	return playlists
+}
+
+func soundCloudPlaylistSummary(ref playlistRef) playlistSummary { //gets the name and details of whatever a reference points at
+	if ref.ID == likedID {
+		return playlistSummary{ID: likedID, Name: "Likes", Owned: true}
+	}
+	api := newSoundCloudAPI()
+	var item soundCloudPlaylist
+	if strings.Trim(ref.ID, "0123456789") == "" {
+		api.request("GET", "/playlists/"+ref.ID+"?show_tracks=false", nil, &item)
+	} else {
+		api.request("GET", "/resolve?url="+url.QueryEscape("https://soundcloud.com/"+ref.ID), nil, &item)
+	}
+	if item.Kind == "user" {
+		var user struct {
+			Username string `json:"username"`
+		}
+		api.request("GET", fmt.Sprintf("/users/%d", item.ID), nil, &user)
+		return playlistSummary{ID: ref.ID, Name: user.Username, Owner: user.Username}
+	}
+	return soundCloudSummary(item, -1)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// TestSoundCloudAuthorization checks that the access token reaches the
+// server as OAuth rather than the Bearer header the oauth2 package sends.
+func TestSoundCloudAuthorization(t *testing.T) {
+	var got string
+	url := standIn(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	client := soundCloudClient(&oauth2.Config{}, &oauth2.Token{AccessToken: "secret", TokenType: "Bearer"})
+	if err := apiRequest(client, "GET", url+"/me", nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got != "OAuth secret" {
+		t.Errorf("the server got Authorization %q, want OAuth secret", got)
+	}
+}
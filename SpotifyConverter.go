@@ -9,8 +9,9 @@ import (
 	"golang.org/x/oauth2"
 	"io/ioutil"
 	"log"
-	"math"
 	"net/http"
+	"strings"
+	"time"
 )
 
 func SpotifyConfigFromJSON(jsonKey []byte, scope ...string) (*oauth2.Config, error) {
@@ -52,13 +53,26 @@ func SpotifyConfigFromJSON(jsonKey []byte, scope ...string) (*oauth2.Config, err
 		},
 	}, nil
 }
-func getSpotifyClient(scope string) *http.Client {
+
+// spotifyScopes are requested on every Spotify login. The token is cached
+// per service, so a token cached for one step of a conversion has to carry
+// the scopes every other step needs as well.
+var spotifyScopes = []string{
+	spotify.ScopeUserReadPrivate,
+	spotify.ScopePlaylistReadPrivate,
+	spotify.ScopePlaylistReadCollaborative,
+	spotify.ScopePlaylistModifyPrivate,
+	spotify.ScopeUserLibraryRead,
+	spotify.ScopeUserLibraryModify,
+}
+
+func getSpotifyClient() *http.Client {
 	ctx := context.Background()
 	b, err := ioutil.ReadFile("spotifyClientSecret.json")
 	if err != nil {
 		log.Fatalf("Unable to read spotify client secret file: %v", err)
 	}
-	config, err := SpotifyConfigFromJSON(b, scope)
+	config, err := SpotifyConfigFromJSON(b, spotifyScopes...)
 	if err != nil {
 		log.Fatalf("Unable to parse client secret file to config: %v", err)
 	}
@@ -88,124 +102,375 @@ func getSpotifyClient(scope string) *http.Client {
 	}
 	return config.Client(ctx, tok)
 }
-func spotifyPlaylistItems(service spotify.Client, playlistId spotify.ID) []string { //gets list of spotify tracks in a playlist
-	var spotifyPlaylistItemsList []string
+func spotifyPlaylistItems(service spotify.Client, playlistId spotify.ID) []Track { //gets list of spotify tracks in a playlist
+	var spotifyPlaylistItemsList []Track
+	maxResult := 50 //Spotify can only grab 50 items at a time from a playlist, if the playlist is bigger than 50 items it iterates through multiple pages
+	itemsPage := 0
+	options := spotify.Options{
+		Limit:  &maxResult,
+		Offset: &itemsPage,
+	}
+	for {
+		playlistTracks, err := service.GetPlaylistTracksOpt(playlistId, &options, "total,items(track(id,name,artists(name),album(name),duration_ms,external_ids))")
+		if err != nil {
+			log.Fatalf("Retrieving Playlist failed")
+		}
+		for _, songInfo := range playlistTracks.Tracks {
+			spotifyPlaylistItemsList = append(spotifyPlaylistItemsList, spotifyTrack(songInfo.Track))
+		}
+		itemsPage += maxResult //next page of results
+		if itemsPage >= playlistTracks.Total {
+			break
+		}
+	}
+	return spotifyPlaylistItemsList
+}
+func spotifyTrack(songInfo spotify.FullTrack) Track { //converts a spotify track to a Track
+	track := spotifySimpleTrack(songInfo.SimpleTrack, songInfo.Album.Name)
+	track.ISRC = songInfo.ExternalIDs["isrc"]
+	return track
+}
+func spotifySimpleTrack(songInfo spotify.SimpleTrack, album string) Track { //converts a spotify track without album details, such as one from an album's track list, to a Track
+	track := Track{
+		Title:    songInfo.Name,
+		Album:    album,
+		Duration: time.Duration(songInfo.Duration) * time.Millisecond,
+		ID:       string(songInfo.ID),
+	}
+	if songInfo.ID != "" { //local files have no ID
+		track.Location = "https://open.spotify.com/track/" + string(songInfo.ID)
+	}
+	if len(songInfo.Artists) > 0 { //local files and removed tracks have no artist to search with
+		track.Artist = songInfo.Artists[0].Name
+	}
+	return track
+}
+func spotifyAlbumTracks(service spotify.Client, albumId spotify.ID) []Track { //gets the tracks on an album, in order
+	var albumTracks []Track
+	album, err := service.GetAlbum(albumId)
+	if err != nil {
+		log.Fatalf("Retrieving Album failed: %v", err)
+	}
 	maxResult := 50
 	itemsPage := 0
 	options := spotify.Options{
 		Limit:  &maxResult,
 		Offset: &itemsPage,
 	}
-	playlistTracks, err := service.GetPlaylistTracksOpt(playlistId, &options, "total,items(track(name,artists))") //returns only the track name and artist
+	page := &album.Tracks
+	for {
+		for _, songInfo := range page.Tracks {
+			albumTracks = append(albumTracks, spotifySimpleTrack(songInfo, album.Name))
+		}
+		itemsPage += len(page.Tracks)
+		if len(page.Tracks) == 0 || itemsPage >= page.Total {
+			break
+		}
+		page, err = service.GetAlbumTracksOpt(albumId, &options)
+		if err != nil {
+			log.Fatalf("Retrieving Album failed: %v", err)
+		}
+	}
+	return albumTracks
+}
+func spotifyArtistTopTracks(service spotify.Client, artistId spotify.ID) []Track { //gets an artist's most popular tracks in the user's country
+	var topTracks []Track
+	country := "US"
+	userInfo, err := service.CurrentUser()
+	if err == nil && userInfo.Country != "" {
+		country = userInfo.Country
+	}
+	tracks, err := service.GetArtistsTopTracks(artistId, country)
 	if err != nil {
-		log.Fatalf("Retrieving Playlist failed")
+		log.Fatalf("Retrieving artist top tracks failed: %v", err)
 	}
-	pages := int(math.Ceil(float64(playlistTracks.Total) / 50))
-
-	if playlistTracks.Total > 50 { //Spotify can only grab 50 items at a time from a playlist, if the playlist is bigger than 50 items it iterates through multiple times
-		i := 0
-		x := 0
-		for i <= pages {
-			i++
-			x = 0
-			itemsPage = i * 50 //next page of results
-			for x <= 49 {
-				songInfo := playlistTracks.Tracks[x]
-				songName := songInfo.Track.Name
-				songArtist := songInfo.Track.Artists[0].Name
-				searchQuery := songName + " - " + songArtist //concatenate song name and artist name for more accurate search
-				spotifyPlaylistItemsList = append(spotifyPlaylistItemsList, searchQuery)
-				x++
+	for _, songInfo := range tracks {
+		topTracks = append(topTracks, spotifyTrack(songInfo))
+	}
+	return topTracks
+}
+func spotifyDiscography(service spotify.Client, artistId spotify.ID) []Track { //gets every track on an artist's albums and singles, oldest release last, skipping recordings that are repeated on another release
+	var discography []Track
+	seen := make(map[string]bool)
+	maxResult := 50
+	itemsPage := 0
+	options := spotify.Options{
+		Limit:  &maxResult,
+		Offset: &itemsPage,
+	}
+	for {
+		albums, err := service.GetArtistAlbumsOpt(artistId, &options, spotify.AlbumTypeAlbum, spotify.AlbumTypeSingle)
+		if err != nil {
+			log.Fatalf("Retrieving artist albums failed: %v", err)
+		}
+		for _, album := range albums.Albums {
+			for _, track := range spotifyAddISRCs(service, spotifyAlbumTracks(service, album.ID)) {
+				key := discographyKey(track)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				discography = append(discography, track)
 			}
 		}
-	} else {
-		i := 0
-		for i < playlistTracks.Total {
-			songInfo := playlistTracks.Tracks[i]
-			songName := songInfo.Track.Name
-			songArtist := songInfo.Track.Artists[0].Name
-			searchQuery := songName + " - " + songArtist
-			fmt.Println(songName)
-			spotifyPlaylistItemsList = append(spotifyPlaylistItemsList, searchQuery)
-			i++
+		itemsPage += maxResult
+		if itemsPage >= albums.Total {
+			break
 		}
-
 	}
-	return spotifyPlaylistItemsList
+	return discography
 }
-func createSpotifyPlaylist(playlist []string) { //creates spotify playlist from the text file that is a playlist
-	client := getSpotifyClient(spotify.ScopePlaylistModifyPrivate)
+func spotifyAddISRCs(service spotify.Client, tracks []Track) []Track { //album track lists leave out the ISRC, so it is read from the full tracks, 50 at a time
+	for start := 0; start < len(tracks); start += 50 {
+		end := start + 50
+		if end > len(tracks) {
+			end = len(tracks)
+		}
+		var ids []spotify.ID
+		for _, track := range tracks[start:end] {
+			ids = append(ids, spotify.ID(track.ID))
+		}
+		full, err := service.GetTracks(ids...)
+		if err != nil {
+			log.Fatalf("Retrieving tracks failed: %v", err)
+		}
+		for i, songInfo := range full {
+			if songInfo != nil {
+				tracks[start+i].ISRC = songInfo.ExternalIDs["isrc"]
+			}
+		}
+	}
+	return tracks
+}
+func discographyKey(track Track) string { //the same recording on two releases shares its ISRC, without one the title and length have to do
+	if track.ISRC != "" {
+		return "isrc:" + strings.ToUpper(track.ISRC)
+	}
+	return fmt.Sprintf("%s\x00%d", strings.ToLower(track.Title), track.Duration.Round(time.Second)/time.Second)
+}
+func listSpotifyPlaylists(includeFollowed bool) []playlistSummary { //lists the playlists in the current user's library, skipping ones they only follow unless includeFollowed is set
+	var playlists []playlistSummary
+	client := getSpotifyClient()
 	service := spotify.NewClient(client)
 	userInfo, err := service.CurrentUser()
+	if err != nil {
+		log.Fatalf("Unable to retrieve user info")
+	}
+	maxResult := 50
+	itemsPage := 0
+	options := spotify.Options{
+		Limit:  &maxResult,
+		Offset: &itemsPage,
+	}
+	for {
+		page, err := service.CurrentUsersPlaylistsOpt(&options)
+		if err != nil {
+			log.Fatalf("Unable to list Spotify playlists: %v", err)
+		}
+		for _, item := range page.Playlists {
+			owned := item.Owner.ID == userInfo.ID
+			if !owned && !includeFollowed {
+				continue
+			}
+			playlists = append(playlists, playlistSummary{
+				ID:     string(item.ID),
+				Name:   item.Name,
+				Owner:  item.Owner.DisplayName,
+				Owned:  owned,
+				Public: item.IsPublic,
+				Tracks: int(item.Tracks.Total),
+			})
+		}
+		itemsPage += maxResult
+		if itemsPage >= page.Total {
+			break
+		}
+	}
+	return playlists
+}
+func spotifyLikedSongs(service spotify.Client) []Track { //gets the songs saved to the user's Liked Songs
+	var likedSongs []Track
+	maxResult := 50
+	itemsPage := 0
+	options := spotify.Options{
+		Limit:  &maxResult,
+		Offset: &itemsPage,
+	}
+	for {
+		savedTracks, err := service.CurrentUsersTracksOpt(&options)
+		if err != nil {
+			log.Fatalf("Retrieving Liked Songs failed: %v", err)
+		}
+		for _, songInfo := range savedTracks.Tracks {
+			likedSongs = append(likedSongs, spotifyTrack(songInfo.FullTrack))
+		}
+		itemsPage += maxResult
+		if itemsPage >= savedTracks.Total {
+			break
+		}
+	}
+	return likedSongs
+}
+func spotifyPlaylistSummary(ref playlistRef) playlistSummary { //gets the name and details of whatever a reference points at
+	client := getSpotifyClient()
+	service := spotify.NewClient(client)
+	summary := playlistSummary{ID: ref.ID}
+	if ref.ID == likedID {
+		summary.Name = "Liked Songs"
+		summary.Owned = true
+		return summary
+	}
+	switch ref.Kind {
+	case albumKind:
+		album, err := service.GetAlbum(spotify.ID(ref.ID))
+		if err != nil {
+			log.Fatalf("Retrieving Album failed: %v", err)
+		}
+		summary.Name = album.Name
+		if len(album.Artists) > 0 {
+			summary.Owner = album.Artists[0].Name
+		}
+		summary.Tracks = album.Tracks.Total
+	case artistKind, discographyKind:
+		artist, err := service.GetArtist(spotify.ID(ref.ID))
+		if err != nil {
+			log.Fatalf("Retrieving artist failed: %v", err)
+		}
+		summary.Name = artist.Name
+		summary.Owner = artist.Name
+	default:
+		playlist, err := service.GetPlaylistOpt(spotify.ID(ref.ID), "id,name,description,public,owner(id,display_name),tracks(total)")
+		if err != nil {
+			log.Fatalf("Retrieving Playlist failed: %v", err)
+		}
+		summary.Name = playlist.Name
+		summary.Description = playlist.Description
+		summary.Owner = playlist.Owner.DisplayName
+		summary.Public = playlist.IsPublic
+		summary.Tracks = playlist.Tracks.Total
+	}
+	return summary
+}
+func searchSpotifyTracks(service spotify.Client, playlist []Track, result *ConversionResult, matches *matchCache) []string { //finds the best match for each song, recording the ones that could not be found
 	searchResultLimit := 1
-	spotifyAddTrackLimit := 100
 	options := spotify.Options{
 		Limit: &searchResultLimit,
 	}
-	var spotifyTrackIds []spotify.ID
+	return matches.find(SPOTIFY, playlist, result, func(track Track) string {
+		searchResults, err := service.SearchOpt(track.Query(), spotify.SearchTypeTrack, &options)
+		if err != nil || len(searchResults.Tracks.Tracks) == 0 {
+			return ""
+		}
+		return string(searchResults.Tracks.Tracks[0].ID)
+	})
+}
+func spotifyIDs(ids []string) []spotify.ID { //converts IDs for the spotify package
+	spotifyIds := make([]spotify.ID, len(ids))
+	for i, id := range ids {
+		spotifyIds[i] = spotify.ID(id)
+	}
+	return spotifyIds
+}
+func createSpotifyPlaylist(name string, playlist []Track, matches *matchCache) ConversionResult { //creates a spotify playlist called name from the list of songs
+	client := getSpotifyClient()
+	service := spotify.NewClient(client)
+	userInfo, err := service.CurrentUser()
+	spotifyAddTrackLimit := 100 //spotify allows up to 100 songs to be added at a time
+	result := ConversionResult{Name: name, Destination: "spotify", Total: len(playlist)}
 	if err != nil {
 		log.Fatalf("Unable to retrieve user info")
 	}
 	userId := userInfo.ID
-	playlistInfo, err := service.CreatePlaylistForUser(userId, "Converted Playlist", "", false)
+	playlistInfo, err := service.CreatePlaylistForUser(userId, name, "", false)
 	if err != nil {
 		log.Fatalf("Unable to create playlist")
 	}
 	playlistId := playlistInfo.ID
-	if len(playlist) > spotifyAddTrackLimit { //spotify allows up to 100 songs to be added at a time, this checks if the total playlist length is greater than that and changes accordingly
-		x := 0
-		for i := range playlist {
-			if x < spotifyAddTrackLimit {
-				searchResults, err := service.SearchOpt(playlist[i], spotify.SearchTypeTrack, &options)
-				if err != nil {
-					fmt.Printf("%s : not found/n", playlist[i])
-					continue
-				}
-				searchResultsLen := len(searchResults.Tracks.Tracks)
-
-				if searchResultsLen != 0 {
-					songId := searchResults.Tracks.Tracks[0].ID
-					spotifyTrackIds = append(spotifyTrackIds, songId)
-					x++
-					continue
-				} else {
-					fmt.Printf("%s : not found/n", playlist[i])
-					x++
-					continue
-				}
-			} else {
-				snapshotId, err := service.AddTracksToPlaylist(playlistId, spotifyTrackIds...)
-				if err != nil {
-					log.Fatalf("Track ID:%s could not be added to Playlist ID: %s/n", spotifyTrackIds, playlistId)
-				}
-				fmt.Println(snapshotId)
-				x = 0
-			}
+	spotifyTrackIds := searchSpotifyTracks(service, playlist, &result, matches)
+	addInBatches(spotifyTrackIds, spotifyAddTrackLimit, &result, func(batch []string) {
+		snapshotId, err := service.AddTracksToPlaylist(playlistId, spotifyIDs(batch)...)
+		if err != nil {
+			log.Fatalf("Track ID:%s could not be added to Playlist ID: %s\n", batch, playlistId)
 		}
-	} else {
-		for i := range playlist {
-			searchResults, err := service.SearchOpt(playlist[i], spotify.SearchTypeTrack, &options)
-			if err != nil {
-				fmt.Printf("%s : not found", playlist[i])
-				continue
-			}
-			searchResultsLen := len(searchResults.Tracks.Tracks)
-
-			if searchResultsLen != 0 {
-				songId := searchResults.Tracks.Tracks[0].ID
-				spotifyTrackIds = append(spotifyTrackIds, songId)
-				continue
-			} else {
-				fmt.Printf("%s : not found/n", playlist[i])
-				continue
+		fmt.Println(snapshotId)
+	})
+	fmt.Println("Added songs to Spotify")
+	return result
+}
+func likeSpotifyTracks(playlist []Track, matches *matchCache) ConversionResult { //saves the songs to the user's Liked Songs instead of a playlist
+	client := getSpotifyClient()
+	service := spotify.NewClient(client)
+	spotifyLibraryLimit := 50 //spotify allows up to 50 songs to be saved at a time
+	result := ConversionResult{Name: "Liked Songs", Destination: "spotify:liked", Total: len(playlist)}
+	spotifyTrackIds := searchSpotifyTracks(service, playlist, &result, matches)
+	addInBatches(spotifyTrackIds, spotifyLibraryLimit, &result, func(batch []string) {
+		err := service.AddTracksToLibrary(spotifyIDs(batch)...)
+		if err != nil {
+			log.Fatalf("Track ID:%s could not be added to Liked Songs: %v", batch, err)
+		}
+	})
+	fmt.Println("Liked songs on Spotify")
+	return result
+}
+func saveSpotifyAlbums(playlist []Track, matches *matchCache) ConversionResult { //saves the albums the songs come from to the user's library instead of making a playlist
+	client := getSpotifyClient()
+	service := spotify.NewClient(client)
+	searchResultLimit := 1
+	options := spotify.Options{
+		Limit: &searchResultLimit,
+	}
+	var albums []Track //one track standing in for each distinct album, in playlist order
+	seen := make(map[string]bool)
+	for _, track := range playlist {
+		key := strings.ToLower(track.Album + "\x00" + track.Artist)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		albums = append(albums, track)
+	}
+	result := ConversionResult{Name: "Saved albums", Destination: "spotify:albums", Total: len(albums)}
+	var albumIds []string
+	for _, album := range albums {
+		if album.Album == "" {
+			result.NotFound = append(result.NotFound, album.Query()+" (no album)")
+			continue
+		}
+		query := fmt.Sprintf("album:%q", album.Album)
+		if album.Artist != "" {
+			query += fmt.Sprintf(" artist:%q", album.Artist)
+		}
+		albumId, cached := matches.get(SPOTIFY, query)
+		if !cached {
+			searchResults, err := service.SearchOpt(query, spotify.SearchTypeAlbum, &options)
+			if err == nil && len(searchResults.Albums.Albums) != 0 {
+				albumId = string(searchResults.Albums.Albums[0].ID)
 			}
-
+			matches.put(SPOTIFY, query, albumId)
 		}
-		snapshotId, err := service.AddTracksToPlaylist(playlistId, spotifyTrackIds...)
-		if err != nil {
-			log.Fatalf("Track ID:%s could not be added to Playlist ID: %s/n", spotifyTrackIds, playlistId)
+		if albumId == "" {
+			fmt.Printf("%s : not found\n", album.Album)
+			result.NotFound = append(result.NotFound, album.Album)
+			continue
 		}
-		fmt.Println(snapshotId)
+		albumIds = append(albumIds, albumId)
 	}
-	fmt.Println("Added songs to Spotify")
+	spotifyAlbumLimit := 20 //spotify allows up to 20 albums to be saved at a time
+	addInBatches(albumIds, spotifyAlbumLimit, &result, func(batch []string) {
+		//the spotify package has no call for saving albums, so the request is made directly
+		req, err := http.NewRequest(http.MethodPut, "https://api.spotify.com/v1/me/albums?ids="+strings.Join(batch, ","), nil)
+		if err != nil {
+			log.Fatalf("Unable to build save albums request: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Fatalf("Albums %s could not be saved: %v", batch, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Fatalf("Albums %s could not be saved: %s", batch, resp.Status)
+		}
+	})
+	fmt.Println("Saved albums on Spotify")
+	return result
 }
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// subsonicConfig holds the server and account of a Subsonic compatible
+// server such as Navidrome, Airsonic or Gonic.
+type subsonicConfig struct {
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+const (
+	subsonicVersion       = "1.16.1"
+	subsonicClient        = "playlist-converter" //the name the server shows this program as
+	subsonicSearchResults = 20
+	subsonicBatchSize     = 100 //songs added to a playlist per request
+)
+
+// Subsonic reads and writes the playlists and starred songs on a Subsonic
+// compatible server, see http://www.subsonic.org/pages/api.jsp
+type Subsonic struct {
+	ID string
+}
+
+func NewSubsonic(ref playlistRef) *Subsonic {
+	return &Subsonic{ID: ref.ID}
+}
+
+type subsonicSong struct {
+	ID            string   `json:"id"`
+	Title         string   `json:"title"`
+	Artist        string   `json:"artist"`
+	Album         string   `json:"album"`
+	Duration      int64    `json:"duration"` //seconds
+	MusicBrainzID string   `json:"musicBrainzId"`
+	ISRC          []string `json:"isrc"` //OpenSubsonic servers only
+}
+
+type subsonicPlaylist struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Comment   string         `json:"comment"`
+	Owner     string         `json:"owner"`
+	Public    bool           `json:"public"`
+	SongCount int            `json:"songCount"`
+	Entry     []subsonicSong `json:"entry"`
+}
+
+// subsonicAPI calls a server as the configured user.
+type subsonicAPI struct {
+	base     string
+	username string
+	password string
+}
+
+func (S *Subsonic) GetTracks() []Track {
+	api := newSubsonicAPI()
+	var songs []subsonicSong
+	if S.ID == likedID {
+		var response struct {
+			Starred struct {
+				Song []subsonicSong `json:"song"`
+			} `json:"starred2"`
+		}
+		api.request("getStarred2", nil, &response)
+		songs = response.Starred.Song
+	} else {
+		var response struct {
+			Playlist subsonicPlaylist `json:"playlist"`
+		}
+		api.request("getPlaylist", url.Values{"id": {S.ID}}, &response)
+		songs = response.Playlist.Entry
+	}
+	var tracks []Track
+	for _, song := range songs {
+		track := subsonicTrack(song)
+		tracks = append(tracks, track)
+		fmt.Printf("%v, (%v)\n", track.Query(), track.ID)
+	}
+	return tracks
+}
+
+// WritePlaylist creates a new playlist, or stars the songs when the
+// reference is to the liked songs.
+func (S *Subsonic) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	switch S.ID {
+	case "":
+		return createSubsonicPlaylist(name, tracks, matches)
+	case likedID:
+		return starSubsonicSongs(tracks, matches)
+	case albumsID:
+		log.Fatalf("Subsonic has no saved albums, convert to subsonic to make a playlist of the album")
+	}
+	log.Fatalf("Adding to an existing Subsonic playlist is not supported")
+	return ConversionResult{}
+}
+
+func subsonicTrack(song subsonicSong) Track {
+	track := Track{
+		Title:         song.Title,
+		Artist:        song.Artist,
+		Album:         song.Album,
+		MusicBrainzID: song.MusicBrainzID,
+		Duration:      time.Duration(song.Duration) * time.Second,
+		ID:            song.ID,
+	}
+	if len(song.ISRC) > 0 {
+		track.ISRC = song.ISRC[0]
+	}
+	return track
+}
+
+func newSubsonicAPI() *subsonicAPI {
+	config := settings.Subsonic
+	if config.URL == "" || config.Username == "" {
+		log.Fatalf("Set subsonic.url, subsonic.username and subsonic.password in %s", configFile)
+	}
+	return &subsonicAPI{base: strings.TrimSuffix(config.URL, "/"), username: config.Username, password: config.Password}
+}
+
+// request calls a method of the REST API, see call.
+func (api *subsonicAPI) request(method string, params url.Values, out interface{}) {
+	handleError(api.call(method, params, out), "Subsonic request failed")
+}
+
+// call calls a method of the REST API. Every request is signed with a
+// token, the MD5 hash of the password and a random salt, so the password
+// itself is never sent. Subsonic reports errors in the body of a 200
+// response, which are returned like any other error.
+func (api *subsonicAPI) call(method string, params url.Values, out interface{}) error {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+	query.Set("u", api.username)
+	query.Set("s", hex.EncodeToString(salt))
+	token := md5.Sum([]byte(api.password + hex.EncodeToString(salt)))
+	query.Set("t", hex.EncodeToString(token[:]))
+	query.Set("v", subsonicVersion)
+	query.Set("c", subsonicClient)
+	query.Set("f", "json")
+
+	var envelope struct {
+		Response json.RawMessage `json:"subsonic-response"`
+	}
+	if err := apiRequest(http.DefaultClient, "GET", api.base+"/rest/"+method+"?"+query.Encode(), nil, nil, &envelope); err != nil {
+		return err
+	}
+	var status struct {
+		Status string `json:"status"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(envelope.Response, &status); err != nil || status.Status != "ok" {
+		if status.Error != nil {
+			return fmt.Errorf("%s failed: %s (error %d)", method, status.Error.Message, status.Error.Code)
+		}
+		return fmt.Errorf("%s failed: unexpected response from %s", method, api.base)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(envelope.Response, out)
+}
+
+// searchSubsonicSongs finds each song in the server's library with
+// search3, by artist and title together first.
+func searchSubsonicSongs(api *subsonicAPI, playlist []Track, result *ConversionResult, matches *matchCache) []string {
+	return matches.find(SUBSONIC, playlist, result, func(track Track) string {
+		var terms []string
+		for _, title := range searchTitles(track) {
+			terms = append(terms, strings.TrimSpace(leadArtist(track.Artist)+" "+title))
+			if track.Artist != "" { //some servers only search one field at a time
+				terms = append(terms, title)
+			}
+		}
+		for _, term := range terms {
+			var response struct {
+				SearchResult struct {
+					Song []subsonicSong `json:"song"`
+				} `json:"searchResult3"`
+			}
+			api.request("search3", url.Values{
+				"query":       {term},
+				"songCount":   {fmt.Sprint(subsonicSearchResults)},
+				"artistCount": {"0"},
+				"albumCount":  {"0"},
+			}, &response)
+			var candidates []Track
+			for _, song := range response.SearchResult.Song {
+				candidates = append(candidates, subsonicTrack(song))
+			}
+			if found, ok := newLocalLibrary(candidates).match(track); ok {
+				return found.ID
+			}
+		}
+		return ""
+	})
+}
+
+// createSubsonicPlaylist creates an empty playlist called name, then adds
+// the songs found to it in batches.
+func createSubsonicPlaylist(name string, playlist []Track, matches *matchCache) ConversionResult {
+	api := newSubsonicAPI()
+	result := ConversionResult{Name: name, Destination: "subsonic", Total: len(playlist)}
+	songIds := searchSubsonicSongs(api, playlist, &result, matches)
+	var created struct {
+		Playlist subsonicPlaylist `json:"playlist"`
+	}
+	api.request("createPlaylist", url.Values{"name": {name}}, &created)
+	if created.Playlist.ID == "" { //servers before API 1.14 do not return the playlist they created
+		log.Fatalf("Subsonic did not return the new playlist, the server needs to support API version 1.14 or later")
+	}
+	addInBatches(songIds, subsonicBatchSize, &result, func(batch []string) {
+		api.request("updatePlaylist", url.Values{"playlistId": {created.Playlist.ID}, "songIdToAdd": batch}, nil)
+	})
+	fmt.Println("Added songs to Subsonic")
+	return result
+}
+
+func starSubsonicSongs(playlist []Track, matches *matchCache) ConversionResult { //stars the songs instead of adding them to a playlist
+	api := newSubsonicAPI()
+	result := ConversionResult{Name: "Starred", Destination: "subsonic:liked", Total: len(playlist)}
+	songIds := searchSubsonicSongs(api, playlist, &result, matches)
+	addInBatches(songIds, subsonicBatchSize, &result, func(batch []string) {
+		api.request("star", url.Values{"id": batch}, nil)
+	})
+	fmt.Println("Starred songs on Subsonic")
+	return result
+}
+
+func subsonicSummary(item subsonicPlaylist, username string) playlistSummary {
+	return playlistSummary{
+		ID:          item.ID,
+		Name:        item.Name,
+		Description: item.Comment,
+		Owner:       item.Owner,
+		Owned:       item.Owner == username,
+		Public:      item.Public,
+		Tracks:      item.SongCount,
+	}
+}
+
+func listSubsonicPlaylists(includeFollowed bool) []playlistSummary { //lists the user's playlists, and other users' public playlists when includeFollowed is set
+	api := newSubsonicAPI()
+	var response struct {
+		Playlists struct {
+			Playlist []subsonicPlaylist `json:"playlist"`
+		} `json:"playlists"`
+	}
+	api.request("getPlaylists", nil, &response)
+	var playlists []playlistSummary
+	for _, item := range response.Playlists.Playlist {
+		summary := subsonicSummary(item, api.username)
+		if summary.Owned || includeFollowed {
+			playlists = append(playlists, summary)
+		}
+	}
+	return playlists
+}
+
+func subsonicPlaylistSummary(ref playlistRef) playlistSummary { //gets the name and details of whatever a reference points at
+	if ref.ID == likedID {
+		return playlistSummary{ID: likedID, Name: "Starred", Owned: true}
+	}
+	api := newSubsonicAPI()
+	var response struct {
+		Playlist subsonicPlaylist `json:"playlist"`
+	}
+	api.request("getPlaylist", url.Values{"id": {ref.ID}}, &response)
+	return subsonicSummary(response.Playlist, api.username)
+}
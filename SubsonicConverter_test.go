@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeSubsonic is a stand-in for a Subsonic server that checks each
+// request's token and salt, with playlist 1 of two songs and a library of
+// "Song <n>" by Artist, which is only found when searching for the whole
+// title, and "Only Title" by Someone, which is only found when searching
+// for its title alone. Like Subsonic it reports errors in
+// the body of a 200 response.
+type fakeSubsonic struct {
+	t        *testing.T
+	salts    map[string]bool
+	searches []string
+	created  string
+	added    [][]string
+}
+
+func (f *fakeSubsonic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	respond := func(body map[string]interface{}) {
+		body["version"] = subsonicVersion
+		if _, failed := body["error"]; failed {
+			body["status"] = "failed"
+		} else {
+			body["status"] = "ok"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"subsonic-response": body})
+	}
+	failure := func(code int, message string) map[string]interface{} {
+		return map[string]interface{}{"error": map[string]interface{}{"code": code, "message": message}}
+	}
+	salt := query.Get("s")
+	token := md5.Sum([]byte("sesame" + salt))
+	if query.Get("u") != "alice" || salt == "" || query.Get("t") != hex.EncodeToString(token[:]) || query.Get("p") != "" {
+		respond(failure(40, "Wrong username or password"))
+		return
+	}
+	if f.salts[salt] {
+		f.t.Errorf("salt %s was used twice", salt)
+	}
+	f.salts[salt] = true
+	if query.Get("f") != "json" || query.Get("v") == "" || query.Get("c") == "" {
+		f.t.Errorf("request %s is missing f, v or c", r.URL.RawQuery)
+	}
+	song := func(id, title, artist string) map[string]interface{} {
+		return map[string]interface{}{"id": id, "title": title, "artist": artist, "album": "Album", "duration": 200}
+	}
+	switch strings.TrimPrefix(r.URL.Path, "/rest/") {
+	case "getPlaylist":
+		if query.Get("id") != "1" {
+			respond(failure(70, "Playlist not found"))
+			return
+		}
+		respond(map[string]interface{}{"playlist": map[string]interface{}{"id": "1", "name": "Mix", "owner": "alice", "songCount": 2,
+			"entry": []interface{}{song("s1", "Song 1", "Artist"), song("s2", "Song 2", "Artist")}}})
+	case "search3":
+		term := query.Get("query")
+		f.searches = append(f.searches, term)
+		var songs []interface{}
+		if n, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(term), "artist song ")); err == nil {
+			songs = append(songs, song(fmt.Sprint("s-", n), fmt.Sprint("Song ", n), "Artist"))
+		}
+		if term == "Only Title" {
+			songs = append(songs, song("only", "Only Title", "Someone"))
+		}
+		respond(map[string]interface{}{"searchResult3": map[string]interface{}{"song": songs}})
+	case "createPlaylist":
+		f.created = query.Get("name")
+		respond(map[string]interface{}{"playlist": map[string]interface{}{"id": "9", "name": f.created}})
+	case "updatePlaylist":
+		if query.Get("playlistId") != "9" {
+			respond(failure(70, "Playlist not found"))
+			return
+		}
+		f.added = append(f.added, query["songIdToAdd"])
+		respond(map[string]interface{}{})
+	default:
+		respond(failure(0, "Unknown method"))
+	}
+}
+
+func newFakeSubsonic(t *testing.T) *fakeSubsonic {
+	fake := &fakeSubsonic{t: t, salts: make(map[string]bool)}
+	url := standIn(t, fake)
+	settings.Subsonic = subsonicConfig{URL: url + "/", Username: "alice", Password: "sesame"}
+	return fake
+}
+
+func TestSubsonicGetTracks(t *testing.T) {
+	newFakeSubsonic(t)
+	tracks := NewSubsonic(playlistRef{Service: SUBSONIC, ID: "1"}).GetTracks()
+	if len(tracks) != 2 || tracks[1].ID != "s2" || tracks[1].Title != "Song 2" || tracks[1].Artist != "Artist" {
+		t.Errorf("read %+v", tracks)
+	}
+}
+
+func TestSubsonicErrors(t *testing.T) {
+	newFakeSubsonic(t)
+	api := newSubsonicAPI()
+	err := api.call("getPlaylist", map[string][]string{"id": {"404"}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "Playlist not found (error 70)") {
+		t.Errorf("an error in the body of a 200 response gave %v", err)
+	}
+	api.password = "wrong"
+	if err := api.call("getPlaylist", map[string][]string{"id": {"1"}}, nil); err == nil || !strings.Contains(err.Error(), "error 40") {
+		t.Errorf("a wrong password gave %v", err)
+	}
+}
+
+func TestSubsonicCreatePlaylist(t *testing.T) {
+	fake := newFakeSubsonic(t)
+	var playlist []Track
+	for i := 1; i <= subsonicBatchSize+5; i++ {
+		playlist = append(playlist, Track{Title: fmt.Sprintf("Song %d", i), Artist: "Artist"})
+	}
+	playlist = append(playlist, Track{Title: "Song 3 (feat. Guest)", Artist: "Artist"}, Track{Title: "Only Title", Artist: "Someone"}, Track{Title: "Missing", Artist: "Nobody"})
+	result := NewSubsonic(playlistRef{Service: SUBSONIC}).WritePlaylist("Road Trip", playlist, newMatchCache())
+	if fake.created != "Road Trip" {
+		t.Errorf("created %q, want Road Trip", fake.created)
+	}
+	if sizes := batchSizes(fake.added); !reflect.DeepEqual(sizes, []int{subsonicBatchSize, 7}) {
+		t.Errorf("added batches of %v, want %d then 7", sizes, subsonicBatchSize)
+	}
+	if last := fake.added[len(fake.added)-1]; !reflect.DeepEqual(last[len(last)-2:], []string{"s-3", "only"}) {
+		t.Errorf("the songs found without their featured artist or by their title alone were not added, the last batch is %v", last)
+	}
+	if result.Added != subsonicBatchSize+7 || !reflect.DeepEqual(result.NotFound, []string{"Missing - Nobody"}) {
+		t.Errorf("result = %d added, not found %v", result.Added, result.NotFound)
+	}
+}
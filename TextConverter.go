@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// textConfig customises how plain text tracklists are read. Patterns are
+// regular expressions with named groups title, artist and album, tried in
+// order on every line before the built in heuristics.
+type textConfig struct {
+	Patterns   []string `json:"patterns"`
+	TitleFirst bool     `json:"titleFirst"` //read "A - B" as title then artist
+}
+
+var (
+	//00:12:30, [12:30], (1:02:03) and the separator after them
+	leadingTimestamp = regexp.MustCompile(`^[\[(]?\d{1,2}:\d{2}(:\d{2})?[\])]?\s*[-–—|.:]?\s*`)
+	//1. 01) #3 [4] 12: 7 - but not the 99 in "99 Luftballons"
+	leadingNumber = regexp.MustCompile(`^(#\d{1,3}|\[\d{1,3}\]|\d{1,3}\s*[.):]|\d{1,3}\s+[-–—])\s*`)
+	//a length at the end in brackets, e.g. (3:45)
+	bracketedLength = regexp.MustCompile(`\s*[\[(](\d{1,2}):(\d{2})[\])]$`)
+	//a length at the end after a separator, e.g. | 3:45, unless it is the title as in "JAY-Z - 4:44"
+	separatedLength = regexp.MustCompile(`\s+[-–—|·]\s+(\d{1,2}):(\d{2})$`)
+	//[Label] at the end, labels are only ever in square brackets
+	trailingLabel = regexp.MustCompile(`\s*\[[^\]]*\]$`)
+	dashSeparator = regexp.MustCompile(`\s+[-–—~]\s+`)
+)
+
+// TextFile reads tracklists pasted from chats, radio sites and the like,
+// one track per line, and writes playlists back as "Artist - Title" lines.
+// The path - reads from standard input.
+type TextFile struct {
+	Path string
+}
+
+func NewTextFile(ref playlistRef) *TextFile {
+	return &TextFile{Path: ref.ID}
+}
+
+func (T *TextFile) GetTracks() []Track {
+	var r io.Reader = os.Stdin
+	if T.Path != "-" {
+		f, err := os.Open(T.Path)
+		if err != nil {
+			log.Fatalf("Unable to read tracklist: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	patterns, err := compileTextPatterns(settings.Text.Patterns)
+	handleError(err, "Invalid text pattern")
+	tracks, err := parseTracklist(r, patterns, settings.Text.TitleFirst)
+	handleError(err, "Unable to read tracklist")
+	return tracks
+}
+
+// WritePlaylist writes one "Artist - Title" line per track. Without a path
+// the file is named after the playlist.
+func (T *TextFile) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	path := T.Path
+	if path == "" {
+		path = playlistFileName(name, ".txt")
+	}
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatalf("Unable to create tracklist: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	buffered := bufio.NewWriter(w)
+	for _, track := range tracks {
+		fmt.Fprintln(buffered, m3uDisplayTitle(track))
+	}
+	err := buffered.Flush()
+	handleError(err, "Unable to write tracklist")
+	return ConversionResult{Name: name, Destination: "text:" + path, Total: len(tracks), Added: len(tracks)}
+}
+
+func compileTextPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if re.SubexpIndex("title") < 0 {
+			return nil, fmt.Errorf("pattern %q has no title group", pattern)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// parseTracklist reads a track from every line that has one.
+func parseTracklist(r io.Reader, patterns []*regexp.Regexp, titleFirst bool) ([]Track, error) {
+	var tracks []Track
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if track, ok := parseTracklistLine(scanner.Text(), patterns, titleFirst); ok {
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks, scanner.Err()
+}
+
+// parseTracklistLine reads a track from a line such as "1. Artist – Title
+// [Label]", "Title by Artist" or "00:12:30 Artist - Title". Configured
+// patterns are tried first. Otherwise numbering, timestamps and labels are
+// stripped and the rest is split on a dash or " by ". Blank lines and
+// comments are skipped.
+func parseTracklistLine(line string, patterns []*regexp.Regexp, titleFirst bool) (Track, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#EXT") || strings.HasPrefix(line, "//") {
+		return Track{}, false
+	}
+	for _, re := range patterns {
+		matches := re.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		track := Track{Title: strings.TrimSpace(matches[re.SubexpIndex("title")])}
+		if i := re.SubexpIndex("artist"); i >= 0 {
+			track.Artist = strings.TrimSpace(matches[i])
+		}
+		if i := re.SubexpIndex("album"); i >= 0 {
+			track.Album = strings.TrimSpace(matches[i])
+		}
+		return track, track.Title != ""
+	}
+
+	line = leadingTimestamp.ReplaceAllString(line, "")
+	line = leadingNumber.ReplaceAllString(line, "")
+	var track Track
+	length := bracketedLength.FindStringSubmatch(line)
+	if length == nil {
+		length = separatedLength.FindStringSubmatch(line)
+		if length != nil && !hasArtistAndTitle(line[:len(line)-len(length[0])]) {
+			length = nil
+		}
+	}
+	if length != nil {
+		var minutes, seconds int
+		fmt.Sscan(length[1], &minutes)
+		fmt.Sscan(length[2], &seconds)
+		track.Duration = time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+		line = line[:len(line)-len(length[0])]
+	}
+	line = trailingLabel.ReplaceAllString(line, "")
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return Track{}, false
+	}
+
+	if parts := dashSeparator.Split(line, 2); len(parts) == 2 {
+		track.Artist, track.Title = parts[0], parts[1]
+		if titleFirst {
+			track.Artist, track.Title = track.Title, track.Artist
+		}
+	} else if i := strings.LastIndex(strings.ToLower(line), " by "); i > 0 {
+		track.Title, track.Artist = line[:i], line[i+len(" by "):]
+	} else {
+		track.Title = line
+	}
+	track.Title = strings.Trim(strings.TrimSpace(track.Title), `"“”'`)
+	track.Artist = strings.TrimSpace(track.Artist)
+	return track, track.Title != ""
+}
+
+func hasArtistAndTitle(line string) bool { //whether a line splits into an artist and a title
+	return dashSeparator.MatchString(line) || strings.LastIndex(strings.ToLower(line), " by ") > 0
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTracklistLine(t *testing.T) {
+	length := 5*time.Minute + 20*time.Second
+	tests := []struct {
+		line string
+		want Track
+	}{
+		{"Daft Punk - One More Time", Track{Artist: "Daft Punk", Title: "One More Time"}},
+		{"1. Daft Punk – One More Time [Virgin]", Track{Artist: "Daft Punk", Title: "One More Time"}},
+		{"01) Daft Punk - One More Time (5:20)", Track{Artist: "Daft Punk", Title: "One More Time", Duration: length}},
+		{"#3 Daft Punk - One More Time", Track{Artist: "Daft Punk", Title: "One More Time"}},
+		{"[4] Daft Punk - One More Time", Track{Artist: "Daft Punk", Title: "One More Time"}},
+		{"7 - Daft Punk - One More Time", Track{Artist: "Daft Punk", Title: "One More Time"}},
+		{"00:12:30 Daft Punk - One More Time", Track{Artist: "Daft Punk", Title: "One More Time"}},
+		{"[12:30] Daft Punk - One More Time [Virgin]", Track{Artist: "Daft Punk", Title: "One More Time"}},
+		{"Daft Punk - One More Time | 5:20", Track{Artist: "Daft Punk", Title: "One More Time", Duration: length}},
+		{"One More Time by Daft Punk", Track{Artist: "Daft Punk", Title: "One More Time"}},
+		{`"One More Time" by Daft Punk - 5:20`, Track{Artist: "Daft Punk", Title: "One More Time", Duration: length}},
+		{"JAY-Z - 4:44", Track{Artist: "JAY-Z", Title: "4:44"}},
+		{"99 Luftballons", Track{Title: "99 Luftballons"}},
+	}
+	for _, test := range tests {
+		got, ok := parseTracklistLine(test.line, nil, false)
+		if !ok || got != test.want {
+			t.Errorf("parseTracklistLine(%q) = %+v, %v, want %+v", test.line, got, ok, test.want)
+		}
+	}
+	if got, _ := parseTracklistLine("One More Time - Daft Punk", nil, true); got.Title != "One More Time" || got.Artist != "Daft Punk" {
+		t.Errorf("with titleFirst, read %+v", got)
+	}
+}
+
+func TestParseTracklistLineSkips(t *testing.T) {
+	for _, line := range []string{"", "   ", "#EXTM3U", "// side B", "[Virgin]"} {
+		if got, ok := parseTracklistLine(line, nil, false); ok {
+			t.Errorf("parseTracklistLine(%q) = %+v, want it skipped", line, got)
+		}
+	}
+}
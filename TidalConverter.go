@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// tidalConfig holds the Tidal app used to sign in.
+type tidalConfig struct {
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	BaseURL      string `json:"baseUrl"`
+	OpenAPIURL   string `json:"openApiUrl"` //the newer API, which can look tracks up by ISRC
+	AuthURL      string `json:"authUrl"`
+}
+
+const (
+	tidalDefaultURL        = "https://api.tidal.com/v1"
+	tidalDefaultOpenAPIURL = "https://openapi.tidal.com/v2"
+	tidalDefaultAuthURL    = "https://auth.tidal.com/v1/oauth2"
+	tidalScopes            = "r_usr w_usr"
+	tidalPageLimit         = 100
+	tidalAddLimit          = 50              //tracks added to a playlist or the favourites in one request
+	tidalSignInTimeout     = 5 * time.Minute //how long to wait for the user when Tidal does not say how long the code lasts
+)
+
+// Tidal reads playlists, albums, artists' top tracks and the user's
+// favourite tracks from Tidal, and writes new playlists or favourites.
+// Playlists have UUIDs, everything else numeric IDs.
+type Tidal struct {
+	ID   string
+	Kind refKind
+}
+
+func NewTidal(ref playlistRef) *Tidal {
+	return &Tidal{ID: ref.ID, Kind: ref.Kind}
+}
+
+type tidalTrack struct {
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	Version  string `json:"version"`  //e.g. Remastered, kept apart from the title
+	Duration int    `json:"duration"` //seconds
+	ISRC     string `json:"isrc"`
+	URL      string `json:"url"`
+	Artist   struct {
+		Name string `json:"name"`
+	} `json:"artist"`
+	Album struct {
+		Title string `json:"title"`
+	} `json:"album"`
+}
+
+type tidalPlaylist struct {
+	UUID           string `json:"uuid"`
+	Title          string `json:"title"`
+	Description    string `json:"description"`
+	NumberOfTracks int    `json:"numberOfTracks"`
+	PublicPlaylist bool   `json:"publicPlaylist"`
+	Creator        struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	} `json:"creator"`
+}
+
+// tidalAPI is a client for the Tidal API signed in as the user.
+type tidalAPI struct {
+	client      *http.Client
+	base        string
+	openAPI     string
+	userId      int64
+	countryCode string
+}
+
+func (T *Tidal) GetTracks() []Track {
+	api := newTidalAPI()
+	var path string
+	switch {
+	case T.ID == likedID:
+		path = fmt.Sprintf("/users/%d/favorites/tracks", api.userId)
+	case T.Kind == albumKind:
+		path = "/albums/" + T.ID + "/tracks"
+	case T.Kind == artistKind, T.Kind == discographyKind:
+		path = "/artists/" + T.ID + "/toptracks"
+	default:
+		path = "/playlists/" + T.ID + "/items"
+	}
+	var tracks []Track
+	for _, item := range api.allTracks(path) {
+		track := Track{
+			ID:       strconv.FormatInt(item.ID, 10),
+			Title:    item.Title,
+			Artist:   item.Artist.Name,
+			Album:    item.Album.Title,
+			ISRC:     item.ISRC,
+			Location: item.URL,
+			Duration: time.Duration(item.Duration) * time.Second,
+		}
+		if item.Version != "" {
+			track.Title += " (" + item.Version + ")"
+		}
+		tracks = append(tracks, track)
+		fmt.Printf("%v, (%v)\n", track.Query(), track.ID)
+	}
+	return tracks
+}
+
+// WritePlaylist creates a new playlist, or adds the tracks to the user's
+// favourites when the reference is to the liked songs.
+func (T *Tidal) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	switch T.ID {
+	case "":
+		return createTidalPlaylist(name, tracks, matches)
+	case likedID:
+		return favouriteTidalTracks(tracks, matches)
+	case albumsID:
+		log.Fatalf("Saving albums to a Tidal library is not supported, convert to tidal to make a playlist")
+	}
+	log.Fatalf("Adding to an existing Tidal playlist is not supported")
+	return ConversionResult{}
+}
+
+func newTidalAPI() *tidalAPI {
+	config := settings.Tidal
+	if config.ClientID == "" {
+		log.Fatalf("Set tidal.clientId in %s", configFile)
+	}
+	authBase := strings.TrimSuffix(config.AuthURL, "/")
+	if authBase == "" {
+		authBase = tidalDefaultAuthURL
+	}
+	oauthConfig := &oauth2.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		Endpoint:     oauth2.Endpoint{TokenURL: authBase + "/token", AuthStyle: oauth2.AuthStyleInParams},
+		Scopes:       strings.Fields(tidalScopes),
+	}
+	cacheFile, err := tokenCacheFile("tidal")
+	if err != nil {
+		log.Fatalf("Unable to get path to cached credential file. %v", err)
+	}
+	tok, err := tokenFromFile(cacheFile)
+	if err != nil {
+		tok = tidalDeviceToken(authBase, config)
+		saveToken(cacheFile, tok)
+	}
+	api := &tidalAPI{
+		client:  oauthConfig.Client(context.Background(), tok),
+		base:    strings.TrimSuffix(config.BaseURL, "/"),
+		openAPI: strings.TrimSuffix(config.OpenAPIURL, "/"),
+	}
+	if api.base == "" {
+		api.base = tidalDefaultURL
+	}
+	if api.openAPI == "" {
+		api.openAPI = tidalDefaultOpenAPIURL
+	}
+	var session struct {
+		UserID      int64  `json:"userId"`
+		CountryCode string `json:"countryCode"`
+	}
+	api.request("GET", "/sessions", nil, &session)
+	api.userId, api.countryCode = session.UserID, session.CountryCode
+	return api
+}
+
+// tidalDeviceToken signs in with the OAuth device authorization grant: the
+// user enters a code on Tidal's site while this polls for the token.
+func tidalDeviceToken(authBase string, config tidalConfig) *oauth2.Token {
+	var device struct {
+		DeviceCode              string `json:"deviceCode"`
+		UserCode                string `json:"userCode"`
+		VerificationURIComplete string `json:"verificationUriComplete"`
+		ExpiresIn               int    `json:"expiresIn"`
+		Interval                int    `json:"interval"`
+	}
+	err := apiRequest(http.DefaultClient, "POST", authBase+"/device_authorization", nil, url.Values{
+		"client_id": {config.ClientID},
+		"scope":     {tidalScopes},
+	}, &device)
+	handleError(err, "Unable to start Tidal sign in")
+	link := device.VerificationURIComplete
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+	fmt.Printf("Go to %s to sign in to Tidal, the code is %s\n", link, device.UserCode)
+	openURL(link)
+
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	expiresIn := time.Duration(device.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = tidalSignInTimeout
+	}
+	deadline := time.Now().Add(expiresIn)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		var token struct {
+			AccessToken      string `json:"access_token"`
+			RefreshToken     string `json:"refresh_token"`
+			TokenType        string `json:"token_type"`
+			ExpiresIn        int    `json:"expires_in"`
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		resp, err := http.PostForm(authBase+"/token", url.Values{
+			"client_id":     {config.ClientID},
+			"client_secret": {config.ClientSecret},
+			"device_code":   {device.DeviceCode},
+			"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+			"scope":         {tidalScopes},
+		})
+		handleError(err, "Unable to sign in to Tidal")
+		err = json.NewDecoder(resp.Body).Decode(&token)
+		resp.Body.Close()
+		switch {
+		case token.Error == "authorization_pending": //until the user has signed in
+			continue
+		case token.Error == "slow_down":
+			interval += 5 * time.Second
+			continue
+		case token.Error != "" || resp.StatusCode != http.StatusOK:
+			log.Fatalf("Tidal sign in failed: %s %s (%s)", token.Error, token.ErrorDescription, resp.Status)
+		}
+		handleError(err, "Unable to read Tidal token")
+		return &oauth2.Token{
+			AccessToken:  token.AccessToken,
+			RefreshToken: token.RefreshToken,
+			TokenType:    token.TokenType,
+			Expiry:       time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+		}
+	}
+	log.Fatalf("Tidal sign in timed out")
+	return nil
+}
+
+// request calls the API. Every call needs the user's country code, which is
+// added to the query.
+func (api *tidalAPI) request(method string, path string, form url.Values, out interface{}) {
+	target := api.base + path
+	if api.countryCode != "" {
+		separator := "?"
+		if strings.Contains(target, "?") {
+			separator = "&"
+		}
+		target += separator + "countryCode=" + api.countryCode
+	}
+	var body interface{}
+	if form != nil {
+		body = form
+	}
+	err := apiRequest(api.client, method, target, nil, body, out)
+	handleError(err, "Tidal request failed")
+}
+
+func (api *tidalAPI) allTracks(path string) []tidalTrack { //pages through a list, which holds tracks or, for playlists, items wrapping tracks and videos
+	var tracks []tidalTrack
+	for offset := 0; ; {
+		var page struct {
+			Items []struct {
+				tidalTrack
+				Item *tidalTrack `json:"item"`
+				Type string      `json:"type"`
+			} `json:"items"`
+			Total int `json:"totalNumberOfItems"`
+		}
+		api.request("GET", fmt.Sprintf("%s?limit=%d&offset=%d", path, tidalPageLimit, offset), nil, &page)
+		for _, item := range page.Items {
+			switch {
+			case item.Item == nil:
+				tracks = append(tracks, item.tidalTrack)
+			case item.Type == "" || item.Type == "track":
+				tracks = append(tracks, *item.Item)
+			}
+		}
+		offset += len(page.Items)
+		if len(page.Items) == 0 || offset >= page.Total {
+			return tracks
+		}
+	}
+}
+
+// searchTidalTracks finds the Tidal track for each song, by ISRC when it
+// has one, otherwise by searching for the title and artist.
+func searchTidalTracks(api *tidalAPI, playlist []Track, result *ConversionResult, matches *matchCache) []string {
+	return matches.find(TIDAL, playlist, result, func(track Track) string {
+		if track.ISRC != "" {
+			var found struct {
+				Data []struct {
+					ID string `json:"id"`
+				} `json:"data"`
+			}
+			target := fmt.Sprintf("%s/tracks?countryCode=%s&filter[isrc]=%s", api.openAPI, api.countryCode, url.QueryEscape(track.ISRC))
+			err := apiRequest(api.client, "GET", target, http.Header{"Accept": {"application/vnd.api+json"}}, nil, &found)
+			if err == nil && len(found.Data) > 0 {
+				return found.Data[0].ID
+			}
+		}
+		var search struct {
+			Tracks struct {
+				Items []tidalTrack `json:"items"`
+			} `json:"tracks"`
+		}
+		term := strings.TrimSpace(track.Title + " " + track.Artist)
+		api.request("GET", "/search?types=TRACKS&limit=1&query="+url.QueryEscape(term), nil, &search)
+		if len(search.Tracks.Items) == 0 {
+			return ""
+		}
+		return strconv.FormatInt(search.Tracks.Items[0].ID, 10)
+	})
+}
+
+func (api *tidalAPI) playlistETag(uuid string) string { //playlist changes have to give the current version of the playlist
+	req, err := http.NewRequest("GET", api.base+"/playlists/"+uuid+"?countryCode="+api.countryCode, nil)
+	handleError(err, "")
+	resp, err := api.client.Do(req)
+	handleError(err, "Unable to look up Tidal playlist")
+	resp.Body.Close()
+	return resp.Header.Get("ETag")
+}
+
+func createTidalPlaylist(name string, playlist []Track, matches *matchCache) ConversionResult { //creates a Tidal playlist called name with the tracks found
+	api := newTidalAPI()
+	result := ConversionResult{Name: name, Destination: "tidal", Total: len(playlist)}
+	trackIds := searchTidalTracks(api, playlist, &result, matches)
+	var created tidalPlaylist
+	api.request("POST", fmt.Sprintf("/users/%d/playlists", api.userId), url.Values{"title": {name}, "description": {""}}, &created)
+	addInBatches(trackIds, tidalAddLimit, &result, func(batch []string) {
+		target := api.base + "/playlists/" + created.UUID + "/items?countryCode=" + api.countryCode
+		err := apiRequest(api.client, "POST", target, http.Header{"If-None-Match": {api.playlistETag(created.UUID)}}, url.Values{
+			"trackIds": {strings.Join(batch, ",")},
+			"onDupes":  {"ADD"},
+		}, nil)
+		handleError(err, "Unable to add tracks to Tidal playlist "+created.UUID)
+	})
+	fmt.Println("Added songs to Tidal")
+	return result
+}
+
+func favouriteTidalTracks(playlist []Track, matches *matchCache) ConversionResult { //adds the tracks to the user's favourites instead of a playlist
+	api := newTidalAPI()
+	result := ConversionResult{Name: "Favourite tracks", Destination: "tidal:liked", Total: len(playlist)}
+	trackIds := searchTidalTracks(api, playlist, &result, matches)
+	addInBatches(trackIds, tidalAddLimit, &result, func(batch []string) {
+		api.request("POST", fmt.Sprintf("/users/%d/favorites/tracks", api.userId), url.Values{"trackIds": {strings.Join(batch, ",")}}, nil)
+	})
+	fmt.Println("Added songs to Tidal favourites")
+	return result
+}
+
+func tidalSummary(item tidalPlaylist, userId int64) playlistSummary {
+	return playlistSummary{
+		ID:          item.UUID,
+		Name:        item.Title,
+		Description: item.Description,
+		Owner:       item.Creator.Name,
+		Owned:       item.Creator.ID == userId,
+		Public:      item.PublicPlaylist,
+		Tracks:      item.NumberOfTracks,
+	}
+}
+
+func listTidalPlaylists(includeFollowed bool) []playlistSummary { //lists the user's playlists, the ones they created unless includeFollowed is set
+	api := newTidalAPI()
+	path := fmt.Sprintf("/users/%d/playlists", api.userId)
+	if includeFollowed {
+		path = fmt.Sprintf("/users/%d/playlistsAndFavoritePlaylists", api.userId)
+	}
+	var playlists []playlistSummary
+	for offset := 0; ; {
+		var page struct {
+			Items []struct {
+				tidalPlaylist
+				Playlist *tidalPlaylist `json:"playlist"` //favourite playlists are wrapped
+			} `json:"items"`
+			Total int `json:"totalNumberOfItems"`
+		}
+		api.request("GET", fmt.Sprintf("%s?limit=%d&offset=%d", path, tidalPageLimit, offset), nil, &page)
+		for _, item := range page.Items {
+			playlist := item.tidalPlaylist
+			if item.Playlist != nil {
+				playlist = *item.Playlist
+			}
+			playlists = append(playlists, tidalSummary(playlist, api.userId))
+		}
+		offset += len(page.Items)
+		if len(page.Items) == 0 || offset >= page.Total {
+			return playlists
+		}
+	}
+}
+
+func tidalPlaylistSummary(ref playlistRef) playlistSummary { //gets the name and details of whatever a reference points at
+	api := newTidalAPI()
+	switch {
+	case ref.ID == likedID:
+		return playlistSummary{ID: likedID, Name: "Favourite tracks", Owned: true}
+	case ref.Kind == albumKind:
+		var album struct {
+			Title          string `json:"title"`
+			NumberOfTracks int    `json:"numberOfTracks"`
+			Artist         struct {
+				Name string `json:"name"`
+			} `json:"artist"`
+		}
+		api.request("GET", "/albums/"+ref.ID, nil, &album)
+		return playlistSummary{ID: ref.ID, Name: album.Title, Owner: album.Artist.Name, Tracks: album.NumberOfTracks}
+	case ref.Kind == artistKind, ref.Kind == discographyKind:
+		var artist struct {
+			Name string `json:"name"`
+		}
+		api.request("GET", "/artists/"+ref.ID, nil, &artist)
+		return playlistSummary{ID: ref.ID, Name: artist.Name, Owner: artist.Name}
+	}
+	var playlist tidalPlaylist
+	api.request("GET", "/playlists/"+ref.ID, nil, &playlist)
+	return tidalSummary(playlist, api.userId)
+}
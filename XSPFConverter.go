@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// XSPFFile reads and writes XSPF playlists, or their JSON form JSPF as used
+// by ListenBrainz. Both carry the title, creator, album, duration and
+// identifiers of every track, so a playlist survives a round trip through
+// either without losing anything.
+type XSPFFile struct {
+	Path string
+	JSON bool //JSPF rather than XSPF
+}
+
+func NewXSPFFile(ref playlistRef) *XSPFFile {
+	return &XSPFFile{Path: ref.ID, JSON: ref.Service == JSPF}
+}
+
+// musicBrainzRecordingURL prefixes the MusicBrainz identifier of a track.
+const musicBrainzRecordingURL = "https://musicbrainz.org/recording/"
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist" json:"-"`
+	Version string      `xml:"version,attr" json:"-"`
+	Xmlns   string      `xml:"xmlns,attr" json:"-"`
+	Title   string      `xml:"title,omitempty" json:"title,omitempty"`
+	Creator string      `xml:"creator,omitempty" json:"creator,omitempty"`
+	Date    string      `xml:"date,omitempty" json:"date,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track" json:"track"`
+}
+
+type xspfTrack struct {
+	Location   stringList `xml:"location" json:"location,omitempty"`
+	Identifier stringList `xml:"identifier" json:"identifier,omitempty"`
+	Title      string     `xml:"title,omitempty" json:"title,omitempty"`
+	Creator    string     `xml:"creator,omitempty" json:"creator,omitempty"`
+	Album      string     `xml:"album,omitempty" json:"album,omitempty"`
+	Duration   int64      `xml:"duration,omitempty" json:"duration,omitempty"` //milliseconds
+}
+
+// stringList is a list of strings that JSPF files may also give as a single
+// string, which ListenBrainz does for identifiers.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = stringList{single}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(data, &list)
+	*l = list
+	return err
+}
+
+func (X *XSPFFile) GetTracks() []Track {
+	data, err := os.ReadFile(X.Path)
+	if err != nil {
+		log.Fatalf("Unable to read playlist file: %v", err)
+	}
+	var playlist xspfPlaylist
+	if X.JSON {
+		var document struct {
+			Playlist xspfPlaylist `json:"playlist"`
+		}
+		err = json.Unmarshal(data, &document)
+		playlist = document.Playlist
+	} else {
+		err = xml.Unmarshal(data, &playlist)
+	}
+	handleError(err, "Unable to parse playlist file")
+	var tracks []Track
+	for _, item := range playlist.Tracks {
+		tracks = append(tracks, trackFromXSPF(item, filepath.Dir(X.Path)))
+	}
+	return tracks
+}
+
+// WritePlaylist writes every track to the file with all of its metadata.
+// Without a path the file is named after the playlist.
+func (X *XSPFFile) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	service, ext := XSPF, ".xspf"
+	if X.JSON {
+		service, ext = JSPF, ".jspf"
+	}
+	path := X.Path
+	if path == "" {
+		path = playlistFileName(name, ext)
+	}
+	playlist := xspfPlaylist{
+		Version: "1",
+		Xmlns:   "http://xspf.org/ns/0/",
+		Title:   name,
+		Date:    time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, track := range tracks {
+		playlist.Tracks = append(playlist.Tracks, xspfFromTrack(track))
+	}
+	var data []byte
+	var err error
+	if X.JSON {
+		data, err = json.MarshalIndent(map[string]xspfPlaylist{"playlist": playlist}, "", "  ")
+	} else {
+		data, err = xml.MarshalIndent(playlist, "", "  ")
+		data = append([]byte(xml.Header), data...)
+	}
+	handleError(err, "Unable to encode playlist file")
+	err = os.WriteFile(path, append(data, '\n'), 0644)
+	handleError(err, "Unable to write playlist file")
+	fmt.Println("Wrote", path)
+	return ConversionResult{Name: name, Destination: fmt.Sprintf("%s:%s", service, path), Total: len(tracks), Added: len(tracks)}
+}
+
+func trackFromXSPF(item xspfTrack, dir string) Track { //reads the metadata and identifiers of an XSPF track, resolving relative locations against dir
+	track := Track{
+		Title:    item.Title,
+		Artist:   item.Creator,
+		Album:    item.Album,
+		Duration: time.Duration(item.Duration) * time.Millisecond,
+	}
+	if len(item.Location) > 0 {
+		track.Location = m3uLocation(item.Location[0], dir)
+	}
+	for _, identifier := range item.Identifier {
+		switch {
+		case strings.HasPrefix(identifier, "isrc:"):
+			track.ISRC = strings.TrimPrefix(identifier, "isrc:")
+		case strings.HasPrefix(identifier, musicBrainzRecordingURL):
+			track.MusicBrainzID = strings.TrimPrefix(identifier, musicBrainzRecordingURL)
+		}
+	}
+	if track.Title == "" && track.Location != "" {
+		track.Artist, track.Title = titleFromFileName(track.Location, track.Artist)
+	}
+	return track
+}
+
+func xspfFromTrack(track Track) xspfTrack { //writes the metadata and identifiers of a track for XSPF
+	item := xspfTrack{
+		Title:    track.Title,
+		Creator:  track.Artist,
+		Album:    track.Album,
+		Duration: track.Duration.Milliseconds(),
+	}
+	if location := localFileURL(track.Location); location != "" { //XSPF locations are URIs, so local paths become file:// ones
+		item.Location = stringList{location}
+	} else if track.Location != "" {
+		item.Location = stringList{track.Location}
+	}
+	if track.MusicBrainzID != "" {
+		item.Identifier = append(item.Identifier, musicBrainzRecordingURL+track.MusicBrainzID)
+	}
+	if track.ISRC != "" {
+		item.Identifier = append(item.Identifier, "isrc:"+track.ISRC)
+	}
+	return item
+}
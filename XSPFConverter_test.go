@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestXSPFLocation(t *testing.T) {
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{"/music/Daft Punk/01 One More Time.mp3", "file://localhost/music/Daft%20Punk/01%20One%20More%20Time.mp3"},
+		{"/music/AC#DC?.flac", "file://localhost/music/AC%23DC%3F.flac"},
+		{"file:///music/a%20b.mp3", "file:///music/a%20b.mp3"},
+		{"https://open.spotify.com/track/4aawyAB9vmqN3uQ7FjRGTy", "https://open.spotify.com/track/4aawyAB9vmqN3uQ7FjRGTy"},
+	}
+	for _, test := range tests {
+		item := xspfFromTrack(Track{Title: "Song", Location: test.location})
+		if len(item.Location) != 1 || item.Location[0] != test.want {
+			t.Errorf("xspfFromTrack wrote %q as %v, want %q", test.location, item.Location, test.want)
+			continue
+		}
+		if back := trackFromXSPF(item, "/elsewhere"); back.Location != test.location && test.location[0] == '/' {
+			t.Errorf("%q read back as %q", test.want, back.Location)
+		}
+	}
+}
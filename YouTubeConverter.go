@@ -10,9 +10,24 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func getGoogleClient(scope string) *http.Client {
+const youtubePlaylistLimit = 200
+
+// googleScopes are requested on every Google login. The token is cached
+// once for YouTube and YouTube Music, so it has to carry the scopes every
+// step of a conversion needs: reading, writing playlists and liking.
+var googleScopes = []string{
+	youtube.YoutubeReadonlyScope,
+	youtube.YoutubepartnerScope,
+	youtube.YoutubeForceSslScope,
+}
+
+func getGoogleClient() *http.Client {
 	ctx := context.Background()
 
 	b, err := ioutil.ReadFile("googleClientSecret.json")
@@ -20,9 +35,9 @@ func getGoogleClient(scope string) *http.Client {
 		log.Fatalf("Unable to read google client secret file: %v", err)
 	}
 
-	// If modifying the scope, delete your previously saved credentials
+	// If modifying the scopes, delete your previously saved credentials
 	// at ~/.credentials/youtube-go.json
-	config, err := google.ConfigFromJSON(b, scope)
+	config, err := google.ConfigFromJSON(b, googleScopes...)
 	if err != nil {
 		log.Fatalf("Unable to parse client secret file to config: %v", err)
 	}
@@ -53,15 +68,6 @@ func getGoogleClient(scope string) *http.Client {
 	}
 	return config.Client(ctx, tok)
 }
-func getYoutubeVideoID(service *youtube.Service, videoName string) *youtube.SearchListResponse { //gets individual video IDs
-	part := []string{"id,snippet"}
-	call := service.Search.List(part).
-		Q(videoName).
-		MaxResults(1)
-	response, err := call.Do()
-	handleError(err, "")
-	return response
-}
 func youtubePlaylistMaker(service *youtube.Service, part []string, playlistName *youtube.PlaylistSnippet) string { //creates an empty playlist and returns the ID
 	playlist := &youtube.Playlist{
 		Snippet: playlistName,
@@ -89,55 +95,121 @@ func addItemsToYoutubePlaylist(service *youtube.Service, playListId string, vide
 	handleError(err, "")
 	return response
 }
-func playlistItemsList(service *youtube.Service, part []string, playlistId string, pageToken string) *youtube.PlaylistItemListResponse { //grabs all the items in a YouTube playlist
-	call := service.PlaylistItems.List(part)
-	call = call.PlaylistId(playlistId)
-	if pageToken != "" {
-		call = call.PageToken(pageToken)
+func youtubeAlbumTitle(reader youtubeReader, playlistId string) string { //gets the album name of a YouTube Music album playlist
+	album := reader.playlist(playlistId)
+	if album == nil {
+		return ""
 	}
-	response, err := call.Do()
-	handleError(err, "")
-	return response
+	return strings.TrimPrefix(album.Snippet.Title, "Album - ") //YouTube Music titles its album playlists "Album - <name>"
 }
-
-func createYouTubePlaylist(playlist []string) { //creates a YouTube playlist and adds the songs listed in the playlist slice to it
-	var part = []string{"id,snippet"}
-	var videoIdList []string
-	client := getGoogleClient(youtube.YoutubepartnerScope)
+func youtubeSummary(item *youtube.Playlist) playlistSummary {
+	return playlistSummary{
+		ID:          item.Id,
+		Name:        item.Snippet.Title,
+		Description: item.Snippet.Description,
+		Owner:       item.Snippet.ChannelTitle,
+		Owned:       true, //the Data API only lists playlists on the user's own channel
+		Public:      item.Status != nil && item.Status.PrivacyStatus == "public",
+		Tracks:      int(item.ContentDetails.ItemCount),
+	}
+}
+func youtubePlaylistSummary(ref playlistRef) playlistSummary { //gets the name and details of whatever a reference points at
+	if ref.ID == likedID {
+		return playlistSummary{ID: likedID, Name: "Liked videos", Owned: true}
+	}
+	reader := newYouTubeReader(settings.YouTube.Read, nil)
+	if ref.Kind == mixKind {
+		video := reader.video(ref.ID)
+		return playlistSummary{ID: ref.ID, Name: video.Snippet.Title, Description: video.Snippet.Description, Owner: video.Snippet.ChannelTitle}
+	}
+	if ref.Kind == artistKind || ref.Kind == discographyKind {
+		title := reader.channelTitle(ref.ID)
+		return playlistSummary{ID: ref.ID, Name: title, Owner: title}
+	}
+	playlist := reader.playlist(ref.ID)
+	if playlist == nil {
+		log.Fatalf("YouTube playlist %s not found", ref.ID)
+	}
+	summary := youtubeSummary(playlist)
+	summary.Owned = false
+	return summary
+}
+func listYouTubePlaylists() []playlistSummary { //lists the playlists on the current user's channel
+	var playlists []playlistSummary
+	part := []string{"snippet,contentDetails,status"}
+	client := getGoogleClient()
 	service, err := youtube.New(client)
 	if err != nil {
 		log.Fatalf("Error creating YouTube client: %v", err)
 	}
-	for i := range playlist { //gets Video IDs of songs by using the YouTube search method
-		videoSearch := getYoutubeVideoID(service, playlist[i])
-		for _, item := range videoSearch.Items {
-			videoIdList = append(videoIdList, item.Id.VideoId)
+	nextPageToken := ""
+	for {
+		call := service.Playlists.List(part).Mine(true).MaxResults(50)
+		if nextPageToken != "" {
+			call = call.PageToken(nextPageToken)
+		}
+		response, err := call.Do()
+		handleError(err, "Unable to list YouTube playlists")
+		for _, item := range response.Items {
+			playlists = append(playlists, youtubeSummary(item))
+		}
+		nextPageToken = response.NextPageToken
+		if nextPageToken == "" {
+			break
+		}
+	}
+	return playlists
+}
+
+func searchYouTubeVideos(service *youtube.Service, playlist []Track, result *ConversionResult, matches *matchCache) []string { //gets Video IDs of songs by using the YouTube search method
+	reader := newYouTubeReader(settings.YouTube.Search, service)
+	return matches.find(YOUTUBE, playlist, result, func(track Track) string {
+		videoSearch := reader.search(track.Query(), 1, false)
+		if len(videoSearch) == 0 {
+			return ""
 		}
+		return videoSearch[0].Id.VideoId
+	})
+}
+
+// youtubeSearch finds the video to use for each song, YouTube and YouTube
+// Music differ only in how they pick it.
+type youtubeSearch func(service *youtube.Service, playlist []Track, result *ConversionResult, matches *matchCache) []string
+
+func createYouTubePlaylist(name string, destination string, playlist []Track, matches *matchCache, search youtubeSearch) ConversionResult { //creates a YouTube playlist called name and adds the songs listed in the playlist slice to it
+	var part = []string{"id,snippet"}
+	result := ConversionResult{Name: name, Destination: destination, Total: len(playlist)}
+	client := getGoogleClient()
+	service, err := youtube.New(client)
+	if err != nil {
+		log.Fatalf("Error creating YouTube client: %v", err)
 	}
-	if len(videoIdList) < 200 { //YouTube has a 200 video per playlist limit, this splits the songs into multiple playlists if it is bigger then 200
+	videoIdList := search(service, playlist, &result, matches)
+	if len(videoIdList) < youtubePlaylistLimit { //YouTube has a 200 video per playlist limit, this splits the songs into multiple playlists if it is bigger then 200
 		playlistDetails := &youtube.PlaylistSnippet{
-			Title: "Converted Playlist",
+			Title: name,
 		}
 		ytPlaylistId := youtubePlaylistMaker(service, part, playlistDetails)
 		for x := range videoIdList {
 			addItemsToYoutubePlaylist(service, ytPlaylistId, videoIdList[x])
+			result.Added++
 		}
 	} else {
 		i := 0
 		x := 0
-		name := fmt.Sprintf("%v%d", "Playlist #", i+1)
-		howManyTimes := int(math.Ceil(float64(len(videoIdList) / 200)))
+		howManyTimes := int(math.Ceil(float64(len(videoIdList)) / youtubePlaylistLimit))
 		lenVideoIdList := len(videoIdList)
 		for i < howManyTimes {
 			c := 0
 			playlistDetails := &youtube.PlaylistSnippet{
-				Title: name,
+				Title: fmt.Sprintf("%v #%d", name, i+1),
 			}
 			i += 1
 			ytPlaylistId := youtubePlaylistMaker(service, part, playlistDetails)
 			for x < lenVideoIdList {
-				if c < 200 {
+				if c < youtubePlaylistLimit {
 					addItemsToYoutubePlaylist(service, ytPlaylistId, videoIdList[x])
+					result.Added++
 					c += 1
 					x += 1
 				} else {
@@ -146,7 +218,138 @@ func createYouTubePlaylist(playlist []string) { //creates a YouTube playlist and
 			}
 		}
 	}
-	fmt.Println(videoIdList[0])
 	fmt.Println("created youtube playlist")
-	return
+	return result
+}
+
+func youtubeLikedVideos(service *youtube.Service) []Track { //gets the videos the user has liked
+	var likedVideos []Track
+	part := []string{"snippet"}
+	nextPageToken := ""
+	for {
+		call := service.Videos.List(part).MyRating("like").MaxResults(50)
+		if nextPageToken != "" {
+			call = call.PageToken(nextPageToken)
+		}
+		response, err := call.Do()
+		handleError(err, "Unable to list liked videos")
+		for _, item := range response.Items {
+			track := youtubeTrack(item.Snippet.Title, item.Snippet.ChannelTitle)
+			track.ID = item.Id
+			track.Location = youtubeVideoURL(track.ID)
+			likedVideos = append(likedVideos, track)
+		}
+		nextPageToken = response.NextPageToken
+		if nextPageToken == "" {
+			break
+		}
+	}
+	return likedVideos
+}
+
+func likeYouTubeVideos(name string, destination string, playlist []Track, matches *matchCache, search youtubeSearch) ConversionResult { //likes the matching video for each song instead of adding it to a playlist
+	result := ConversionResult{Name: name, Destination: destination, Total: len(playlist)}
+	client := getGoogleClient()
+	service, err := youtube.New(client)
+	if err != nil {
+		log.Fatalf("Error creating YouTube client: %v", err)
+	}
+	videoIdList := search(service, playlist, &result, matches)
+	for _, videoId := range videoIdList {
+		err := service.Videos.Rate(videoId, "like").Do()
+		handleError(err, "Unable to like video "+videoId)
+		result.Added++
+	}
+	fmt.Println("liked youtube videos")
+	return result
+}
+
+var (
+	//a timestamp anywhere in a line, e.g. 1:02:03, [12:30] or (4:05)
+	mixTimestamp = regexp.MustCompile(`[\[(]?\b(?:(\d{1,2}):)?(\d{1,2}):(\d{2})\b[\])]?`)
+	//an ISO 8601 video length as given in contentDetails, e.g. PT1H2M3S
+	isoDuration = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+)
+
+// youtubeMixTracks reads the tracklist of a DJ mix from its description.
+// YouTube makes chapters out of the timestamped lines of the description,
+// so these are the only lines read. Each track lasts until the next
+// timestamp, or the end of the video. Unidentified tracks, listed as ID,
+// are skipped.
+func youtubeMixTracks(reader youtubeReader, videoId string) []Track {
+	video := reader.video(videoId)
+	fmt.Printf("Tracklist of %s\r\n", video.Snippet.Title)
+	var length time.Duration
+	if video.ContentDetails != nil {
+		length = parseISODuration(video.ContentDetails.Duration)
+	}
+	patterns, err := compileTextPatterns(settings.Text.Patterns)
+	handleError(err, "Invalid text pattern")
+	tracks, starts, ends := parseMixTracklist(video.Snippet.Description, patterns, settings.Text.TitleFirst)
+	for i := range tracks {
+		end := ends[i]
+		if end == 0 { //the last timestamp runs to the end of the video
+			end = length
+		}
+		if end > starts[i] {
+			tracks[i].Duration = end - starts[i]
+		}
+		tracks[i].Location = fmt.Sprintf("%s&t=%ds", youtubeVideoURL(videoId), int(starts[i].Seconds()))
+		fmt.Printf("%v %v\r\n", starts[i], tracks[i].Query())
+	}
+	if len(tracks) == 0 {
+		log.Fatalf("No timestamped tracklist found in the description of %s", video.Snippet.Title)
+	}
+	return tracks
+}
+
+// parseMixTracklist reads the tracks from the timestamped lines of a mix
+// description, along with the time each one starts. The timestamp may be
+// at the start or the end of the line, the rest is parsed the same way as
+// a text tracklist. Each track ends where the next timestamped line starts,
+// even one that is left out, such as an unreleased "ID", or at 0 when it is
+// the last.
+func parseMixTracklist(description string, patterns []*regexp.Regexp, titleFirst bool) ([]Track, []time.Duration, []time.Duration) {
+	var tracks []Track
+	var starts, ends []time.Duration
+	last := -1 //the track the previous timestamped line started, if it was kept
+	for _, line := range strings.Split(description, "\n") {
+		loc := mixTimestamp.FindStringSubmatchIndex(line)
+		if loc == nil {
+			continue
+		}
+		parts := mixTimestamp.FindStringSubmatch(line)
+		hours, _ := strconv.Atoi(parts[1])
+		minutes, _ := strconv.Atoi(parts[2])
+		seconds, _ := strconv.Atoi(parts[3])
+		start := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second
+		if last >= 0 {
+			ends[last] = start
+		}
+		last = -1
+		rest := strings.Trim(line[:loc[0]]+" "+line[loc[1]:], " \t-–—|:")
+		track, ok := parseTracklistLine(rest, patterns, titleFirst)
+		if !ok || strings.EqualFold(track.Title, "ID") {
+			continue
+		}
+		track.Duration = 0 //worked out from the next timestamp instead
+		last = len(tracks)
+		tracks = append(tracks, track)
+		starts = append(starts, start)
+		ends = append(ends, 0)
+	}
+	return tracks, starts, ends
+}
+
+func parseISODuration(value string) time.Duration { //parses a video length, anything unexpected counts as unknown
+	parts := isoDuration.FindStringSubmatch(value)
+	if parts == nil {
+		return 0
+	}
+	var length time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		n, _ := strconv.Atoi(parts[i+1])
+		length += time.Duration(n) * unit
+	}
+	return length
 }
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMixTracklist(t *testing.T) {
+	description := "Recorded live\n0:00 Daft Punk - One More Time\n4:30 ID - ID\n9:00 Justice - Genesis\n1:02:03 Moby - Porcelain"
+	tracks, starts, ends := parseMixTracklist(description, nil, false)
+	var titles []string
+	for _, track := range tracks {
+		titles = append(titles, track.Title)
+	}
+	if want := []string{"One More Time", "Genesis", "Porcelain"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("tracks = %q, want %q", titles, want)
+	}
+	minute := time.Minute
+	if want := []time.Duration{0, 9 * minute, time.Hour + 2*minute + 3*time.Second}; !reflect.DeepEqual(starts, want) {
+		t.Errorf("starts = %v, want %v", starts, want)
+	}
+	if want := []time.Duration{4*minute + 30*time.Second, time.Hour + 2*minute + 3*time.Second, 0}; !reflect.DeepEqual(ends, want) {
+		t.Errorf("ends = %v, want %v, the first ending where the ID starts", ends, want)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"google.golang.org/api/youtube/v3"
+	"log"
+	"strings"
+)
+
+// youtubeMusicLikedListID is the ID YouTube Music gives the liked songs list.
+const youtubeMusicLikedListID = "LM"
+
+// youtubeMusicCategory is the Music video category, which search results are
+// limited to.
+const youtubeMusicCategory = "10"
+
+// youtubeMusicSearchResults is how many search results are looked through
+// for a Topic channel song before settling for the top video. Search costs
+// the same quota whatever the number of results.
+const youtubeMusicSearchResults = 10
+
+// YouTubeMusic reads and writes the same playlists as YouTube, but matches
+// songs to the audio tracks YouTube Music plays, which are videos on
+// auto-generated "Artist - Topic" channels, rather than to music videos,
+// lyric videos or fan uploads.
+type YouTubeMusic struct {
+	ID   string
+	Kind refKind
+}
+
+func NewYouTubeMusic(ref playlistRef) *YouTubeMusic {
+	return &YouTubeMusic{ID: ref.ID, Kind: ref.Kind}
+}
+
+func (Y *YouTubeMusic) GetTracks() []Track {
+	if Y.ID != likedID {
+		return NewYoutube(playlistRef{Service: YOUTUBE, Kind: Y.Kind, ID: Y.ID}).GetTracks()
+	}
+	client := getGoogleClient()
+	service, err := youtube.New(client)
+	if err != nil {
+		log.Fatalf("Error creating YouTube client: %v", err)
+	}
+	var songs []Track
+	for _, track := range youtubeLikedVideos(service) {
+		if track.Artist != "" { //youtubeTrack only knows the artist of Topic channel songs, any other liked video is not a song in the library
+			songs = append(songs, track)
+		}
+	}
+	return songs
+}
+
+// WritePlaylist creates a new playlist, or likes the songs when the
+// reference is to the liked songs.
+func (Y *YouTubeMusic) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	switch Y.ID {
+	case "":
+		return createYouTubePlaylist(name, "youtubemusic", tracks, matches, searchYouTubeMusicSongs)
+	case likedID:
+		return likeYouTubeVideos("Liked songs", "youtubemusic:liked", tracks, matches, searchYouTubeMusicSongs)
+	case albumsID:
+		log.Fatalf("The YouTube API cannot save albums to a YouTube Music library, convert to youtubemusic to make a playlist of the album")
+	}
+	log.Fatalf("Adding to an existing YouTube Music playlist is not supported")
+	return ConversionResult{}
+}
+
+// bestYouTubeMusicResult picks a song from the search results: a Topic
+// channel upload with the same title, then any Topic channel upload whose
+// title holds the song's, then the top result whatever it is.
+func bestYouTubeMusicResult(track Track, results []*youtube.SearchResult) string {
+	title := normaliseName(track.Title)
+	var partial string
+	for _, item := range results {
+		if item.Id == nil || item.Id.VideoId == "" || !strings.HasSuffix(item.Snippet.ChannelTitle, " - Topic") {
+			continue
+		}
+		candidate := normaliseName(item.Snippet.Title)
+		if candidate == title {
+			return item.Id.VideoId
+		}
+		if partial == "" && strings.Contains(candidate, title) {
+			partial = item.Id.VideoId
+		}
+	}
+	if partial != "" {
+		return partial
+	}
+	for _, item := range results {
+		if item.Id != nil && item.Id.VideoId != "" {
+			return item.Id.VideoId
+		}
+	}
+	return ""
+}
+
+func searchYouTubeMusicSongs(service *youtube.Service, playlist []Track, result *ConversionResult, matches *matchCache) []string { //gets Video IDs of songs, preferring the Topic channel audio over music videos
+	reader := newYouTubeReader(settings.YouTube.Search, service)
+	return matches.find(YOUTUBEMUSIC, playlist, result, func(track Track) string {
+		term := strings.TrimSpace(leadArtist(track.Artist) + " " + cleanVideoTitle(track.Title))
+		return bestYouTubeMusicResult(track, reader.search(term, youtubeMusicSearchResults, true))
+	})
+}
+
+func youtubeMusicPlaylistSummary(ref playlistRef) playlistSummary { //gets the name and details of whatever a reference points at
+	if ref.ID == likedID {
+		return playlistSummary{ID: likedID, Name: "Liked songs", Owned: true}
+	}
+	return youtubePlaylistSummary(ref)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// apiRequest sends a request to a JSON web API. body, when not nil, is sent
+// as a form for url.Values and as JSON otherwise, and the response is
+// decoded into out, when not nil. Responses other than 2xx are returned as
+// an *apiStatusError with the start of their body.
+func apiRequest(client *http.Client, method string, target string, header http.Header, body interface{}, out interface{}) error {
+	var reader io.Reader
+	contentType := "application/json"
+	if form, ok := body.(url.Values); ok {
+		reader, contentType = strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"
+	} else if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &apiStatusError{Method: method, Target: target, Status: resp.Status, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// apiStatusError is a response other than 2xx, so callers can tell an
+// expired sign in from other failures.
+type apiStatusError struct {
+	Method     string
+	Target     string
+	Status     string
+	StatusCode int
+	Message    string //the start of the body
+}
+
+func (e *apiStatusError) Error() string {
+	return fmt.Sprintf("%s %s returned %s: %s", e.Method, e.Target, e.Status, e.Message)
+}
+
+// headerTransport adds headers, such as API keys, to every request.
+type headerTransport struct {
+	header http.Header
+	base   http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, values := range t.header {
+		req.Header[key] = values
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
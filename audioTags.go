@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// readTags reads the title, artist, album, ISRC, MusicBrainz recording ID
+// and length of an audio file from its ID3v2 tag, Vorbis comments or MP4
+// metadata. Anything the file does not have is left empty.
+func readTags(path string) (Track, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Track{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Track{}, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3", ".aac":
+		return readMP3(f, info.Size())
+	case ".flac":
+		return readFLAC(f)
+	case ".ogg", ".opus":
+		return readOgg(f, info.Size())
+	case ".m4a", ".mp4", ".alac":
+		return readMP4(f, info.Size())
+	}
+	return Track{}, nil
+}
+
+// id3Frames maps the ID3v2.3 and v2.4 frames read, and their v2.2 names,
+// to what they hold.
+var id3Frames = map[string]string{
+	"TIT2": "title", "TT2": "title",
+	"TPE1": "artist", "TP1": "artist",
+	"TALB": "album", "TAL": "album",
+	"TSRC": "isrc", "TRC": "isrc",
+	"TLEN": "length", "TLE": "length",
+	"UFID": "ufid", "UFI": "ufid",
+}
+
+// readMP3 reads the ID3v2 tag at the start of the file, then works out the
+// length from the Xing or VBRI header of the first MPEG frame, or from the
+// bitrate for constant bitrate files.
+func readMP3(f io.ReaderAt, size int64) (Track, error) {
+	var track Track
+	header := make([]byte, 10)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return track, err
+	}
+	var audioStart int64
+	if string(header[:3]) == "ID3" {
+		tagSize := int64(syncsafe(header[6:10]))
+		if tagSize > size-10 { //a corrupt or cut off file
+			return track, errors.New("ID3 tag is larger than the file")
+		}
+		audioStart = 10 + tagSize
+		if header[5]&0x10 != 0 { //footer
+			audioStart += 10
+		}
+		tag := make([]byte, tagSize)
+		if _, err := f.ReadAt(tag, 10); err != nil {
+			return track, err
+		}
+		parseID3(&track, header[3], header[5], tag)
+	}
+	if track.Duration == 0 {
+		frames := make([]byte, 64*1024)
+		n, _ := f.ReadAt(frames, audioStart)
+		track.Duration = mpegLength(frames[:n], size-audioStart)
+	}
+	return track, nil
+}
+
+func parseID3(track *Track, version byte, flags byte, tag []byte) {
+	if flags&0x80 != 0 && version < 4 { //unsynchronised, v2.4 does this per frame
+		tag = bytes.ReplaceAll(tag, []byte{0xff, 0x00}, []byte{0xff})
+	}
+	if flags&0x40 != 0 && len(tag) >= 4 { //extended header
+		skip := 4 + int(binary.BigEndian.Uint32(tag[:4]))
+		if version == 4 {
+			skip = int(syncsafe(tag[:4]))
+		}
+		if skip > len(tag) {
+			return
+		}
+		tag = tag[skip:]
+	}
+	idLength, headerLength := 4, 10
+	if version == 2 {
+		idLength, headerLength = 3, 6
+	}
+	for len(tag) >= headerLength && tag[0] != 0 {
+		id := string(tag[:idLength])
+		var frameSize int
+		switch version {
+		case 2:
+			frameSize = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(tag[4:8]))
+		default:
+			frameSize = int(syncsafe(tag[4:8]))
+		}
+		if frameSize > len(tag)-headerLength {
+			return
+		}
+		data := tag[headerLength : headerLength+frameSize]
+		if version == 4 && tag[9]&0x02 != 0 {
+			data = bytes.ReplaceAll(data, []byte{0xff, 0x00}, []byte{0xff})
+		}
+		if version == 4 && tag[9]&0x01 != 0 && len(data) >= 4 { //data length indicator
+			data = data[4:]
+		}
+		tag = tag[headerLength+frameSize:]
+
+		switch id3Frames[id] {
+		case "title":
+			track.Title = id3Text(data)
+		case "artist":
+			track.Artist = id3Text(data)
+		case "album":
+			track.Album = id3Text(data)
+		case "isrc":
+			track.ISRC = id3Text(data)
+		case "length":
+			if ms, err := strconv.Atoi(id3Text(data)); err == nil {
+				track.Duration = time.Duration(ms) * time.Millisecond
+			}
+		case "ufid": //an owner URL and the ID, MusicBrainz uses its own URL
+			if owner, id, found := bytes.Cut(data, []byte{0}); found && string(owner) == "http://musicbrainz.org" {
+				track.MusicBrainzID = string(id)
+			}
+		}
+	}
+}
+
+func id3Text(data []byte) string { //decodes a text frame, keeping the first of several values
+	if len(data) == 0 {
+		return ""
+	}
+	encoding, data := data[0], data[1:]
+	var text string
+	switch encoding {
+	case 1, 2: //UTF-16 with a byte order mark, or big endian
+		text = decodeUTF16(data, encoding == 2)
+	case 3:
+		text = string(data)
+	default: //ISO-8859-1
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+	text, _, _ = strings.Cut(text, "\x00")
+	return strings.TrimSpace(text)
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	if len(data) >= 2 && data[0] == 0xfe && data[1] == 0xff {
+		bigEndian, data = true, data[2:]
+	} else if len(data) >= 2 && data[0] == 0xff && data[1] == 0xfe {
+		bigEndian, data = false, data[2:]
+	}
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+func syncsafe(b []byte) uint32 { //an ID3 size, seven bits to a byte
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
+
+var (
+	mpeg1Layer3Bitrates = []int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+	mpeg2Layer3Bitrates = []int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+	mpegSampleRates     = []int{44100, 48000, 32000}
+)
+
+// mpegLength works out the length of MPEG layer III audio from its first
+// frame, given the bytes from the start of the audio and the audio's size.
+func mpegLength(frames []byte, audioSize int64) time.Duration {
+	for i := 0; i+4 <= len(frames); i++ {
+		if frames[i] != 0xff || frames[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		version, layer := (frames[i+1]>>3)&3, (frames[i+1]>>1)&3
+		bitrateIndex, rateIndex := int(frames[i+2]>>4), int((frames[i+2]>>2)&3)
+		if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue //reserved values, or not layer III
+		}
+		mono := frames[i+3]>>6 == 3
+		sampleRate, samples, bitrate := mpegSampleRates[rateIndex], 1152, mpeg1Layer3Bitrates[bitrateIndex]
+		sideInfo := 32
+		if mono {
+			sideInfo = 17
+		}
+		if version != 3 { //MPEG 2 and 2.5
+			sampleRate /= 2
+			if version == 0 {
+				sampleRate /= 2
+			}
+			samples, bitrate = 576, mpeg2Layer3Bitrates[bitrateIndex]
+			sideInfo = 17
+			if mono {
+				sideInfo = 9
+			}
+		}
+		frameCount := 0
+		if xing := i + 4 + sideInfo; xing+12 <= len(frames) && (string(frames[xing:xing+4]) == "Xing" || string(frames[xing:xing+4]) == "Info") {
+			if binary.BigEndian.Uint32(frames[xing+4:])&1 != 0 {
+				frameCount = int(binary.BigEndian.Uint32(frames[xing+8:]))
+			}
+		} else if vbri := i + 36; vbri+18 <= len(frames) && string(frames[vbri:vbri+4]) == "VBRI" {
+			frameCount = int(binary.BigEndian.Uint32(frames[vbri+14:]))
+		}
+		if frameCount > 0 {
+			return time.Duration(frameCount) * time.Duration(samples) * time.Second / time.Duration(sampleRate)
+		}
+		return time.Duration(audioSize-int64(i)) * 8 * time.Millisecond / time.Duration(bitrate)
+	}
+	return 0
+}
+
+// readFLAC reads the STREAMINFO block for the length and the Vorbis comment
+// block for the tags.
+func readFLAC(f io.ReadSeeker) (Track, error) {
+	var track Track
+	r := bufio.NewReader(f)
+	marker := make([]byte, 4)
+	if _, err := io.ReadFull(r, marker); err != nil {
+		return track, err
+	}
+	if string(marker) != "fLaC" {
+		return track, errors.New("not a FLAC file")
+	}
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return track, err
+		}
+		last, blockType := header[0]&0x80 != 0, header[0]&0x7f
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		switch blockType {
+		case 0, 4: //STREAMINFO and VORBIS_COMMENT
+			block := make([]byte, length)
+			if _, err := io.ReadFull(r, block); err != nil {
+				return track, err
+			}
+			if blockType == 4 {
+				parseVorbisComment(&track, block)
+			} else if len(block) >= 18 {
+				sampleRate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+				samples := int64(block[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(block[14:18]))
+				if sampleRate > 0 {
+					track.Duration = time.Duration(samples) * time.Second / time.Duration(sampleRate)
+				}
+			}
+		default: //pictures and padding
+			if _, err := r.Discard(length); err != nil {
+				return track, err
+			}
+		}
+		if last {
+			return track, nil
+		}
+	}
+}
+
+// parseVorbisComment reads the tags from a Vorbis comment, as used by FLAC,
+// Ogg Vorbis and Opus: a vendor string then a list of KEY=value strings.
+func parseVorbisComment(track *Track, data []byte) {
+	next := func() (string, bool) {
+		if len(data) < 4 {
+			return "", false
+		}
+		length := int(binary.LittleEndian.Uint32(data))
+		if length > len(data)-4 {
+			return "", false
+		}
+		value := string(data[4 : 4+length])
+		data = data[4+length:]
+		return value, true
+	}
+	if _, ok := next(); !ok || len(data) < 4 { //vendor
+		return
+	}
+	count := int(binary.LittleEndian.Uint32(data))
+	data = data[4:]
+	for i := 0; i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			return
+		}
+		key, value, _ := strings.Cut(comment, "=")
+		var field *string
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			field = &track.Title
+		case "ARTIST":
+			field = &track.Artist
+		case "ALBUM":
+			field = &track.Album
+		case "ISRC":
+			field = &track.ISRC
+		case "MUSICBRAINZ_TRACKID": //the recording, despite the name
+			field = &track.MusicBrainzID
+		}
+		if field != nil && *field == "" {
+			*field = strings.TrimSpace(value)
+		}
+	}
+}
+
+// readOgg reads the identification and comment headers at the start of an
+// Ogg Vorbis or Opus file, and the length from the position of the last
+// page.
+func readOgg(f io.ReadSeeker, size int64) (Track, error) {
+	var track Track
+	r := bufio.NewReader(f)
+	var packets [][]byte
+	var packet []byte
+	for len(packets) < 2 {
+		header := make([]byte, 27)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return track, err
+		}
+		if string(header[:4]) != "OggS" {
+			return track, errors.New("not an Ogg file")
+		}
+		segments := make([]byte, header[26])
+		if _, err := io.ReadFull(r, segments); err != nil {
+			return track, err
+		}
+		for _, length := range segments {
+			data := make([]byte, length)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return track, err
+			}
+			packet = append(packet, data...)
+			if length < 255 { //the end of a packet
+				packets = append(packets, packet)
+				packet = nil
+			}
+		}
+	}
+
+	identification, comment := packets[0], packets[1]
+	sampleRate, preSkip := 0, 0
+	switch {
+	case bytes.HasPrefix(identification, []byte("\x01vorbis")) && len(identification) >= 16:
+		sampleRate = int(binary.LittleEndian.Uint32(identification[12:]))
+		comment = bytes.TrimPrefix(comment, []byte("\x03vorbis"))
+	case bytes.HasPrefix(identification, []byte("OpusHead")) && len(identification) >= 12:
+		sampleRate, preSkip = 48000, int(binary.LittleEndian.Uint16(identification[10:])) //Opus always counts at 48kHz
+		comment = bytes.TrimPrefix(comment, []byte("OpusTags"))
+	default:
+		return track, errors.New("not a Vorbis or Opus stream")
+	}
+	parseVorbisComment(&track, comment)
+
+	tailSize := int64(64 * 1024)
+	if tailSize > size {
+		tailSize = size
+	}
+	if _, err := f.Seek(size-tailSize, io.SeekStart); err != nil {
+		return track, err
+	}
+	tail := make([]byte, tailSize)
+	if _, err := io.ReadFull(f, tail); err != nil {
+		return track, err
+	}
+	if last := bytes.LastIndex(tail, []byte("OggS")); last >= 0 && last+14 <= len(tail) && sampleRate > 0 {
+		granule := int64(binary.LittleEndian.Uint64(tail[last+6:]))
+		track.Duration = time.Duration(granule-int64(preSkip)) * time.Second / time.Duration(sampleRate)
+	}
+	return track, nil
+}
+
+// mp4Containers are the boxes holding the ones read, the rest are skipped.
+var mp4Containers = map[string]bool{"moov": true, "udta": true, "meta": true, "ilst": true}
+
+// readMP4 reads the iTunes style metadata in moov/udta/meta/ilst and the
+// length from the movie header.
+func readMP4(f io.ReaderAt, size int64) (Track, error) {
+	var track Track
+	err := walkMP4(f, 0, size, func(box string, data []byte) {
+		switch box {
+		case "mvhd":
+			var timescale, duration uint64
+			if len(data) >= 32 && data[0] == 1 {
+				timescale, duration = uint64(binary.BigEndian.Uint32(data[20:])), binary.BigEndian.Uint64(data[24:])
+			} else if len(data) >= 20 {
+				timescale, duration = uint64(binary.BigEndian.Uint32(data[12:])), uint64(binary.BigEndian.Uint32(data[16:]))
+			}
+			if timescale > 0 {
+				track.Duration = time.Duration(duration) * time.Second / time.Duration(timescale)
+			}
+		case "\xa9nam":
+			track.Title = mp4Value(data)
+		case "\xa9ART":
+			track.Artist = mp4Value(data)
+		case "\xa9alb":
+			track.Album = mp4Value(data)
+		case "----":
+			name, value := mp4Freeform(data)
+			switch name {
+			case "ISRC":
+				track.ISRC = value
+			case "MusicBrainz Track Id": //the recording, despite the name
+				track.MusicBrainzID = value
+			}
+		}
+	})
+	return track, err
+}
+
+// walkMP4 calls visit with every box between start and end that is not a
+// container, reading only the small ones so the media data is skipped.
+func walkMP4(f io.ReaderAt, start int64, end int64, visit func(box string, data []byte)) error {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return err
+		}
+		boxSize, box, headerSize := int64(binary.BigEndian.Uint32(header)), string(header[4:8]), int64(8)
+		switch boxSize {
+		case 0: //to the end of the file
+			boxSize = end - offset
+		case 1: //a 64 bit size follows
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return err
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if boxSize < headerSize || offset+boxSize > end {
+			return errors.New("invalid MP4 box size")
+		}
+		contentStart := offset + headerSize
+		if box == "meta" { //a full box, with a version and flags before its children
+			flags := make([]byte, 4)
+			if _, err := f.ReadAt(flags, contentStart); err == nil && bytes.Equal(flags, []byte{0, 0, 0, 0}) {
+				contentStart += 4
+			}
+		}
+		switch {
+		case mp4Containers[box]:
+			if err := walkMP4(f, contentStart, offset+boxSize, visit); err != nil {
+				return err
+			}
+		case boxSize-headerSize <= 1<<20 && box != "mdat" && box != "free":
+			data := make([]byte, boxSize-headerSize)
+			if _, err := f.ReadAt(data, contentStart); err != nil {
+				return err
+			}
+			visit(box, data)
+		}
+		offset += boxSize
+	}
+	return nil
+}
+
+func mp4Children(data []byte) map[string][]byte { //splits the content of a metadata item into its boxes
+	children := make(map[string][]byte)
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			break
+		}
+		if _, seen := children[string(data[4:8])]; !seen {
+			children[string(data[4:8])] = data[8:size]
+		}
+		data = data[size:]
+	}
+	return children
+}
+
+func mp4Value(item []byte) string { //the text in an item's data box, after its type and locale
+	data := mp4Children(item)["data"]
+	if len(data) < 8 {
+		return ""
+	}
+	return strings.TrimSpace(string(data[8:]))
+}
+
+func mp4Freeform(item []byte) (string, string) { //the name and value of a ---- item, such as com.apple.iTunes:ISRC
+	name := mp4Children(item)["name"]
+	if len(name) < 4 {
+		return "", ""
+	}
+	return string(name[4:]), mp4Value(item)
+}
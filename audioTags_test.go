@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func id3Frame(id string, data string) []byte { //an ID3v2.3 frame
+	frame := append([]byte(id), 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(frame[len(id):], uint32(len(data)))
+	return append(frame, data...)
+}
+
+func TestReadMP3(t *testing.T) {
+	var tag []byte
+	tag = append(tag, id3Frame("TIT2", "\x03One More Time")...)
+	tag = append(tag, id3Frame("TPE1", "\x01\xff\xfeD\x00a\x00f\x00t\x00 \x00P\x00u\x00n\x00k\x00")...) //UTF-16 with a byte order mark
+	tag = append(tag, id3Frame("TSRC", "\x00GBDUW0000053")...)
+	tag = append(tag, id3Frame("TLEN", "\x00320000")...)
+	tag = append(tag, id3Frame("UFID", "http://musicbrainz.org\x00d3b2a0b6-1f9d-4b46-9a3c-6a2b5e1c4f10")...)
+	tag = append(tag, make([]byte, 16)...) //padding
+	size := uint32(len(tag))
+	file := append([]byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}, tag...)
+
+	track, err := readMP3(bytes.NewReader(file), int64(len(file)))
+	want := Track{Title: "One More Time", Artist: "Daft Punk", ISRC: "GBDUW0000053", MusicBrainzID: "d3b2a0b6-1f9d-4b46-9a3c-6a2b5e1c4f10", Duration: 320 * time.Second}
+	if err != nil || track != want {
+		t.Errorf("readMP3 = %+v, %v, want %+v", track, err, want)
+	}
+
+	truncated := []byte{'I', 'D', '3', 3, 0, 0, 0x7f, 0x7f, 0x7f, 0x7f, 'T', 'I', 'T', '2'}
+	if track, err := readMP3(bytes.NewReader(truncated), int64(len(truncated))); err == nil {
+		t.Errorf("a tag larger than the file read as %+v, want an error", track)
+	}
+}
+
+func TestReadFLAC(t *testing.T) {
+	length := func(n int) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(n))
+		return b
+	}
+	comment := append(length(3), "lib"...)
+	fields := []string{"TITLE=One More Time", "ARTIST=Daft Punk", "ISRC=GBDUW0000053", "MUSICBRAINZ_TRACKID=d3b2a0b6-1f9d-4b46-9a3c-6a2b5e1c4f10"}
+	comment = append(comment, length(len(fields))...)
+	for _, field := range fields {
+		comment = append(append(comment, length(len(field))...), field...)
+	}
+	streamInfo := make([]byte, 34)
+	streamInfo[10], streamInfo[11], streamInfo[12] = 0x0a, 0xc4, 0x40 //44100 Hz
+	binary.BigEndian.PutUint32(streamInfo[14:18], 44100*320)
+	file := []byte("fLaC")
+	file = append(file, 0, 0, 0, byte(len(streamInfo)))
+	file = append(file, streamInfo...)
+	file = append(file, 0x80|4, 0, byte(len(comment)>>8), byte(len(comment)))
+	file = append(file, comment...)
+
+	track, err := readFLAC(bytes.NewReader(file))
+	want := Track{Title: "One More Time", Artist: "Daft Punk", ISRC: "GBDUW0000053", MusicBrainzID: "d3b2a0b6-1f9d-4b46-9a3c-6a2b5e1c4f10", Duration: 320 * time.Second}
+	if err != nil || track != want {
+		t.Errorf("readFLAC = %+v, %v, want %+v", track, err, want)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// backup implements the backup command, which snapshots playlists with all
+// of their metadata into a backup file that restore can recreate them from.
+func backup(args []string) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	output := flags.String("o", "", "file to write the backup to (default backup-<date>.json)")
+	all := flags.String("all", "", "back up every playlist in the library of this service ("+libraryServices+")")
+	followed := flags.Bool("followed", false, "with -all, also back up "+followedHelp)
+	include := flags.String("include", "", "with -all, only back up playlists whose name matches this regular expression")
+	exclude := flags.String("exclude", "", "with -all, skip playlists whose name matches this regular expression")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: backup [-o FILE] PLAYLIST...")
+		fmt.Fprintln(flags.Output(), "       backup [-o FILE] -all SERVICE [-followed] [-include REGEXP] [-exclude REGEXP]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if (*all == "") == (flags.NArg() == 0) {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = "backup-" + time.Now().Format("2006-01-02") + ".json"
+	}
+
+	var refs []playlistRef
+	var summaries []playlistSummary
+	if *all != "" {
+		service, err := serviceFromName(*all)
+		handleError(err, "Invalid -all")
+		includeRe, err := compileOptional(*include)
+		handleError(err, "Invalid -include pattern")
+		excludeRe, err := compileOptional(*exclude)
+		handleError(err, "Invalid -exclude pattern")
+		for _, p := range filterPlaylists(listPlaylists(service, *followed), includeRe, excludeRe) {
+			refs = append(refs, playlistRef{Service: service, ID: p.ID})
+			summaries = append(summaries, p)
+		}
+	}
+	for _, arg := range flags.Args() {
+		ref, err := parsePlaylistRef(arg)
+		handleError(err, "Invalid playlist")
+		switch ref.Service {
+		case SPOTIFY:
+			summaries = append(summaries, spotifyPlaylistSummary(ref))
+		case YOUTUBE:
+			summaries = append(summaries, youtubePlaylistSummary(ref))
+		case YOUTUBEMUSIC:
+			summaries = append(summaries, youtubeMusicPlaylistSummary(ref))
+		case APPLEMUSIC:
+			summaries = append(summaries, appleMusicPlaylistSummary(ref))
+		case DEEZER:
+			summaries = append(summaries, deezerPlaylistSummary(ref))
+		case TIDAL:
+			summaries = append(summaries, tidalPlaylistSummary(ref))
+		case SOUNDCLOUD:
+			summaries = append(summaries, soundCloudPlaylistSummary(ref))
+		case SUBSONIC:
+			summaries = append(summaries, subsonicPlaylistSummary(ref))
+		case JELLYFIN:
+			summaries = append(summaries, jellyfinPlaylistSummary(ref))
+		case PLEX:
+			summaries = append(summaries, plexPlaylistSummary(ref))
+		case MPD:
+			summaries = append(summaries, mpdPlaylistSummary(ref))
+		default:
+			log.Fatalf("Only %s playlists can be backed up, %s is not one", libraryServices, arg)
+		}
+		refs = append(refs, ref)
+	}
+
+	var playlists []backupPlaylist
+	for i, ref := range refs {
+		fmt.Printf("Backing up %s\n", summaries[i].Name)
+		tracks := newSource(ref).GetTracks()
+		playlists = append(playlists, newBackupPlaylist(summaries[i], ref, tracks))
+	}
+	writeBackup(*output, playlists)
+	fmt.Printf("Backed up %d playlists\n", len(playlists))
+	cleanUp()
+}
+
+// restore implements the restore command, which recreates the playlists in
+// a backup file on one or more destinations. Tracks restored to the service
+// they were backed up from keep their original IDs instead of being
+// searched for again.
+func restore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	include := flags.String("include", "", "only restore playlists whose name matches this regular expression")
+	exclude := flags.String("exclude", "", "skip playlists whose name matches this regular expression")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: restore [-include REGEXP] [-exclude REGEXP] BACKUP DESTINATION...")
+		fmt.Fprintln(flags.Output(), "  DESTINATION is a service such as spotify, youtube, applemusic or deezer, its liked songs such as spotify:liked, spotify:albums or a playlist file")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	includeRe, err := compileOptional(*include)
+	handleError(err, "Invalid -include pattern")
+	excludeRe, err := compileOptional(*exclude)
+	handleError(err, "Invalid -exclude pattern")
+
+	backup := readBackup(flags.Arg(0))
+	destinations := parseDestinations(flags.Args()[1:], BACKUP)
+	matches := newMatchCache()
+	var results []ConversionResult
+	for _, playlist := range backup.Playlists {
+		if (includeRe != nil && !includeRe.MatchString(playlist.Name)) || (excludeRe != nil && excludeRe.MatchString(playlist.Name)) {
+			continue
+		}
+		fmt.Printf("Restoring %s\n", playlist.Name)
+		tracks := playlist.tracks()
+		if service, err := serviceFromName(playlist.Service); err == nil && !service.isFile() {
+			for _, track := range tracks {
+				if track.ID != "" { //local files have no ID
+					matches.put(service, matchKey(track), track.ID)
+				}
+			}
+		}
+		results = append(results, writeAll(destinations, playlist.Name, tracks, matches)...)
+	}
+	printReport(results)
+	cleanUp()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// configFile holds optional settings, in the working directory next to the
+// client secret files. See "config - Template.json" for an example.
+const configFile = "config.json"
+
+// Config holds the settings read from the config file. Every setting is
+// optional and a missing config file is the same as an empty one.
+type Config struct {
+	CSV          csvConfig          `json:"csv"`
+	Text         textConfig         `json:"text"`
+	Library      libraryConfig      `json:"library"`
+	SetlistFM    setlistFMConfig    `json:"setlistfm"`
+	AppleMusic   appleMusicConfig   `json:"appleMusic"`
+	Deezer       deezerConfig       `json:"deezer"`
+	Tidal        tidalConfig        `json:"tidal"`
+	SoundCloud   soundCloudConfig   `json:"soundcloud"`
+	YouTube      youtubeConfig      `json:"youtube"`
+	Subsonic     subsonicConfig     `json:"subsonic"`
+	Jellyfin     jellyfinConfig     `json:"jellyfin"`
+	Plex         plexConfig         `json:"plex"`
+	MPD          mpdConfig          `json:"mpd"`
+	LastFM       lastFMConfig       `json:"lastfm"`
+	ListenBrainz listenBrainzConfig `json:"listenbrainz"`
+}
+
+// settings is the configuration of the current run, loaded in main.
+var settings Config
+
+func loadConfig(path string) Config {
+	var config Config
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config
+	}
+	if err != nil {
+		log.Fatalf("Unable to read config file: %v", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		log.Fatalf("Unable to parse config file %s: %v", path, err)
+	}
+	return config
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withSettings puts the settings back as they were when the test ends, so
+// the test can point them at a stand-in.
+func withSettings(t *testing.T) {
+	previous := settings
+	t.Cleanup(func() { settings = previous })
+}
+
+// standIn serves handler in place of a service's API until the test ends
+// and returns its URL, with the settings put back afterwards.
+func standIn(t *testing.T, handler http.Handler) string {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	withSettings(t)
+	return server.URL
+}
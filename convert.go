@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// convert implements the convert command, which copies a single playlist
+// given as a reference such as spotify:liked or youtube:<playlist id>.
+// The source is read once and written to every destination given, each of
+// which is either a service, to create a new playlist, the service's liked
+// collection or, on Spotify, the saved albums.
+func convert(args []string) {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	name := flags.String("name", "Converted Playlist", "name of the playlist created on the destination")
+	discography := flags.Bool("discography", false, "convert every track on an artist's albums and singles instead of their top tracks (Spotify only)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: convert [-name NAME] [-discography] SOURCE DESTINATION...")
+		fmt.Fprintln(flags.Output(), "  SOURCE is a Spotify, YouTube, Apple Music, Deezer or Tidal playlist, album or artist link, a SoundCloud set or user link, subsonic:<playlist id>, jellyfin:<playlist id>, plex:<playlist id>, mpd:<stored playlist>, URI or ID, a YouTube mix video link, local:<folder>, a setlist.fm link, lastfm:[<user>:]loved or top[:<period>][:<limit>] such as lastfm:top:2025:100, listenbrainz:[<user>:]listens, top or recommendations, a Last.fm or ListenBrainz export as lastfm:<file> or listenbrainz:<file>, spotify:liked, youtube:liked, a playlist file or a text tracklist (text:- reads standard input)")
+		fmt.Fprintln(flags.Output(), "  DESTINATION is a service such as spotify, youtube, applemusic, youtubemusic, deezer, tidal, soundcloud, subsonic, jellyfin, plex or mpd to create a playlist, its liked songs such as spotify:liked, spotify:albums, a playlist file such as m3u:<path>, local for an M3U of files in the music folder or a DJ playlist such as rekordbox:<path>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	source, err := parsePlaylistRef(flags.Arg(0))
+	handleError(err, "Invalid source")
+	if source.ID == "" || source.ID == albumsID {
+		log.Fatalf("Please give the playlist to convert, for example %s:%s", source.Service, likedID)
+	}
+	if *discography && source.Service != SPOTIFY {
+		log.Fatalf("-discography is only supported for Spotify artists, not %s", source.Service)
+	}
+	if *discography && source.Kind == artistKind {
+		source.Kind = discographyKind
+	}
+	destinations := parseDestinations(flags.Args()[1:], source.Service)
+
+	songs := newSource(source).GetTracks()
+	printReport(writeAll(destinations, *name, songs, newMatchCache()))
+	cleanUp()
+}
+
+func parseDestinations(refs []string, start Service) []playlistRef { //parses and checks the destinations of a job reading from start
+	var destinations []playlistRef
+	for _, ref := range refs {
+		destination, err := parsePlaylistRef(ref)
+		handleError(err, "Invalid destination")
+		if destination.Service.isFile() {
+			destinations = append(destinations, destination)
+			continue
+		}
+		if destination.ID != "" && destination.ID != likedID && destination.ID != albumsID {
+			log.Fatalf("Adding to an existing playlist is not supported, give only the service name to create a new playlist")
+		}
+		if destination.Service == start {
+			log.Fatalf("Please make sure your start and ending services are different")
+		}
+		destinations = append(destinations, destination)
+	}
+	return destinations
+}
+
+// writeAll writes the tracks to every destination in turn. Files are
+// written last so they can include what the services matched.
+func writeAll(destinations []playlistRef, name string, tracks []Track, matches *matchCache) []ConversionResult {
+	var results []ConversionResult
+	for _, files := range []bool{false, true} {
+		for _, destination := range destinations {
+			if destination.Service.isFile() == files {
+				results = append(results, newDestination(destination).WritePlaylist(name, tracks, matches))
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// YouTube Data API quota costs, in units, of the calls a conversion makes.
+// See https://developers.google.com/youtube/v3/determine_quota_cost
+const (
+	youtubeDailyQuota = 10000
+	youtubeListCost   = 1
+	youtubeSearchCost = 100
+	youtubeInsertCost = 50
+)
+
+// libraryServices are the services listPlaylists lists the library of, as
+// named in help and error messages.
+const libraryServices = "spotify, youtube, youtubemusic, applemusic, deezer, tidal, soundcloud, subsonic, jellyfin, plex or mpd"
+
+// followedHelp says what listPlaylists adds to a library on each service
+// when asked for followed playlists.
+const followedHelp = "playlists that are followed but not owned, liked sets on SoundCloud, other users' public playlists on Subsonic and playlists shared with the user on Jellyfin (YouTube, Plex and MPD only list the user's own)"
+
+// playlistSummary describes a playlist in the user's library without
+// fetching its tracks.
+type playlistSummary struct {
+	ID          string
+	Name        string
+	Description string
+	Owner       string
+	Owned       bool
+	Public      bool
+	Tracks      int
+}
+
+// quotaEstimate is the expected API usage of a bulk conversion. YouTube
+// usage is counted in quota units, Spotify only rate limits so its usage is
+// counted in requests.
+type quotaEstimate struct {
+	YouTubeUnits    int
+	SpotifyRequests int
+}
+
+// convertAll implements the convert-all command: every playlist in the
+// user's library on one service is copied to one or more other services in
+// one job.
+func convertAll(args []string) {
+	flags := flag.NewFlagSet("convert-all", flag.ExitOnError)
+	from := flags.String("from", "", "service to read playlists from ("+libraryServices+")")
+	to := flags.String("to", "", "comma separated destinations to write playlists to (spotify, youtube, youtubemusic, spotify:liked, youtube:liked or spotify:albums)")
+	followed := flags.Bool("followed", false, "also convert "+followedHelp)
+	include := flags.String("include", "", "only convert playlists whose name matches this regular expression")
+	exclude := flags.String("exclude", "", "skip playlists whose name matches this regular expression")
+	dryRun := flags.Bool("dry-run", false, "list the selected playlists and the quota estimate without converting anything")
+	flags.Parse(args)
+
+	start, err := serviceFromName(*from)
+	handleError(err, "Invalid -from")
+	if *to == "" {
+		log.Fatalf("Please give at least one destination with -to")
+	}
+	destinations := parseDestinations(strings.Split(*to, ","), start)
+	includeRe, err := compileOptional(*include)
+	handleError(err, "Invalid -include pattern")
+	excludeRe, err := compileOptional(*exclude)
+	handleError(err, "Invalid -exclude pattern")
+
+	playlists := listPlaylists(start, *followed)
+	selected := filterPlaylists(playlists, includeRe, excludeRe)
+	if len(selected) == 0 {
+		fmt.Println("No playlists matched, nothing to convert")
+		return
+	}
+
+	fmt.Printf("Converting %d of %d playlists from %s to %s:\n", len(selected), len(playlists), start, *to)
+	for _, p := range selected {
+		fmt.Printf("  %s (%d tracks)\n", p.Name, p.Tracks)
+	}
+	estimate := estimateQuota(start, destinations, len(playlists), selected)
+	printQuotaEstimate(estimate)
+	if *dryRun {
+		return
+	}
+
+	var results []ConversionResult
+	matches := newMatchCache()
+	for _, p := range selected {
+		fmt.Printf("Converting %s\n", p.Name)
+		songs := newSource(playlistRef{Service: start, ID: p.ID}).GetTracks()
+		results = append(results, writeAll(destinations, p.Name, songs, matches)...)
+	}
+	printReport(results)
+	printQuotaEstimate(estimate)
+	cleanUp()
+}
+
+// listPlaylists lists the playlists in the user's library on service, with
+// the ones they follow as well when followed is set. Services without a
+// library of playlists, such as files and listening histories, are rejected.
+func listPlaylists(service Service, followed bool) []playlistSummary {
+	switch service {
+	case SPOTIFY:
+		return listSpotifyPlaylists(followed)
+	case YOUTUBE, YOUTUBEMUSIC:
+		if followed {
+			fmt.Println("YouTube does not expose followed playlists, only playlists on your channel are listed")
+		}
+		return listYouTubePlaylists()
+	case APPLEMUSIC:
+		return listAppleMusicPlaylists(followed)
+	case DEEZER:
+		return listDeezerPlaylists(followed)
+	case TIDAL:
+		return listTidalPlaylists(followed)
+	case SOUNDCLOUD:
+		return listSoundCloudPlaylists(followed)
+	case SUBSONIC:
+		return listSubsonicPlaylists(followed)
+	case JELLYFIN:
+		return listJellyfinPlaylists(followed)
+	case PLEX:
+		if followed {
+			fmt.Println("Plex copies shared playlists to each account, only your own playlists are listed")
+		}
+		return listPlexPlaylists(followed)
+	case MPD:
+		return listMPDPlaylists(followed)
+	}
+	log.Fatalf("%s has no library of playlists, use %s", service, libraryServices)
+	return nil
+}
+
+func compileOptional(pattern string) (*regexp.Regexp, error) { //compiles pattern unless it is empty
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+func filterPlaylists(playlists []playlistSummary, include *regexp.Regexp, exclude *regexp.Regexp) []playlistSummary { //keeps playlists matching include and not matching exclude
+	var selected []playlistSummary
+	for _, p := range playlists {
+		if include != nil && !include.MatchString(p.Name) {
+			continue
+		}
+		if exclude != nil && exclude.MatchString(p.Name) {
+			continue
+		}
+		selected = append(selected, p)
+	}
+	return selected
+}
+
+// estimateQuota works out how much API usage converting the selected
+// playlists will take. listed is the number of playlists in the library,
+// which all have to be paged through to find the selected ones. Each track
+// is only searched for once per service however many destinations there
+// are on it.
+func estimateQuota(start Service, destinations []playlistRef, listed int, selected []playlistSummary) quotaEstimate {
+	var estimate quotaEstimate
+	listPages := pages(listed, 50)
+	switch start {
+	case SPOTIFY:
+		estimate.SpotifyRequests += 1 + listPages //current user and playlist pages
+		for _, p := range selected {
+			estimate.SpotifyRequests += pages(p.Tracks, 50)
+		}
+	case YOUTUBE, YOUTUBEMUSIC:
+		estimate.YouTubeUnits += listPages * youtubeListCost
+		for _, p := range selected {
+			if youtubeUsesAPI(settings.YouTube.Read) { //the playlists themselves can be read from another backend
+				estimate.YouTubeUnits += pages(p.Tracks, 50) * youtubeListCost
+			}
+		}
+	}
+	searched := make(map[Service]bool)
+	for _, destination := range destinations {
+		for _, p := range selected {
+			switch destination.Service {
+			case SPOTIFY:
+				if !searched[SPOTIFY] {
+					estimate.SpotifyRequests += p.Tracks
+				}
+				estimate.SpotifyRequests += 2 + pages(p.Tracks, 50) //current user, create and the adds
+			case YOUTUBE, YOUTUBEMUSIC:
+				if !searched[destination.Service] && youtubeUsesAPI(settings.YouTube.Search) {
+					estimate.YouTubeUnits += p.Tracks * youtubeSearchCost
+				}
+				if destination.ID == "" {
+					estimate.YouTubeUnits += pages(p.Tracks, youtubePlaylistLimit) * youtubeInsertCost
+				}
+				estimate.YouTubeUnits += p.Tracks * youtubeInsertCost //adding to a playlist and liking cost the same
+			}
+		}
+		searched[destination.Service] = true
+	}
+	return estimate
+}
+
+func pages(items int, pageSize int) int { //number of pages needed to fetch items, an empty list still takes one request
+	if items <= 0 {
+		return 1
+	}
+	return (items + pageSize - 1) / pageSize
+}
+
+func printQuotaEstimate(estimate quotaEstimate) {
+	if estimate.YouTubeUnits > 0 {
+		fmt.Printf("Estimated YouTube quota: %d of %d daily units\n", estimate.YouTubeUnits, youtubeDailyQuota)
+		if estimate.YouTubeUnits > youtubeDailyQuota {
+			fmt.Println("This is more than a day's YouTube quota, use -include or -exclude to split the job over several days")
+		}
+	}
+	if estimate.SpotifyRequests > 0 {
+		fmt.Printf("Estimated Spotify requests: %d\n", estimate.SpotifyRequests)
+	}
+}
+
+func printReport(results []ConversionResult) { //prints a summary table of every converted playlist
+	var total, added, notFound int
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PLAYLIST\tDESTINATION\tTRACKS\tADDED\tNOT FOUND")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", r.Name, r.Destination, r.Total, r.Added, len(r.NotFound))
+		total += r.Total
+		added += r.Added
+		notFound += len(r.NotFound)
+	}
+	fmt.Fprintf(w, "TOTAL\t\t%d\t%d\t%d\n", total, added, notFound)
+	w.Flush()
+	for _, r := range results {
+		for _, song := range r.NotFound {
+			fmt.Printf("%s (%s): %s not found\n", r.Name, r.Destination, song)
+		}
+	}
+}
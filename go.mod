@@ -1,4 +1,4 @@
-module main
+module playlistconverter
 
 go 1.18
 
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyDefaultLimit is how many songs top lists and listens are cut to
+// when the reference does not say.
+const historyDefaultLimit = 100
+
+// relativePeriod matches the periods counted back from now, e.g. 7day,
+// 3month or 1year.
+var relativePeriod = regexp.MustCompile(`^(\d+)(day|week|month|year)s?$`)
+
+// yearPeriod matches a year, which is read as the period rather than the
+// limit, so top:2025 is the top 100 of 2025.
+var yearPeriod = regexp.MustCompile(`^(19|20)\d\d$`)
+
+// historyQuery is what to read from a listening history service, parsed
+// from what follows lastfm: or listenbrainz:, which is
+//
+//	[<file>#][<user>:]<kind>[:<period or name>][:<limit>]
+//
+// such as loved, top:12month:50, someone:top:2025 or listens:500. A file
+// is an export read instead of the API.
+type historyQuery struct {
+	File   string
+	User   string
+	Kind   string
+	Period string //the period of a top list or of listens, or the name of a playlist
+	Limit  int    //0 for no limit
+}
+
+// listen is a song listened to at a point in time.
+type listen struct {
+	Track Track
+	At    time.Time
+}
+
+// parseHistoryQuery parses a reference, where kinds are the lists the
+// service has. The user defaults to the one in the config file.
+func parseHistoryQuery(ref string, kinds []string, defaultUser string) (historyQuery, error) {
+	var query historyQuery
+	selector := ref
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		query.File, selector = ref[:i], ref[i+1:]
+	} else if _, err := os.Stat(ref); err == nil {
+		query.File, selector = ref, ""
+	}
+	isKind := func(kind string) bool {
+		for _, k := range kinds {
+			if k == kind {
+				return true
+			}
+		}
+		return false
+	}
+	parts := strings.Split(selector, ":")
+	if len(parts) > 0 && parts[0] != "" && !isKind(strings.ToLower(parts[0])) {
+		query.User, parts = parts[0], parts[1:]
+	}
+	if len(parts) > 0 {
+		query.Kind, parts = strings.ToLower(parts[0]), parts[1:]
+	}
+	for _, part := range parts {
+		if n, err := strconv.Atoi(part); err == nil && n > 0 && !(query.Period == "" && yearPeriod.MatchString(part)) {
+			query.Limit = n
+		} else if query.Period == "" {
+			query.Period = part
+		} else {
+			return query, fmt.Errorf("%q has more parts than a %s reference takes", ref, query.Kind)
+		}
+	}
+	if query.Kind == "" && query.File == "" {
+		return query, fmt.Errorf("say which list to read, one of %s", strings.Join(kinds, ", "))
+	}
+	if query.Kind != "" && !isKind(query.Kind) {
+		return query, fmt.Errorf("%q is not one of %s", query.Kind, strings.Join(kinds, ", "))
+	}
+	if query.User == "" {
+		query.User = defaultUser
+	}
+	if query.Limit == 0 && (query.Kind == "top" || query.Kind == "listens") {
+		query.Limit = historyDefaultLimit
+	}
+	return query, nil
+}
+
+// historyRange is the time span of a period: a year such as 2025, a month
+// such as 2025-06, a span counted back from now such as 7day or 12month,
+// or overall, which like no period at all is unbounded and gives zero
+// times.
+func historyRange(period string, now time.Time) (time.Time, time.Time, error) {
+	period = strings.ToLower(period)
+	if period == "" || period == "overall" || period == "all_time" {
+		return time.Time{}, time.Time{}, nil
+	}
+	if from, err := time.Parse("2006", period); err == nil {
+		return from, from.AddDate(1, 0, 0), nil
+	}
+	if from, err := time.Parse("2006-01", period); err == nil {
+		return from, from.AddDate(0, 1, 0), nil
+	}
+	if match := relativePeriod.FindStringSubmatch(period); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		switch match[2] {
+		case "day":
+			return now.AddDate(0, 0, -n), now, nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), now, nil
+		case "month":
+			return now.AddDate(0, -n, 0), now, nil
+		case "year":
+			return now.AddDate(-n, 0, 0), now, nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("%q is not a period, use overall, a year such as 2025, a month such as 2025-06 or a span such as 7day or 12month", period)
+}
+
+func inRange(at time.Time, from time.Time, to time.Time) bool { //zero times leave that end open
+	return (from.IsZero() || !at.Before(from)) && (to.IsZero() || at.Before(to))
+}
+
+// topTracks ranks the songs listened to between from and to by how often
+// they were played, the first played first among equals, and keeps the
+// first limit of them.
+func topTracks(listens []listen, from time.Time, to time.Time, limit int) []Track {
+	var tracks []Track
+	plays := make(map[string]int)
+	index := make(map[string]int)
+	for _, l := range listens {
+		if !inRange(l.At, from, to) {
+			continue
+		}
+		key := normaliseName(l.Track.Artist) + "\x00" + normaliseName(l.Track.Title)
+		if _, seen := index[key]; !seen {
+			index[key] = len(tracks)
+			tracks = append(tracks, l.Track)
+		}
+		plays[key]++
+	}
+	key := func(t Track) string { return normaliseName(t.Artist) + "\x00" + normaliseName(t.Title) }
+	sort.SliceStable(tracks, func(i, j int) bool { return plays[key(tracks[i])] > plays[key(tracks[j])] })
+	if limit > 0 && len(tracks) > limit {
+		tracks = tracks[:limit]
+	}
+	for _, t := range tracks {
+		fmt.Printf("%d plays: %v\n", plays[key(t)], t.Query())
+	}
+	return tracks
+}
+
+// recentListens keeps the songs listened to between from and to, most
+// recent first, and the first limit of them.
+func recentListens(listens []listen, from time.Time, to time.Time, limit int) []Track {
+	sort.SliceStable(listens, func(i, j int) bool { return listens[i].At.After(listens[j].At) })
+	var tracks []Track
+	for _, l := range listens {
+		if limit > 0 && len(tracks) >= limit {
+			break
+		}
+		if inRange(l.At, from, to) {
+			tracks = append(tracks, l.Track)
+			fmt.Println(l.Track.Query())
+		}
+	}
+	return tracks
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHistoryQuery(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want historyQuery
+	}{
+		{"loved", historyQuery{User: "me", Kind: "loved"}},
+		{"top", historyQuery{User: "me", Kind: "top", Limit: historyDefaultLimit}},
+		{"top:2025", historyQuery{User: "me", Kind: "top", Period: "2025", Limit: historyDefaultLimit}},
+		{"top:2025:100", historyQuery{User: "me", Kind: "top", Period: "2025", Limit: 100}},
+		{"TOP:7day:20", historyQuery{User: "me", Kind: "top", Period: "7day", Limit: 20}},
+		{"someone:top:12month:50", historyQuery{User: "someone", Kind: "top", Period: "12month", Limit: 50}},
+		{"someone:loved", historyQuery{User: "someone", Kind: "loved"}},
+		{"listens:500", historyQuery{User: "me", Kind: "listens", Limit: 500}},
+		{"listens", historyQuery{User: "me", Kind: "listens", Limit: historyDefaultLimit}},
+		{"export.csv#top:2025", historyQuery{File: "export.csv", User: "me", Kind: "top", Period: "2025", Limit: historyDefaultLimit}},
+		{"export.csv#someone:listens:10", historyQuery{File: "export.csv", User: "someone", Kind: "listens", Limit: 10}},
+		{"export.csv#", historyQuery{File: "export.csv", User: "me"}},
+	}
+	for _, test := range tests {
+		got, err := parseHistoryQuery(test.ref, lastFMKinds, "me")
+		if err != nil {
+			t.Errorf("parseHistoryQuery(%q) failed: %v", test.ref, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseHistoryQuery(%q) = %+v, want %+v", test.ref, got, test.want)
+		}
+	}
+}
+
+func TestParseHistoryQueryRejects(t *testing.T) {
+	for _, ref := range []string{"", "someone", "someone:charts", "top:2025:100:extra", "top:7day:month"} {
+		if got, err := parseHistoryQuery(ref, lastFMKinds, "me"); err == nil {
+			t.Errorf("parseHistoryQuery(%q) = %+v, want an error", ref, got)
+		}
+	}
+}
+
+func TestHistoryRange(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		period   string
+		from, to time.Time
+	}{
+		{"", time.Time{}, time.Time{}},
+		{"overall", time.Time{}, time.Time{}},
+		{"2025", date(2025, 1, 1), date(2026, 1, 1)},
+		{"2025-06", date(2025, 6, 1), date(2025, 7, 1)},
+		{"7day", now.AddDate(0, 0, -7), now},
+		{"2weeks", now.AddDate(0, 0, -14), now},
+		{"12month", now.AddDate(-1, 0, 0), now},
+		{"1year", now.AddDate(-1, 0, 0), now},
+	}
+	for _, test := range tests {
+		from, to, err := historyRange(test.period, now)
+		if err != nil {
+			t.Errorf("historyRange(%q) failed: %v", test.period, err)
+			continue
+		}
+		if !from.Equal(test.from) || !to.Equal(test.to) {
+			t.Errorf("historyRange(%q) = %v to %v, want %v to %v", test.period, from, to, test.from, test.to)
+		}
+	}
+	for _, period := range []string{"fortnight", "2025-13", "day"} {
+		if _, _, err := historyRange(period, now); err == nil {
+			t.Errorf("historyRange(%q) succeeded, want an error", period)
+		}
+	}
+}
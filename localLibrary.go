@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// libraryConfig points at the user's music folder, which local playlists
+// and playlists for DJ software are matched against.
+type libraryConfig struct {
+	Folder string `json:"folder"`
+}
+
+// audioExtensions are the file types scanned in the music folder.
+var audioExtensions = map[string]bool{
+	".mp3": true, ".flac": true, ".m4a": true, ".aac": true, ".ogg": true,
+	".opus": true, ".wav": true, ".aif": true, ".aiff": true, ".alac": true,
+}
+
+// featuring matches a featured artist credit, which services and file names
+// put in different places if at all.
+var featuring = regexp.MustCompile(`\s[(\[]?(feat\.?|ft\.?|featuring)\s[^)\]]*[)\]]?`)
+
+// localLibrary is an index of the audio files in a music folder, used to
+// find the file for a track from a service.
+type localLibrary struct {
+	Tracks  []Track
+	byName  map[string][]int //normalised "artist title" and "title artist"
+	byTitle map[string][]int
+	byID    map[string]int //ISRCs and MusicBrainz recording IDs
+}
+
+// musicLibrary is the scanned music folder, shared by every playlist in a run.
+var musicLibrary *localLibrary
+
+// openMusicLibrary scans the music folder from the config file the first
+// time it is needed.
+func openMusicLibrary() *localLibrary {
+	if musicLibrary != nil {
+		return musicLibrary
+	}
+	if settings.Library.Folder == "" {
+		log.Fatalf("Set library.folder in %s to the folder your music is in", configFile)
+	}
+	library, err := scanLibrary(settings.Library.Folder)
+	handleError(err, "Unable to scan music folder")
+	fmt.Printf("Found %d audio files in %s\n", len(library.Tracks), settings.Library.Folder)
+	musicLibrary = library
+	return library
+}
+
+// scanLibrary indexes every audio file under folder by its tags. Files
+// without tags are indexed by their name, such as "Artist - Title.mp3", or
+// for "01 Title.mp3" by an Artist/Album/ folder layout.
+func scanLibrary(folder string) (*localLibrary, error) {
+	root, err := filepath.Abs(folder)
+	if err != nil {
+		return nil, err
+	}
+	library := newLocalLibrary(nil)
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !audioExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		track, err := readTags(path)
+		if err != nil { //still indexed by name
+			track = Track{}
+		}
+		track.Location = path
+		if track.Title == "" {
+			artist, title := titleFromFileName(path, track.Artist)
+			track.Title = title
+			if track.Artist == "" {
+				track.Artist = artist
+			}
+		}
+		if rel, err := filepath.Rel(root, path); err == nil && track.Artist == "" {
+			if dirs := strings.Split(filepath.Dir(rel), string(filepath.Separator)); len(dirs) >= 2 {
+				track.Artist = dirs[len(dirs)-2]
+				if track.Album == "" {
+					track.Album = dirs[len(dirs)-1]
+				}
+			}
+		}
+		library.add(track)
+		return nil
+	})
+	return library, err
+}
+
+// newLocalLibrary indexes tracks, which media servers use for their search
+// results so these are matched the same way as the music folder.
+func newLocalLibrary(tracks []Track) *localLibrary {
+	library := &localLibrary{byName: make(map[string][]int), byTitle: make(map[string][]int), byID: make(map[string]int)}
+	for _, track := range tracks {
+		library.add(track)
+	}
+	return library
+}
+
+func (l *localLibrary) add(track Track) {
+	i := len(l.Tracks)
+	l.Tracks = append(l.Tracks, track)
+	title, artist := normaliseName(track.Title), normaliseName(track.Artist)
+	l.byTitle[title] = append(l.byTitle[title], i)
+	for _, id := range []string{strings.ToUpper(track.ISRC), strings.ToLower(track.MusicBrainzID)} {
+		if _, seen := l.byID[id]; id != "" && !seen {
+			l.byID[id] = i
+		}
+	}
+	if artist != "" {
+		l.byName[artist+" "+title] = append(l.byName[artist+" "+title], i)
+		l.byName[title+" "+artist] = append(l.byName[title+" "+artist], i)
+	}
+}
+
+// match finds the file for a track. A track that already is a file in the
+// folder is kept, then its ISRC or MusicBrainz ID are looked up, otherwise
+// the artist and title have to match, ignoring case, punctuation and
+// featured artists. Tracks from YouTube videos, whose title holds both,
+// are matched against either order.
+//
+// Media servers match their search results with it as well, so a song is
+// never swapped for another one that just happens to be the top result.
+func (l *localLibrary) match(track Track) (Track, bool) {
+	if track.Location != "" && !strings.Contains(track.Location, "://") {
+		if path, err := filepath.Abs(track.Location); err == nil {
+			for _, candidate := range l.Tracks {
+				if candidate.Location == path {
+					return candidate, true
+				}
+			}
+		}
+	}
+	for _, id := range []string{strings.ToUpper(track.ISRC), strings.ToLower(track.MusicBrainzID)} {
+		if i, ok := l.byID[id]; id != "" && ok {
+			return l.Tracks[i], true
+		}
+	}
+	title, artist := normaliseName(track.Title), normaliseName(track.Artist)
+	if artist == "" {
+		if found := l.byName[title]; len(found) > 0 {
+			return l.Tracks[found[0]], true
+		}
+		return Track{}, false
+	}
+	if found := l.byName[artist+" "+title]; len(found) > 0 {
+		return l.Tracks[found[0]], true
+	}
+	lead := normaliseName(leadArtist(track.Artist))
+	for _, i := range l.byTitle[title] { //the same song credited a little differently, e.g. "A & B" against "A"
+		other := normaliseName(l.Tracks[i].Artist)
+		if other != "" && (strings.Contains(other, lead) || strings.Contains(lead, other)) {
+			return l.Tracks[i], true
+		}
+	}
+	return Track{}, false
+}
+
+// searchTitles are the titles to search a media server for a track by.
+// Servers only match part of their own titles, so the title is searched for
+// without featured artists and video tags first, and without the artist in
+// front of a video title, then as it is if that finds nothing.
+func searchTitles(track Track) []string {
+	title := cleanVideoTitle(track.Title)
+	if before, after, found := strings.Cut(title, " - "); found && track.Artist == "" && before != "" {
+		title = after
+	}
+	title = strings.TrimSpace(featuring.ReplaceAllString(title, ""))
+	if title == "" || strings.EqualFold(title, track.Title) {
+		return []string{track.Title}
+	}
+	return []string{title, track.Title}
+}
+
+// matchSearch finds track among the results search gives for each of its
+// searchTitles in turn.
+func matchSearch(track Track, search func(title string) []Track) (Track, bool) {
+	for _, title := range searchTitles(track) {
+		if found, ok := newLocalLibrary(search(title)).match(track); ok {
+			return found, true
+		}
+	}
+	return Track{}, false
+}
+
+// normaliseName reduces a title or artist to lowercase letters and digits
+// separated by single spaces, without featured artists or video tags.
+func normaliseName(name string) string {
+	name = featuring.ReplaceAllString(cleanVideoTitle(name), "")
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, name)
+	return strings.Join(strings.Fields(name), " ")
+}
+
+func leadArtist(artist string) string { //the first of several credited artists
+	for _, separator := range []string{", ", " & ", " x ", " and ", " feat", " ft.", "; "} {
+		if i := strings.Index(strings.ToLower(artist), separator); i > 0 {
+			artist = artist[:i]
+		}
+	}
+	return artist
+}
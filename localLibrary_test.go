@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLocalLibraryMatch(t *testing.T) {
+	library := newLocalLibrary([]Track{
+		{ID: "one", Title: "One More Time", Artist: "Daft Punk", ISRC: "GBDUW0000053"},
+		{ID: "lucky", Title: "Get Lucky", Artist: "Daft Punk & Pharrell Williams", MusicBrainzID: "d3b2a0b6-1f9d-4b46-9a3c-6a2b5e1c4f10"},
+		{ID: "song", Title: "Song", Artist: "Artist"},
+		{ID: "other", Title: "Song", Artist: "Someone Else"},
+	})
+	tests := []struct {
+		track Track
+		want  string
+	}{
+		{Track{Title: "Something Else", Artist: "Nobody", ISRC: "gbduw0000053"}, "one"},
+		{Track{Title: "Something Else", MusicBrainzID: "D3B2A0B6-1F9D-4B46-9A3C-6A2B5E1C4F10"}, "lucky"},
+		{Track{Title: "one more time", Artist: "DAFT PUNK"}, "one"},
+		{Track{Title: "Daft Punk - One More Time (Official Video)"}, "one"},
+		{Track{Title: "One More Time - Daft Punk"}, "one"},
+		{Track{Title: "Get Lucky (feat. Pharrell Williams)", Artist: "Daft Punk"}, "lucky"},
+		{Track{Title: "Song", Artist: "Artist, Friend"}, "song"},
+		{Track{Title: "Song [feat. Guest]", Artist: "Someone Else"}, "other"},
+		{Track{Title: "Song", Artist: "Nobody"}, ""},
+		{Track{Title: "Missing", Artist: "Daft Punk"}, ""},
+	}
+	for _, test := range tests {
+		found, ok := library.match(test.track)
+		if found.ID != test.want || ok != (test.want != "") {
+			t.Errorf("match(%+v) = %q, %v, want %q", test.track, found.ID, ok, test.want)
+		}
+	}
+}
+
+func TestSearchTitles(t *testing.T) {
+	tests := []struct {
+		track Track
+		want  []string
+	}{
+		{Track{Title: "Song", Artist: "Artist"}, []string{"Song"}},
+		{Track{Title: "Song (feat. Guest)", Artist: "Artist"}, []string{"song", "Song (feat. Guest)"}},
+		{Track{Title: "Artist - Song (Official Video)"}, []string{"song", "Artist - Song (Official Video)"}},
+		{Track{Title: "Song - Live", Artist: "Artist"}, []string{"Song - Live"}},
+	}
+	for _, test := range tests {
+		if got := searchTitles(test.track); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("searchTitles(%+v) = %q, want %q", test.track, got, test.want)
+		}
+	}
+}
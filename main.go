@@ -13,8 +13,8 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
-	"regexp"
 	"runtime"
+	"strings"
 )
 
 type Service int
@@ -22,8 +22,55 @@ type Service int
 const (
 	SPOTIFY Service = iota
 	YOUTUBE
+	M3U
+	XSPF
+	JSPF
+	CSV
+	BACKUP
+	TEXT
+	ITUNES
+	REKORDBOX
+	TRAKTOR
+	SERATO
+	LOCAL
+	SETLISTFM
+	APPLEMUSIC
+	DEEZER
+	TIDAL
+	SOUNDCLOUD
+	YOUTUBEMUSIC
+	SUBSONIC
+	JELLYFIN
+	PLEX
+	MPD
+	LASTFM
+	LISTENBRAINZ
 )
 
+var serviceNames = []string{"spotify", "youtube", "m3u", "xspf", "jspf", "csv", "backup", "text", "itunes", "rekordbox", "traktor", "serato", "local", "setlistfm", "applemusic", "deezer", "tidal", "soundcloud", "youtubemusic", "subsonic", "jellyfin", "plex", "mpd", "lastfm", "listenbrainz"}
+
+func (s Service) String() string {
+	if s < 0 || int(s) >= len(serviceNames) {
+		return fmt.Sprintf("Service(%d)", int(s))
+	}
+	return serviceNames[s]
+}
+
+// isFile reports whether the service is a playlist file format, which is
+// addressed by a file path instead of an ID and needs no login.
+func (s Service) isFile() bool {
+	return s == M3U || s == XSPF || s == JSPF || s == CSV || s == BACKUP || s == TEXT || s == ITUNES || s == REKORDBOX || s == TRAKTOR || s == SERATO || s == LOCAL
+}
+
+func serviceFromName(name string) (Service, error) { //parses a service name given on the command line
+	for i := range serviceNames {
+		if strings.EqualFold(name, serviceNames[i]) {
+			return Service(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown service %q, expected one of %s", name, strings.Join(serviceNames, ", "))
+}
+
 func handleError(err error, message string) {
 	if message == "" {
 		message = "Error making API call"
@@ -195,124 +242,203 @@ func saveToken(file string, token *oauth2.Token) {
 	defer f.Close()
 	json.NewEncoder(f).Encode(token)
 }
-func playlistIDFromURL(service string) string { //extracts playlist id from url based on which service is being used
-	//only returns the ID portion of the URLS
-	var playlistURL string
-	var playlistID string
-	switch service {
-	case "spotify":
-		var re = regexp.MustCompile(`(?P<spotifyURL>\Qhttps://open.spotify.com/playlist/\E)(?P<id>[A-Za-z0-9]{22})`) //spotify playlist URL format
-		fmt.Println("Enter the Spotify playlist URL")
-		_, err := fmt.Scan(&playlistURL)
+func promptPlaylistRef() playlistRef { //asks for the playlist to convert until a valid link is entered, the link decides which service it is converted from
+	var input string
+	for {
+		fmt.Println("Enter the playlist, album or artist URL to convert, or spotify:liked / youtube:liked for your liked songs")
+		_, err := fmt.Scan(&input)
 		if err != nil {
-			fmt.Println("Please try again")
-			playlistIDFromURL("spotify")
+			log.Fatalf("Unable to read playlist URL %v", err)
 		}
-		if re.MatchString(playlistURL) {
-			matches := re.FindStringSubmatch(playlistURL)
-			indexID := re.SubexpIndex("id")
-			playlistID = matches[indexID]
-		} else {
-			fmt.Println("Please try again")
-			playlistIDFromURL("spotify")
+		ref, err := parsePlaylistRef(input)
+		if err == nil && (ref.ID == "" || ref.ID == albumsID) {
+			err = fmt.Errorf("please include the playlist")
 		}
-	case "youtube":
-		var re = regexp.MustCompile(`(?m)(?P<youtubeurl>\Qhttps://www.youtube.com/playlist?list=\E)(?P<id>.{34})`) //youtube URL format
-		fmt.Println("Enter the YouTube playlist URL")
-		_, err := fmt.Scan(&playlistURL)
 		if err != nil {
-			fmt.Println("please try again")
-			playlistIDFromURL("youtube")
-		}
-		if re.MatchString(playlistURL) {
-			matches := re.FindStringSubmatch(playlistURL)
-			indexID := re.SubexpIndex("id")
-			playlistID = matches[indexID]
-		} else {
-			fmt.Println("Please input a valid YouTube playlist ID")
-			playlistIDFromURL("youtube")
+			fmt.Println(err)
+			fmt.Println("Please try again")
+			continue
 		}
-	default:
-		log.Fatalf("INVALID SERVICE")
+		return ref
 	}
-	return playlistID
 }
 
-func determineFlow() (Service, Service) { //gets user input for program flow
-	var start Service
+func determineFlow() (playlistRef, Service) { //gets user input for program flow
 	var finish Service
-	var err error
-	chooser := []string{"Spotify", "YouTube" /*, "Apple Music"*/}
-	fmt.Println("0. Spotify " + "| 1. YouTube " /*+"| 3. Apple Music"*/)
-	fmt.Println("What are you converting from?")
-	_, err = fmt.Scan(&start)
-	if err != nil {
-		fmt.Println("please try again.")
-		determineFlow()
-	}
+	var choice int
+	chooser := []Service{SPOTIFY, YOUTUBE, YOUTUBEMUSIC, APPLEMUSIC, DEEZER, TIDAL, SOUNDCLOUD}
+	source := promptPlaylistRef()
 	//ask what they are converting to, and assign to finish
+	fmt.Println("0. Spotify " + "| 1. YouTube (videos) " + "| 2. YouTube Music (songs) " + "| 3. Apple Music " + "| 4. Deezer " + "| 5. Tidal " + "| 6. SoundCloud")
 	fmt.Println("What are you converting to?")
-	_, err = fmt.Scan(&finish)
-	if err != nil {
+	_, err := fmt.Scan(&choice)
+	if err != nil || choice < 0 || choice >= len(chooser) {
 		fmt.Println("please try again.")
-		determineFlow()
+		return determineFlow()
+	}
+	finish = chooser[choice]
+	fmt.Println("You are converting from", source.Service, "to", finish)
+	if source.Kind == artistKind && source.Service == SPOTIFY && askYesNo("Convert the artist's full discography instead of their top tracks?") {
+		source.Kind = discographyKind
+	}
+	return source, finish
+}
+func askYesNo(question string) bool { //asks a yes or no question, anything but yes counts as no
+	var answer string
+	fmt.Println(question + " (y/n)")
+	if _, err := fmt.Scan(&answer); err != nil {
+		return false
 	}
-	fmt.Println("You are converting from", chooser[start], "to", chooser[finish])
-	fmt.Println(start, finish)
-	return start, finish
+	return strings.HasPrefix(strings.ToLower(answer), "y")
+}
+func newSource(ref playlistRef) Source { //returns the reader for a playlist on the given service
+	switch ref.Service {
+	case SPOTIFY:
+		return NewSpotify(ref)
+	case YOUTUBE:
+		return NewYoutube(ref)
+	case M3U:
+		return NewM3UFile(ref)
+	case XSPF, JSPF:
+		return NewXSPFFile(ref)
+	case CSV:
+		return NewCSVFile(ref)
+	case BACKUP:
+		return NewJSONBackup(ref)
+	case TEXT:
+		return NewTextFile(ref)
+	case ITUNES:
+		return NewITunesLibrary(ref)
+	case LOCAL:
+		return NewLocalMusic(ref)
+	case SETLISTFM:
+		return NewSetlistFM(ref)
+	case APPLEMUSIC:
+		return NewAppleMusic(ref)
+	case DEEZER:
+		return NewDeezer(ref)
+	case TIDAL:
+		return NewTidal(ref)
+	case SOUNDCLOUD:
+		return NewSoundCloud(ref)
+	case YOUTUBEMUSIC:
+		return NewYouTubeMusic(ref)
+	case SUBSONIC:
+		return NewSubsonic(ref)
+	case JELLYFIN:
+		return NewJellyfin(ref)
+	case PLEX:
+		return NewPlex(ref)
+	case MPD:
+		return NewMPDPlaylist(ref)
+	case LASTFM:
+		return NewLastFM(ref)
+	case LISTENBRAINZ:
+		return NewListenBrainz(ref)
+	case REKORDBOX, TRAKTOR, SERATO:
+		log.Fatalf("%s playlists can only be written, not converted from", ref.Service)
+	}
+	log.Fatalf("INVALID SERVICE")
+	return nil
+}
+func newDestination(ref playlistRef) Destination { //returns the writer for a new playlist, or a library collection, on the given service
+	switch ref.Service {
+	case SPOTIFY:
+		return NewSpotify(ref)
+	case YOUTUBE:
+		return NewYoutube(ref)
+	case M3U:
+		return NewM3UFile(ref)
+	case XSPF, JSPF:
+		return NewXSPFFile(ref)
+	case CSV:
+		return NewCSVFile(ref)
+	case BACKUP:
+		return NewJSONBackup(ref)
+	case TEXT:
+		return NewTextFile(ref)
+	case ITUNES:
+		return NewITunesLibrary(ref)
+	case REKORDBOX, TRAKTOR, SERATO:
+		return NewDJExport(ref)
+	case LOCAL:
+		return NewLocalMusic(ref)
+	case SETLISTFM:
+		log.Fatalf("Setlists can only be converted from, not written")
+	case APPLEMUSIC:
+		return NewAppleMusic(ref)
+	case DEEZER:
+		return NewDeezer(ref)
+	case TIDAL:
+		return NewTidal(ref)
+	case SOUNDCLOUD:
+		return NewSoundCloud(ref)
+	case YOUTUBEMUSIC:
+		return NewYouTubeMusic(ref)
+	case SUBSONIC:
+		return NewSubsonic(ref)
+	case JELLYFIN:
+		return NewJellyfin(ref)
+	case PLEX:
+		return NewPlex(ref)
+	case MPD:
+		return NewMPDPlaylist(ref)
+	case LASTFM, LISTENBRAINZ:
+		log.Fatalf("Listening history can only be converted from, not written")
+	}
+	log.Fatalf("INVALID SERVICE")
+	return nil
 }
 func cleanUp() { //deletes credential files
-	services := []string{"youtube", "spotify"}
+	services := []string{"youtube", "spotify", "applemusic", "deezer", "tidal", "soundcloud"}
 	usr, err := user.Current()
 	if err != nil {
 		log.Fatalf("could not retrieve current user")
 	}
 	for i := range services {
 		err = os.Remove(usr.HomeDir + "/.credentials/" + services[i] + "-go.json")
-		if err != nil {
+		if err != nil && !os.IsNotExist(err) { //a service only has a credential file if it was used
 			log.Fatalf("error deleting file")
 		}
 	}
 }
 func main() {
-	var start Service
+	settings = loadConfig(configFile)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "convert":
+			convert(os.Args[2:])
+			return
+		case "convert-all":
+			convertAll(os.Args[2:])
+			return
+		case "backup":
+			backup(os.Args[2:])
+			return
+		case "restore":
+			restore(os.Args[2:])
+			return
+		default:
+			log.Fatalf("unknown command %q", os.Args[1])
+		}
+	}
+	var source playlistRef
 	var finish Service
-	var playlist Playlist
+	var playlist Source
 
-	start, finish = determineFlow() //Ask what they are converting to and from, and assign to start and finish
-	for {                           //checks that available options were selected and that start and finish are different. If either is false loop until both are true.
-		if start == finish { //checks if start and finish are the same
-			fmt.Println("Please make sure your start and ending services are different")
-			start, finish = determineFlow()
-			continue
-		} else if start != SPOTIFY && start != YOUTUBE /* && start != 3 */ { // check start to make sure it is an available option
-			fmt.Println("Please select a provided option.")
-			start, finish = determineFlow()
-			continue
-		} else if finish != SPOTIFY && finish != YOUTUBE /* && finish != 3*/ { // check finish to make sure it is an available option
-			fmt.Println("Please select a provided option.")
-			start, finish = determineFlow()
-			continue
-		} else { //break out of loop once all conditions satisfied
-			break
-		}
+	source, finish = determineFlow() //Ask what they are converting from and to, the service being converted from comes from the playlist link
+	for source.Service == finish {   //loop until start and finish are different
+		fmt.Println("Please make sure your start and ending services are different")
+		source, finish = determineFlow()
 	}
-	//start getting the playlist
-	switch start {
-	case SPOTIFY:
-		playlist = NewSpotify()
-	case YOUTUBE:
-		playlist = NewYoutube()
+	destination := playlistRef{Service: finish}
+	if source.Kind == albumKind && finish == SPOTIFY && askYesNo("Save the album to your library instead of making a playlist?") {
+		destination.ID = albumsID
 	}
+	//start getting the playlist
+	playlist = newSource(source)
 	//copy playlist to other service
-	switch finish {
-	case SPOTIFY:
-		createSpotifyPlaylist(playlist.GetSongs())
-		fmt.Println("completed!")
-	case YOUTUBE:
-		createYouTubePlaylist(playlist.GetSongs())
-		fmt.Println("Completed!")
-
-	}
+	newDestination(destination).WritePlaylist("Converted Playlist", playlist.GetTracks(), newMatchCache())
+	fmt.Println("Completed!")
 	cleanUp()
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// matchCache remembers what each search query matched on a service, so a
+// job writing the same tracks to several destinations only searches for
+// each track once per service. Queries that matched nothing are remembered
+// as well, with an empty ID.
+type matchCache struct {
+	matches map[Service]map[string]string
+}
+
+func newMatchCache() *matchCache {
+	return &matchCache{matches: make(map[Service]map[string]string)}
+}
+
+func (c *matchCache) get(service Service, query string) (id string, cached bool) { //looks up an earlier search
+	id, cached = c.matches[service][query]
+	return id, cached
+}
+
+func (c *matchCache) put(service Service, query string, id string) { //records a search result, use an empty id for no match
+	if c.matches[service] == nil {
+		c.matches[service] = make(map[string]string)
+	}
+	c.matches[service][query] = id
+}
+
+func (c *matchCache) services() []Service { //the services with searches recorded, in a stable order
+	var services []Service
+	for i := range serviceNames {
+		if len(c.matches[Service(i)]) > 0 {
+			services = append(services, Service(i))
+		}
+	}
+	return services
+}
+
+// matchKey is what the match of a track is remembered by: its search query
+// along with its ISRC, or else its MusicBrainz ID, so an original and a
+// remaster with the same title and artist are each matched on their own.
+func matchKey(track Track) string {
+	switch {
+	case track.ISRC != "":
+		return track.Query() + "\x00" + strings.ToUpper(track.ISRC)
+	case track.MusicBrainzID != "":
+		return track.Query() + "\x00" + strings.ToLower(track.MusicBrainzID)
+	}
+	return track.Query()
+}
+
+// find looks up the ID of each track on service with search, unless an
+// earlier search already matched it, and returns the IDs found in playlist
+// order. Tracks search finds nothing for, returning an empty ID, are
+// reported and counted as not found in result.
+func (c *matchCache) find(service Service, playlist []Track, result *ConversionResult, search func(track Track) string) []string {
+	var ids []string
+	for _, track := range playlist {
+		query := track.Query()
+		id, cached := c.get(service, matchKey(track))
+		if !cached {
+			id = search(track)
+			c.put(service, matchKey(track), id)
+		}
+		if id == "" {
+			fmt.Printf("%s : not found\n", query)
+			result.NotFound = append(result.NotFound, query)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// addInBatches calls add with the IDs a batch of at most size at a time,
+// for services that limit how many items one request adds or that take the
+// IDs in the URL, which a batch keeps to a sensible length, and counts them
+// as added to result.
+func addInBatches(ids []string, size int, result *ConversionResult, add func(batch []string)) {
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > size {
+			batch = batch[:size]
+		}
+		add(batch)
+		result.Added += len(batch)
+		ids = ids[len(batch):]
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestMatchCacheFind checks that each track is only searched for once, and
+// that tracks with the same title and artist but different ISRCs, such as
+// an original and a remaster, are each searched for.
+func TestMatchCacheFind(t *testing.T) {
+	playlist := []Track{
+		{Title: "Song", Artist: "Artist", ISRC: "USAAA0000001"},
+		{Title: "Song", Artist: "Artist", ISRC: "USAAA1900001"},
+		{Title: "Song", Artist: "Artist", ISRC: "usaaa0000001"},
+		{Title: "Song", Artist: "Artist"},
+		{Title: "Song", Artist: "Artist"},
+		{Title: "Missing"},
+	}
+	var searched []Track
+	search := func(track Track) string {
+		searched = append(searched, track)
+		if track.Title == "Missing" {
+			return ""
+		}
+		return track.ISRC + "-id"
+	}
+	matches := newMatchCache()
+	result := ConversionResult{Total: len(playlist)}
+	ids := matches.find(SPOTIFY, playlist, &result, search)
+	if want := []string{"USAAA0000001-id", "USAAA1900001-id", "USAAA0000001-id", "-id", "-id"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("found %q, want %q", ids, want)
+	}
+	if len(searched) != 4 || !reflect.DeepEqual(result.NotFound, []string{"Missing"}) {
+		t.Errorf("searched %d times, not found %v, want 4 searches and Missing", len(searched), result.NotFound)
+	}
+	matches.find(SPOTIFY, playlist, &ConversionResult{}, search)
+	if len(searched) != 4 {
+		t.Errorf("searched again for tracks already matched")
+	}
+}
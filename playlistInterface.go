@@ -6,48 +6,110 @@ import (
 	"google.golang.org/api/youtube/v3"
 	"log"
 	"strings"
+	"time"
 )
 
-type Playlist interface {
-	GetSongs() []string
+// Source is anywhere a playlist can be read from.
+type Source interface {
+	GetTracks() []Track
 }
-type YouTube struct {
+
+// Destination is anywhere a playlist can be written to. Destinations on the
+// same service share matches, so a job writing to several of them only
+// looks each track up once.
+type Destination interface {
+	WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult
 }
 
-func NewYoutube() Playlist {
-	return &YouTube{}
+// Track is a song read from a source, with whatever metadata the source
+// knows about it. Only Title is always set.
+type Track struct {
+	Title         string
+	Artist        string
+	Album         string
+	ISRC          string
+	MusicBrainzID string //MusicBrainz recording ID
+	Duration      time.Duration
+	ID            string //ID of the track on the service it was read from
+	Location      string //link to the track on its service, or the path of a local file
+}
+
+// Query is the text used to search for the track on another service.
+func (t Track) Query() string {
+	if t.Artist == "" {
+		return t.Title
+	}
+	return t.Title + " - " + t.Artist //concatenate song name and artist name for more accurate search
+}
+
+// ConversionResult records what happened to a single playlist written to a
+// destination service, so runs that convert several playlists can report on
+// all of them at the end.
+type ConversionResult struct {
+	Name        string
+	Destination string
+	Total       int
+	Added       int
+	NotFound    []string
+}
+
+// playlistFileName turns a playlist name into a file name with the given
+// extension, for file destinations written without a path.
+func playlistFileName(name string, ext string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	return name + ext
+}
+
+type YouTube struct {
+	ID   string
+	Kind refKind
 }
-func (Y *YouTube) GetSongs() []string {
-	var playlist []string
-	part := []string{"snippet"}
-	undesiredVideoTitles := []string{"[official music video]", "[official lyric video]", "[official video]", "[official audio]", "[audio]", "[video]", "[animated music video]", "(official music video)", "(official video)", "(official audio)", "(audio)", "(video)", "(animated music video)"}
-	client := getGoogleClient(youtube.YoutubeReadonlyScope)
-	service, err := youtube.New(client)
 
-	if err != nil {
-		log.Fatalf("Error creating YouTube client: %v", err)
+func NewYoutube(ref playlistRef) *YouTube {
+	return &YouTube{ID: ref.ID, Kind: ref.Kind}
+}
+func (Y *YouTube) GetTracks() []Track {
+	var playlist []Track
+	if Y.ID == likedID {
+		client := getGoogleClient()
+		service, err := youtube.New(client)
+		if err != nil {
+			log.Fatalf("Error creating YouTube client: %v", err)
+		}
+		return youtubeLikedVideos(service)
+	}
+	reader := newYouTubeReader(settings.YouTube.Read, nil) //public playlists and videos can be read without the Data API
+	if Y.Kind == mixKind {
+		return youtubeMixTracks(reader, Y.ID)
 	}
 
-	playlistId := playlistIDFromURL("youtube") // Print the playlist ID for the list of uploaded videos.
+	playlistId := Y.ID // Print the playlist ID for the list of uploaded videos.
+	album := ""
+	switch Y.Kind {
+	case artistKind, discographyKind: //a channel's uploads are in a playlist with the same ID apart from the prefix
+		playlistId = "UU" + strings.TrimPrefix(Y.ID, "UC")
+	case albumKind:
+		album = youtubeAlbumTitle(reader, Y.ID)
+	}
 	fmt.Printf("Videos in list %s\r\n", playlistId)
 
 	nextPageToken := ""
 	for {
 		// Retrieve next set of items in the playlist.
-		playlistResponse := playlistItemsList(service, part, playlistId, nextPageToken)
+		playlistResponse := reader.playlistItems(playlistId, nextPageToken)
 
 		for _, playlistItem := range playlistResponse.Items {
-			title := strings.ToLower(playlistItem.Snippet.Title)
-			videoId := playlistItem.Snippet.ResourceId.VideoId
-			for i := range undesiredVideoTitles {
-				if strings.Contains(title, undesiredVideoTitles[i]) {
-					title = strings.Replace(title, undesiredVideoTitles[i], "", -1)
-				} else {
-					continue
-				}
-			}
-			playlist = append(playlist, title)
-			fmt.Printf("%v, (%v)\r\n", title, videoId)
+			track := youtubeTrack(playlistItem.Snippet.Title, playlistItem.Snippet.VideoOwnerChannelTitle)
+			track.ID = playlistItem.Snippet.ResourceId.VideoId
+			track.Location = youtubeVideoURL(track.ID)
+			track.Album = album
+			playlist = append(playlist, track)
+			fmt.Printf("%v, (%v)\r\n", track.Title, track.ID)
 		}
 
 		// Set the token to retrieve the next page of results
@@ -62,19 +124,90 @@ func (Y *YouTube) GetSongs() []string {
 	return playlist
 }
 
+// WritePlaylist creates a new playlist, or likes the videos when the
+// YouTube reference is to the liked videos.
+func (Y *YouTube) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	switch Y.ID {
+	case "":
+		return createYouTubePlaylist(name, "youtube", tracks, matches, searchYouTubeVideos)
+	case likedID:
+		return likeYouTubeVideos("Liked videos", "youtube:liked", tracks, matches, searchYouTubeVideos)
+	case albumsID:
+		log.Fatalf("YouTube has no saved albums, convert to youtube to make a playlist of the album")
+	}
+	log.Fatalf("Adding to an existing YouTube playlist is not supported")
+	return ConversionResult{}
+}
+
+// cleanVideoTitle lowercases a video title and strips the tags uploaders add
+// to music videos, which only get in the way of searching for the song.
+func cleanVideoTitle(title string) string {
+	undesiredVideoTitles := []string{"[official music video]", "[official lyric video]", "[official video]", "[official audio]", "[audio]", "[video]", "[animated music video]", "(official music video)", "(official video)", "(official audio)", "(audio)", "(video)", "(animated music video)"}
+	title = strings.ToLower(title)
+	for i := range undesiredVideoTitles {
+		title = strings.Replace(title, undesiredVideoTitles[i], "", -1)
+	}
+	return title
+}
+
+func youtubeVideoURL(videoId string) string {
+	return "https://www.youtube.com/watch?v=" + videoId
+}
+
+// youtubeTrack builds a Track from a video. Videos on auto-generated
+// "Artist - Topic" channels are the official audio of a release, so their
+// title is just the song and the artist can be taken from the channel name.
+// Any other video title is kept whole, since it usually has both in it.
+func youtubeTrack(title string, channelTitle string) Track {
+	if artist := strings.TrimSuffix(channelTitle, " - Topic"); artist != channelTitle {
+		return Track{Title: title, Artist: artist}
+	}
+	return Track{Title: cleanVideoTitle(title)}
+}
+
 type Spotify struct {
+	ID   string
+	Kind refKind
 }
 
-func NewSpotify() Playlist {
-	return &Spotify{}
+func NewSpotify(ref playlistRef) *Spotify {
+	return &Spotify{ID: ref.ID, Kind: ref.Kind}
 }
-func (S *Spotify) GetSongs() []string {
+func (S *Spotify) GetTracks() []Track {
 	var spotifyID spotify.ID
-	client := getSpotifyClient(spotify.ScopeUserReadPrivate)
+	var playlist []Track
+	client := getSpotifyClient()
 	service := spotify.NewClient(client)
-	playlistId := playlistIDFromURL("spotify")
-	spotifyID = spotify.ID(playlistId)
-	playlist := spotifyPlaylistItems(service, spotifyID)
+	if S.ID == likedID {
+		return spotifyLikedSongs(service)
+	}
+	spotifyID = spotify.ID(S.ID)
+	switch S.Kind {
+	case albumKind:
+		playlist = spotifyAlbumTracks(service, spotifyID)
+	case artistKind:
+		playlist = spotifyArtistTopTracks(service, spotifyID)
+	case discographyKind:
+		playlist = spotifyDiscography(service, spotifyID)
+	default:
+		playlist = spotifyPlaylistItems(service, spotifyID)
+	}
 	fmt.Println(playlist) //placeholder for testing
 	return playlist
 }
+
+// WritePlaylist creates a new playlist, or saves the tracks or their albums
+// to the library when the Spotify reference is to the liked songs or saved
+// albums.
+func (S *Spotify) WritePlaylist(name string, tracks []Track, matches *matchCache) ConversionResult {
+	switch S.ID {
+	case "":
+		return createSpotifyPlaylist(name, tracks, matches)
+	case likedID:
+		return likeSpotifyTracks(tracks, matches)
+	case albumsID:
+		return saveSpotifyAlbums(tracks, matches)
+	}
+	log.Fatalf("Adding to an existing Spotify playlist is not supported")
+	return ConversionResult{}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// likedID is the playlist ID used to address a service's liked songs or
+// liked videos, which are a library collection rather than a playlist.
+const likedID = "liked"
+
+// youtubeLikedListID is the ID YouTube gives the liked videos list.
+const youtubeLikedListID = "LL"
+
+// albumsID is the destination ID for saving the albums tracks come from to
+// the user's library, rather than adding the tracks to a playlist.
+const albumsID = "albums"
+
+// refKind is what sort of collection a playlistRef points at. Anything that
+// is not a playlist is still read as a list of tracks.
+type refKind int
+
+const (
+	playlistKind refKind = iota
+	albumKind
+	artistKind      //an artist's top tracks
+	discographyKind //every track on an artist's albums and singles
+	mixKind         //the timestamped tracklist of a single YouTube video
+)
+
+// playlistRef addresses a playlist, album or artist on a service. As a
+// destination an empty ID means a new playlist is created.
+type playlistRef struct {
+	Service Service
+	Kind    refKind
+	ID      string
+}
+
+// fileExtensions maps playlist file extensions to the service for the format.
+var fileExtensions = map[string]Service{
+	".m3u":   M3U,
+	".m3u8":  M3U,
+	".xspf":  XSPF,
+	".jspf":  JSPF,
+	".csv":   CSV,
+	".json":  BACKUP,
+	".txt":   TEXT,
+	".xml":   ITUNES, //also rekordbox, which has to be given as rekordbox:<path>
+	".nml":   TRAKTOR,
+	".crate": SERATO,
+}
+
+var (
+	spotifyIDPattern = regexp.MustCompile(`^[A-Za-z0-9]{22}$`)
+	//YouTube list IDs are a type prefix followed by a base64url string of varying length, e.g. PL playlists of 18 or 34 characters and OLAK5uy_ albums.
+	//Bare IDs need the shortest length for their prefix, so words such as PLAYLIST are not taken for one
+	youtubeListIDPattern     = regexp.MustCompile(`^[A-Za-z0-9_-]{2,}$`)
+	youtubeListPrefixPattern = regexp.MustCompile(`^(PL[A-Za-z0-9_-]{16,}|OLAK5uy_[A-Za-z0-9_-]{20,}|(UU|FL)[A-Za-z0-9_-]{22}|RD[A-Za-z0-9_-]{11,}|OL[A-Za-z0-9_-]{16,})$`)
+	youtubeVideoIDPattern    = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	appleMusicIDPattern      = regexp.MustCompile(`^(p\.|pl\.(u-)?)?[A-Za-z0-9]+$`)
+	uuidPattern              = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	setlistIDPattern         = regexp.MustCompile(`-([0-9a-f]{7,8})\.html$`)
+)
+
+// parsePlaylistRef works out the service and playlist ID from anything a
+// user is likely to paste:
+//   - service names on their own, which leave the ID empty
+//   - service references: spotify:liked, youtube:liked, spotify:<id>, youtube:<id>
+//   - Spotify URIs: spotify:playlist:<id>, spotify:user:<user>:playlist:<id>,
+//     spotify:album:<id> and spotify:artist:<id>
+//   - Spotify playlist, album and artist links, with or without scheme,
+//     intl-xx/ prefix or ?si= parameter
+//   - YouTube links with a list= parameter, including m.youtube.com, watch
+//     URLs and youtu.be short links
+//   - the same on music.youtube.com, read as YouTube Music, and
+//     youtubemusic:<id>, where LM is the liked songs
+//   - YouTube channel links, read as the artist's uploads
+//   - Apple Music playlist and album links, applemusic:<id> and applemusic:liked
+//   - Deezer playlist, album and artist links, deezer:<id>, deezer:album:<id>
+//     and deezer:liked, and the same for Tidal
+//   - SoundCloud set and user links, soundcloud:<set id> and soundcloud:liked
+//   - setlist.fm setlist links and setlistfm:<id or file>
+//   - subsonic:<playlist id> and subsonic:liked for the starred songs, and
+//     the same for Jellyfin, plex:<playlist id> and mpd:<stored playlist>
+//   - Last.fm user and loved tracks links, lastfm:[<user>:]loved and
+//     lastfm:[<user>:]top[:<period>][:<limit>], e.g. lastfm:top:2025:100
+//   - ListenBrainz user and playlist links, listenbrainz:[<user>:]listens,
+//     top, recommendations[:<name>] and listenbrainz:playlist:<mbid>
+//   - YouTube video links without a list= parameter and youtube:video:<id>,
+//     read as the tracklist of a DJ mix
+//   - bare Spotify playlist IDs and bare YouTube list IDs with a known prefix,
+//     and otherwise a file of that name, read as a text tracklist
+//   - playlist files, as a path with a known extension or a format
+//     reference such as m3u:<path>, where a playlist within the file is
+//     picked with a # after the path, e.g. Library.xml#<name>
+//
+// YouTube Music albums are playlists with an OLAK5uy_ ID, these come back
+// as albums wherever they are found.
+func parsePlaylistRef(ref string) (playlistRef, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return playlistRef{}, fmt.Errorf("empty playlist reference")
+	}
+	fileService, isFile := fileExtensions[strings.ToLower(filepath.Ext(ref))]
+	if i := strings.LastIndex(ref, "#"); !isFile && i >= 0 {
+		fileService, isFile = fileExtensions[strings.ToLower(filepath.Ext(ref[:i]))]
+	}
+	if name, rest, found := strings.Cut(ref, ":"); found && !strings.HasPrefix(rest, "//") {
+		service, err := serviceFromName(name)
+		if err == nil {
+			return parseServiceRef(service, rest)
+		}
+		if !isFile { //otherwise a Windows path with a drive letter
+			return playlistRef{}, err
+		}
+	}
+	if isFile && !strings.Contains(ref, "://") {
+		return playlistRef{Service: fileService, ID: ref}, nil
+	}
+	if service, err := serviceFromName(ref); err == nil { //just the service, e.g. a destination to create a new playlist on
+		return playlistRef{Service: service}, nil
+	}
+	if strings.Contains(ref, "/") || strings.Contains(ref, ".") {
+		return parsePlaylistURL(ref)
+	}
+	return parseBareID(ref)
+}
+
+func parseServiceRef(service Service, rest string) (playlistRef, error) { //parses what comes after spotify: or youtube:
+	if rest == "" || service.isFile() {
+		return playlistRef{Service: service, ID: rest}, nil
+	}
+	if strings.EqualFold(rest, likedID) {
+		return playlistRef{Service: service, ID: likedID}, nil
+	}
+	if strings.EqualFold(rest, albumsID) {
+		return playlistRef{Service: service, ID: albumsID}, nil
+	}
+	switch service {
+	case SPOTIFY:
+		parts := strings.Split(rest, ":")
+		if len(parts) == 1 {
+			return spotifyRef("playlist", parts[0])
+		}
+		return spotifyRef(parts[len(parts)-2], parts[len(parts)-1])
+	case YOUTUBE:
+		if id := strings.TrimPrefix(rest, "video:"); id != rest {
+			return youtubeMixRef(id)
+		}
+		return youtubeRef(rest)
+	case YOUTUBEMUSIC:
+		return youtubeMusicRef(youtubeRef(rest))
+	case APPLEMUSIC:
+		return appleMusicRef(rest)
+	case DEEZER:
+		parts := strings.Split(rest, ":")
+		if len(parts) == 1 {
+			return deezerRef("playlist", parts[0])
+		}
+		return deezerRef(parts[0], parts[1])
+	case SOUNDCLOUD: //a link path or a set ID
+		return soundCloudRef(rest)
+	case TIDAL:
+		parts := strings.Split(rest, ":")
+		if len(parts) == 1 {
+			return tidalRef("playlist", parts[0])
+		}
+		return tidalRef(parts[0], parts[1])
+	case SETLISTFM: //a setlist ID or a saved setlist
+		return playlistRef{Service: SETLISTFM, ID: rest}, nil
+	case SUBSONIC, JELLYFIN, PLEX, MPD: //a playlist ID, or stored playlist name for MPD, on the server in the config file
+		return playlistRef{Service: service, ID: rest}, nil
+	case LASTFM, LISTENBRAINZ: //which list of whose listening history, parsed when it is read
+		return playlistRef{Service: service, ID: rest}, nil
+	}
+	return playlistRef{}, fmt.Errorf("INVALID SERVICE")
+}
+
+func parsePlaylistURL(ref string) (playlistRef, error) { //parses a link to a playlist, album or setlist
+	if !strings.Contains(ref, "://") {
+		ref = "https://" + ref
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return playlistRef{}, err
+	}
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	switch host {
+	case "open.spotify.com", "play.spotify.com":
+		return parseSpotifyPath(u.Path)
+	case "music.youtube.com":
+		u.Host = "youtube.com"
+		return youtubeMusicRef(parsePlaylistURL(u.String()))
+	case "youtube.com", "m.youtube.com", "youtu.be", "youtube-nocookie.com":
+		list := u.Query().Get("list")
+		if channel := strings.TrimPrefix(u.Path, "/channel/"); list == "" && channel != u.Path {
+			return playlistRef{Service: YOUTUBE, Kind: artistKind, ID: strings.Trim(channel, "/")}, nil
+		}
+		if video := u.Query().Get("v"); list == "" && video != "" {
+			return youtubeMixRef(video)
+		}
+		if list == "" && host == "youtu.be" {
+			return youtubeMixRef(strings.Trim(u.Path, "/"))
+		}
+		if list == "" {
+			return playlistRef{}, fmt.Errorf("%s does not contain a playlist, look for a link with list= in it", ref)
+		}
+		return youtubeRef(list)
+	case "soundcloud.com", "m.soundcloud.com":
+		return soundCloudRef(strings.Trim(u.Path, "/"))
+	case "tidal.com", "listen.tidal.com":
+		return parseTidalPath(u.Path)
+	case "deezer.com":
+		return parseDeezerPath(u.Path)
+	case "music.apple.com", "geo.music.apple.com":
+		return parseAppleMusicPath(u.Path)
+	case "setlist.fm":
+		return parseSetlistURL(u.Path)
+	case "last.fm", "m.last.fm":
+		return parseLastFMPath(u.Path)
+	case "listenbrainz.org":
+		return parseListenBrainzPath(u.Path)
+	}
+	return playlistRef{}, fmt.Errorf("%s is not a recognised playlist, album or setlist link", ref)
+}
+
+func parseSpotifyPath(path string) (playlistRef, error) { //parses the path of an open.spotify.com link
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) > 0 && strings.HasPrefix(segments[0], "intl-") { //localised links, e.g. /intl-de/playlist/<id>
+		segments = segments[1:]
+	}
+	if len(segments) > 0 && segments[0] == "embed" {
+		segments = segments[1:]
+	}
+	if len(segments) == 2 && segments[0] == "collection" && segments[1] == "tracks" { //the Liked Songs page
+		return playlistRef{Service: SPOTIFY, ID: likedID}, nil
+	}
+	if len(segments) == 4 && segments[0] == "user" { //old style /user/<user>/playlist/<id> links
+		segments = segments[2:]
+	}
+	if len(segments) != 2 {
+		return playlistRef{}, fmt.Errorf("%s is not a Spotify playlist, album or artist link", path)
+	}
+	return spotifyRef(segments[0], segments[1])
+}
+
+func spotifyRef(kind string, id string) (playlistRef, error) { //builds a reference from the type and ID parts of a Spotify link or URI
+	kinds := map[string]refKind{"playlist": playlistKind, "album": albumKind, "artist": artistKind}
+	k, ok := kinds[strings.ToLower(kind)]
+	if !ok {
+		return playlistRef{}, fmt.Errorf("Spotify %s links are not supported, use a playlist, album or artist", kind)
+	}
+	if !spotifyIDPattern.MatchString(id) {
+		return playlistRef{}, fmt.Errorf("%q is not a valid Spotify ID", id)
+	}
+	return playlistRef{Service: SPOTIFY, Kind: k, ID: id}, nil
+}
+
+func parseBareID(id string) (playlistRef, error) { //guesses the service of an ID given on its own
+	switch {
+	case id == youtubeLikedListID:
+		return playlistRef{Service: YOUTUBE, ID: likedID}, nil
+	case strings.EqualFold(id, likedID):
+		return playlistRef{}, fmt.Errorf("say spotify:liked or youtube:liked to pick whose liked songs to convert")
+	case spotifyIDPattern.MatchString(id):
+		return playlistRef{Service: SPOTIFY, ID: id}, nil
+	case youtubeListPrefixPattern.MatchString(id):
+		return youtubeRef(id)
+	}
+	if _, err := os.Stat(id); err == nil { //a file without a playlist extension, read as a text tracklist
+		return playlistRef{Service: TEXT, ID: id}, nil
+	}
+	return playlistRef{}, fmt.Errorf("%q is not a recognised playlist link or ID", id)
+}
+
+func youtubeRef(id string) (playlistRef, error) {
+	if id == youtubeLikedListID {
+		return playlistRef{Service: YOUTUBE, ID: likedID}, nil
+	}
+	if id == youtubeMusicLikedListID {
+		return playlistRef{Service: YOUTUBEMUSIC, ID: likedID}, nil
+	}
+	if !youtubeListIDPattern.MatchString(id) {
+		return playlistRef{}, fmt.Errorf("%q is not a valid YouTube playlist ID", id)
+	}
+	if strings.HasPrefix(id, "OLAK5uy_") {
+		return playlistRef{Service: YOUTUBE, Kind: albumKind, ID: id}, nil
+	}
+	return playlistRef{Service: YOUTUBE, ID: id}, nil
+}
+
+func youtubeMusicRef(ref playlistRef, err error) (playlistRef, error) { //moves a YouTube reference over to YouTube Music, which has the same IDs
+	if err == nil {
+		ref.Service = YOUTUBEMUSIC
+	}
+	return ref, err
+}
+
+func youtubeMixRef(id string) (playlistRef, error) { //a video read as the tracklist in its description
+	if !youtubeVideoIDPattern.MatchString(id) {
+		return playlistRef{}, fmt.Errorf("%q is not a valid YouTube video ID", id)
+	}
+	return playlistRef{Service: YOUTUBE, Kind: mixKind, ID: id}, nil
+}
+
+func parseLastFMPath(path string) (playlistRef, error) { //a user's page, read as their top tracks, or their loved tracks at /user/<name>/loved
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) < 2 || segments[0] != "user" {
+		return playlistRef{}, fmt.Errorf("%s is not a Last.fm user link", path)
+	}
+	if len(segments) > 2 && segments[2] == "loved" {
+		return playlistRef{Service: LASTFM, ID: segments[1] + ":loved"}, nil
+	}
+	return playlistRef{Service: LASTFM, ID: segments[1] + ":top"}, nil
+}
+
+func parseListenBrainzPath(path string) (playlistRef, error) { //a playlist at /playlist/<mbid>, or a user's page, read as their listens
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) < 2 {
+		return playlistRef{}, fmt.Errorf("%s is not a ListenBrainz playlist or user link", path)
+	}
+	switch segments[0] {
+	case "playlist":
+		return playlistRef{Service: LISTENBRAINZ, ID: "playlist:" + segments[1]}, nil
+	case "user":
+		return playlistRef{Service: LISTENBRAINZ, ID: segments[1] + ":listens"}, nil
+	}
+	return playlistRef{}, fmt.Errorf("%s is not a ListenBrainz playlist or user link", path)
+}
+
+func parseSetlistURL(path string) (playlistRef, error) { //setlist links end in the setlist ID, e.g. /setlist/<artist>/<year>/<venue>-63de4613.html
+	match := setlistIDPattern.FindStringSubmatch(path)
+	if !strings.HasPrefix(path, "/setlist/") || match == nil {
+		return playlistRef{}, fmt.Errorf("%s is not a setlist.fm setlist link", path)
+	}
+	return playlistRef{Service: SETLISTFM, ID: match[1]}, nil
+}
+
+func parseAppleMusicPath(path string) (playlistRef, error) { //parses /<storefront>/playlist/<name>/pl.<id>, /library/playlist/p.<id> and /<storefront>/album/<name>/<id>
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		if (segment == "playlist" || segment == "album") && i+1 < len(segments) {
+			return appleMusicRef(segments[len(segments)-1])
+		}
+	}
+	return playlistRef{}, fmt.Errorf("%s is not an Apple Music playlist or album link", path)
+}
+
+func appleMusicRef(id string) (playlistRef, error) { //library playlists start p., catalog playlists pl. and albums are numbers
+	if !appleMusicIDPattern.MatchString(id) {
+		return playlistRef{}, fmt.Errorf("%q is not a valid Apple Music ID", id)
+	}
+	if strings.Trim(id, "0123456789") == "" {
+		return playlistRef{Service: APPLEMUSIC, Kind: albumKind, ID: id}, nil
+	}
+	return playlistRef{Service: APPLEMUSIC, ID: id}, nil
+}
+
+func parseDeezerPath(path string) (playlistRef, error) { //parses /<language>/playlist/<id> and the like
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) == 3 { //the language is optional
+		segments = segments[1:]
+	}
+	if len(segments) != 2 {
+		return playlistRef{}, fmt.Errorf("%s is not a Deezer playlist, album or artist link", path)
+	}
+	return deezerRef(segments[0], segments[1])
+}
+
+func deezerRef(kind string, id string) (playlistRef, error) { //builds a reference from the type and ID parts of a Deezer link
+	kinds := map[string]refKind{"playlist": playlistKind, "album": albumKind, "artist": artistKind}
+	k, ok := kinds[strings.ToLower(kind)]
+	if !ok {
+		return playlistRef{}, fmt.Errorf("Deezer %s links are not supported, use a playlist, album or artist", kind)
+	}
+	if strings.Trim(id, "0123456789") != "" || id == "" {
+		return playlistRef{}, fmt.Errorf("%q is not a valid Deezer ID", id)
+	}
+	return playlistRef{Service: DEEZER, Kind: k, ID: id}, nil
+}
+
+func parseTidalPath(path string) (playlistRef, error) { //parses /browse/playlist/<uuid>, /album/<id> and the like, including /album/<id>/track/<id>
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) > 0 && segments[0] == "browse" {
+		segments = segments[1:]
+	}
+	if len(segments) < 2 {
+		return playlistRef{}, fmt.Errorf("%s is not a Tidal playlist, album or artist link", path)
+	}
+	return tidalRef(segments[0], segments[1])
+}
+
+func tidalRef(kind string, id string) (playlistRef, error) { //playlists have UUIDs, albums and artists numbers
+	kinds := map[string]refKind{"playlist": playlistKind, "album": albumKind, "artist": artistKind}
+	k, ok := kinds[strings.ToLower(kind)]
+	if !ok {
+		return playlistRef{}, fmt.Errorf("Tidal %s links are not supported, use a playlist, album or artist", kind)
+	}
+	if (k == playlistKind && !uuidPattern.MatchString(id)) || (k != playlistKind && (id == "" || strings.Trim(id, "0123456789") != "")) {
+		return playlistRef{}, fmt.Errorf("%q is not a valid Tidal %s ID", id, kind)
+	}
+	return playlistRef{Service: TIDAL, Kind: k, ID: id}, nil
+}
+
+func soundCloudRef(path string) (playlistRef, error) { //<user>/sets/<set>, a user's tracks at <user>, your own likes at you/likes or a numeric set ID
+	segments := strings.Split(path, "/")
+	switch {
+	case path == "you/likes":
+		return playlistRef{Service: SOUNDCLOUD, ID: likedID}, nil
+	case len(segments) == 3 && segments[1] == "sets":
+		return playlistRef{Service: SOUNDCLOUD, ID: path}, nil
+	case len(segments) == 1 && segments[0] != "":
+		if strings.Trim(path, "0123456789") == "" {
+			return playlistRef{Service: SOUNDCLOUD, ID: path}, nil
+		}
+		return playlistRef{Service: SOUNDCLOUD, Kind: artistKind, ID: path}, nil
+	}
+	return playlistRef{}, fmt.Errorf("%s is not a SoundCloud set or user link", path)
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestParsePlaylistRef(t *testing.T) {
+	const (
+		spotifyID  = "37i9dQZF1DXcBWIGoYBM5M"
+		albumID    = "4aawyAB9vmqN3uQ7FjRGTy"
+		shortList  = "PL0123456789abcdef"
+		longList   = "PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI"
+		albumList  = "OLAK5uy_kXvRLtF6PD9RN1pHgxjv7QPzqKdE0wTyM"
+		youtubeVid = "dQw4w9WgXcQ"
+	)
+	tests := []struct {
+		ref  string
+		want playlistRef
+	}{
+		{"spotify:playlist:" + spotifyID, playlistRef{Service: SPOTIFY, ID: spotifyID}},
+		{"spotify:user:someone:playlist:" + spotifyID, playlistRef{Service: SPOTIFY, ID: spotifyID}},
+		{"spotify:album:" + albumID, playlistRef{Service: SPOTIFY, Kind: albumKind, ID: albumID}},
+		{"spotify:artist:" + albumID, playlistRef{Service: SPOTIFY, Kind: artistKind, ID: albumID}},
+		{"spotify:liked", playlistRef{Service: SPOTIFY, ID: likedID}},
+		{"https://open.spotify.com/playlist/" + spotifyID, playlistRef{Service: SPOTIFY, ID: spotifyID}},
+		{"https://open.spotify.com/intl-de/playlist/" + spotifyID, playlistRef{Service: SPOTIFY, ID: spotifyID}},
+		{"https://open.spotify.com/intl-pt/album/" + albumID + "?si=a1b2c3d4e5f6", playlistRef{Service: SPOTIFY, Kind: albumKind, ID: albumID}},
+		{"https://open.spotify.com/playlist/" + spotifyID + "?si=a1b2c3d4e5f6", playlistRef{Service: SPOTIFY, ID: spotifyID}},
+		{"open.spotify.com/playlist/" + spotifyID, playlistRef{Service: SPOTIFY, ID: spotifyID}},
+		{spotifyID, playlistRef{Service: SPOTIFY, ID: spotifyID}},
+		{"https://www.youtube.com/playlist?list=" + longList, playlistRef{Service: YOUTUBE, ID: longList}},
+		{"https://m.youtube.com/playlist?list=" + shortList, playlistRef{Service: YOUTUBE, ID: shortList}},
+		{"https://www.youtube.com/watch?v=" + youtubeVid + "&list=" + longList, playlistRef{Service: YOUTUBE, ID: longList}},
+		{"https://youtu.be/" + youtubeVid + "?list=" + longList, playlistRef{Service: YOUTUBE, ID: longList}},
+		{"https://music.youtube.com/playlist?list=" + longList, playlistRef{Service: YOUTUBEMUSIC, ID: longList}},
+		{"https://music.youtube.com/playlist?list=" + albumList, playlistRef{Service: YOUTUBEMUSIC, Kind: albumKind, ID: albumList}},
+		{"https://www.youtube.com/watch?v=" + youtubeVid, playlistRef{Service: YOUTUBE, Kind: mixKind, ID: youtubeVid}},
+		{albumList, playlistRef{Service: YOUTUBE, Kind: albumKind, ID: albumList}},
+		{shortList, playlistRef{Service: YOUTUBE, ID: shortList}},
+		{longList, playlistRef{Service: YOUTUBE, ID: longList}},
+		{"youtube:liked", playlistRef{Service: YOUTUBE, ID: likedID}},
+		{"LL", playlistRef{Service: YOUTUBE, ID: likedID}},
+		{"mix.m3u8", playlistRef{Service: M3U, ID: "mix.m3u8"}},
+		{"Library.xml#Road Trip", playlistRef{Service: ITUNES, ID: "Library.xml#Road Trip"}},
+		{"youtube", playlistRef{Service: YOUTUBE}},
+	}
+	for _, test := range tests {
+		got, err := parsePlaylistRef(test.ref)
+		if err != nil {
+			t.Errorf("parsePlaylistRef(%q) failed: %v", test.ref, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parsePlaylistRef(%q) = %+v, want %+v", test.ref, got, test.want)
+		}
+	}
+}
+
+func TestParsePlaylistRefRejects(t *testing.T) {
+	for _, ref := range []string{"", "PLAYLIST", "OLDSTUFF", "PL123", "https://open.spotify.com/track/" + "4aawyAB9vmqN3uQ7FjRGTy", "https://www.youtube.com/feed/library"} {
+		if got, err := parsePlaylistRef(ref); err == nil {
+			t.Errorf("parsePlaylistRef(%q) = %+v, want an error", ref, got)
+		}
+	}
+}
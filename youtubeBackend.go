@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"google.golang.org/api/youtube/v3"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// youtubeConfig picks where public YouTube data comes from. The Data API
+// charges quota for every call, a search costing 100 of the 10,000 daily
+// units, while Invidious and Piped instances read the same public data
+// without quota or signing in. Writes, liked videos and the user's own
+// playlists always go through the Data API.
+type youtubeConfig struct {
+	Read      string `json:"read"`      //backend public playlists and videos are read with: api, invidious or piped
+	Search    string `json:"search"`    //backend songs are searched for with
+	Invidious string `json:"invidious"` //base URL of the Invidious instance
+	Piped     string `json:"piped"`     //base URL of the Piped API, which is usually not the address of its web site
+}
+
+// youtubeReader reads public playlists and videos and searches for songs.
+// The alternative backends give back the same types as the Data API, with
+// only the fields this program uses filled in.
+type youtubeReader interface {
+	video(id string) *youtube.Video
+	playlist(id string) *youtube.Playlist //nil when there is no such playlist
+	playlistItems(id string, pageToken string) *youtube.PlaylistItemListResponse
+	channelTitle(id string) string
+	search(query string, maxResults int64, music bool) []*youtube.SearchResult
+}
+
+// youtubeUsesAPI reports whether backend is the Data API, which is the
+// default.
+func youtubeUsesAPI(backend string) bool {
+	return backend == "" || backend == "api"
+}
+
+// newYouTubeReader returns the reader for a backend. service is the signed
+// in Data API client to use, or nil to sign in with read only access if the
+// backend needs it.
+func newYouTubeReader(backend string, service *youtube.Service) youtubeReader {
+	switch strings.ToLower(backend) {
+	case "", "api":
+		if service == nil {
+			var err error
+			service, err = youtube.New(getGoogleClient())
+			if err != nil {
+				log.Fatalf("Error creating YouTube client: %v", err)
+			}
+		}
+		return &youtubeAPIReader{service: service}
+	case "invidious":
+		if settings.YouTube.Invidious == "" {
+			log.Fatalf("Set youtube.invidious to the address of an Invidious instance in %s", configFile)
+		}
+		return &invidiousReader{base: strings.TrimSuffix(settings.YouTube.Invidious, "/")}
+	case "piped":
+		if settings.YouTube.Piped == "" {
+			log.Fatalf("Set youtube.piped to the API address of a Piped instance in %s", configFile)
+		}
+		return &pipedReader{base: strings.TrimSuffix(settings.YouTube.Piped, "/")}
+	}
+	log.Fatalf("Unknown YouTube backend %q, use api, invidious or piped", backend)
+	return nil
+}
+
+// youtubeAPIReader reads through the Data API.
+type youtubeAPIReader struct {
+	service *youtube.Service
+}
+
+func (r *youtubeAPIReader) video(id string) *youtube.Video { //gets the snippet and length of a single video
+	response, err := r.service.Videos.List([]string{"snippet", "contentDetails"}).Id(id).Do()
+	handleError(err, "Unable to look up YouTube video")
+	if len(response.Items) == 0 {
+		log.Fatalf("YouTube video %s not found", id)
+	}
+	return response.Items[0]
+}
+
+func (r *youtubeAPIReader) playlist(id string) *youtube.Playlist {
+	response, err := r.service.Playlists.List([]string{"snippet,contentDetails,status"}).Id(id).Do()
+	handleError(err, "Unable to look up YouTube playlist")
+	if len(response.Items) == 0 {
+		return nil
+	}
+	return response.Items[0]
+}
+
+func (r *youtubeAPIReader) playlistItems(id string, pageToken string) *youtube.PlaylistItemListResponse { //grabs a page of the items in a YouTube playlist
+	call := r.service.PlaylistItems.List([]string{"snippet"})
+	call = call.PlaylistId(id).MaxResults(50)
+	if pageToken != "" {
+		call = call.PageToken(pageToken)
+	}
+	response, err := call.Do()
+	handleError(err, "")
+	return response
+}
+
+func (r *youtubeAPIReader) channelTitle(id string) string {
+	response, err := r.service.Channels.List([]string{"snippet"}).Id(id).Do()
+	handleError(err, "Unable to look up YouTube channel")
+	if len(response.Items) == 0 {
+		log.Fatalf("YouTube channel %s not found", id)
+	}
+	return response.Items[0].Snippet.Title
+}
+
+func (r *youtubeAPIReader) search(query string, maxResults int64, music bool) []*youtube.SearchResult {
+	call := r.service.Search.List([]string{"id,snippet"}).
+		Q(query).
+		Type("video").
+		MaxResults(maxResults)
+	if music {
+		call = call.VideoCategoryId(youtubeMusicCategory)
+	}
+	response, err := call.Do()
+	handleError(err, "")
+	return response.Items
+}
+
+// invidiousReader reads through the API of an Invidious instance, see
+// https://docs.invidious.io/api/
+type invidiousReader struct {
+	base string
+}
+
+type invidiousVideo struct {
+	Type          string `json:"type"`
+	Title         string `json:"title"`
+	VideoID       string `json:"videoId"`
+	Author        string `json:"author"`
+	Description   string `json:"description"`
+	LengthSeconds int64  `json:"lengthSeconds"`
+	Index         int64  `json:"index"`
+}
+
+func (r *invidiousReader) get(path string, out interface{}) {
+	err := apiRequest(http.DefaultClient, "GET", r.base+"/api/v1"+path, nil, nil, out)
+	handleError(err, "Invidious request failed")
+}
+
+func (r *invidiousReader) video(id string) *youtube.Video {
+	var item invidiousVideo
+	r.get("/videos/"+url.PathEscape(id), &item)
+	return &youtube.Video{
+		Id:             id,
+		Snippet:        &youtube.VideoSnippet{Title: item.Title, Description: item.Description, ChannelTitle: item.Author},
+		ContentDetails: &youtube.VideoContentDetails{Duration: fmt.Sprintf("PT%dS", item.LengthSeconds)},
+	}
+}
+
+func (r *invidiousReader) playlist(id string) *youtube.Playlist {
+	var item struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Author      string `json:"author"`
+		VideoCount  int64  `json:"videoCount"`
+	}
+	r.get("/playlists/"+url.PathEscape(id), &item)
+	return &youtube.Playlist{
+		Id:             id,
+		Snippet:        &youtube.PlaylistSnippet{Title: item.Title, Description: item.Description, ChannelTitle: item.Author},
+		ContentDetails: &youtube.PlaylistContentDetails{ItemCount: item.VideoCount},
+		Status:         &youtube.PlaylistStatus{PrivacyStatus: "public"}, //only public playlists can be read without signing in
+	}
+}
+
+// playlistItems gets a page of a playlist. Invidious pages by number, which
+// is used as the page token, and there are more pages until the last video
+// read is the last one in the playlist.
+func (r *invidiousReader) playlistItems(id string, pageToken string) *youtube.PlaylistItemListResponse {
+	page := 1
+	if pageToken != "" {
+		page, _ = strconv.Atoi(pageToken)
+	}
+	var item struct {
+		VideoCount int64            `json:"videoCount"`
+		Videos     []invidiousVideo `json:"videos"`
+	}
+	r.get(fmt.Sprintf("/playlists/%s?page=%d", url.PathEscape(id), page), &item)
+	response := &youtube.PlaylistItemListResponse{}
+	for _, video := range item.Videos {
+		if video.VideoID == "" {
+			continue
+		}
+		response.Items = append(response.Items, youtubePlaylistItem(video.VideoID, video.Title, video.Author))
+	}
+	if n := len(item.Videos); n > 0 && item.Videos[n-1].Index+1 < item.VideoCount {
+		response.NextPageToken = strconv.Itoa(page + 1)
+	}
+	return response
+}
+
+func (r *invidiousReader) channelTitle(id string) string {
+	var item struct {
+		Author string `json:"author"`
+	}
+	r.get("/channels/"+url.PathEscape(id), &item)
+	return item.Author
+}
+
+// search has no music filter on Invidious, but songs can still be told
+// apart by their Topic channel.
+func (r *invidiousReader) search(query string, maxResults int64, music bool) []*youtube.SearchResult {
+	var items []invidiousVideo
+	r.get("/search?type=video&q="+url.QueryEscape(query), &items)
+	var results []*youtube.SearchResult
+	for _, item := range items {
+		if item.Type != "video" || int64(len(results)) >= maxResults {
+			continue
+		}
+		results = append(results, youtubeSearchResult(item.VideoID, item.Title, item.Author))
+	}
+	return results
+}
+
+// pipedReader reads through the API of a Piped instance, see
+// https://docs.piped.video/docs/api-documentation/
+type pipedReader struct {
+	base string
+}
+
+type pipedStream struct {
+	Type         string `json:"type"`
+	URL          string `json:"url"` //a path such as /watch?v=<id>
+	Title        string `json:"title"`
+	UploaderName string `json:"uploaderName"`
+	Duration     int64  `json:"duration"`
+}
+
+type pipedPage struct {
+	Name           string        `json:"name"`
+	Description    string        `json:"description"`
+	Uploader       string        `json:"uploader"`
+	Videos         int64         `json:"videos"`
+	RelatedStreams []pipedStream `json:"relatedStreams"`
+	Items          []pipedStream `json:"items"`
+	NextPage       string        `json:"nextpage"`
+}
+
+// pipedMarkup is the HTML Piped gives descriptions in.
+var pipedMarkup = regexp.MustCompile(`<[^>]*>`)
+
+func (r *pipedReader) get(path string, out interface{}) {
+	err := apiRequest(http.DefaultClient, "GET", r.base+path, nil, nil, out)
+	handleError(err, "Piped request failed")
+}
+
+func pipedVideoID(stream pipedStream) string {
+	u, err := url.Parse(stream.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("v")
+}
+
+func pipedText(html string) string { //turns a description back into plain text
+	text := strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n").Replace(html)
+	text = pipedMarkup.ReplaceAllString(text, "")
+	return strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'").Replace(text)
+}
+
+func (r *pipedReader) video(id string) *youtube.Video {
+	var item struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Uploader    string `json:"uploader"`
+		Duration    int64  `json:"duration"`
+	}
+	r.get("/streams/"+url.PathEscape(id), &item)
+	return &youtube.Video{
+		Id:             id,
+		Snippet:        &youtube.VideoSnippet{Title: item.Title, Description: pipedText(item.Description), ChannelTitle: item.Uploader},
+		ContentDetails: &youtube.VideoContentDetails{Duration: fmt.Sprintf("PT%dS", item.Duration)},
+	}
+}
+
+func (r *pipedReader) playlist(id string) *youtube.Playlist {
+	var item pipedPage
+	r.get("/playlists/"+url.PathEscape(id), &item)
+	return &youtube.Playlist{
+		Id:             id,
+		Snippet:        &youtube.PlaylistSnippet{Title: item.Name, Description: pipedText(item.Description), ChannelTitle: item.Uploader},
+		ContentDetails: &youtube.PlaylistContentDetails{ItemCount: item.Videos},
+		Status:         &youtube.PlaylistStatus{PrivacyStatus: "public"},
+	}
+}
+
+// playlistItems gets a page of a playlist. The first page comes with the
+// playlist, later ones are fetched with the nextpage value Piped gives,
+// which is used as the page token.
+func (r *pipedReader) playlistItems(id string, pageToken string) *youtube.PlaylistItemListResponse {
+	var item pipedPage
+	if pageToken == "" {
+		r.get("/playlists/"+url.PathEscape(id), &item)
+	} else {
+		r.get("/nextpage/playlists/"+url.PathEscape(id)+"?nextpage="+url.QueryEscape(pageToken), &item)
+	}
+	response := &youtube.PlaylistItemListResponse{NextPageToken: item.NextPage}
+	for _, stream := range item.RelatedStreams {
+		if videoId := pipedVideoID(stream); videoId != "" {
+			response.Items = append(response.Items, youtubePlaylistItem(videoId, stream.Title, stream.UploaderName))
+		}
+	}
+	if len(item.RelatedStreams) == 0 { //guards against an instance handing back the same token forever
+		response.NextPageToken = ""
+	}
+	return response
+}
+
+func (r *pipedReader) channelTitle(id string) string {
+	var item struct {
+		Name string `json:"name"`
+	}
+	r.get("/channel/"+url.PathEscape(id), &item)
+	return item.Name
+}
+
+// search uses Piped's YouTube Music song filter when looking for songs.
+// Songs are named after the artist rather than the Topic channel they are
+// on, so the channel name is put back to keep them recognisable as songs.
+func (r *pipedReader) search(query string, maxResults int64, music bool) []*youtube.SearchResult {
+	filter := "videos"
+	if music {
+		filter = "music_songs"
+	}
+	var page pipedPage
+	r.get("/search?filter="+filter+"&q="+url.QueryEscape(query), &page)
+	var results []*youtube.SearchResult
+	for _, stream := range page.Items {
+		videoId := pipedVideoID(stream)
+		if videoId == "" || int64(len(results)) >= maxResults {
+			continue
+		}
+		channel := stream.UploaderName
+		if music && !strings.HasSuffix(channel, " - Topic") {
+			channel += " - Topic"
+		}
+		results = append(results, youtubeSearchResult(videoId, stream.Title, channel))
+	}
+	return results
+}
+
+func youtubePlaylistItem(videoId string, title string, channelTitle string) *youtube.PlaylistItem {
+	return &youtube.PlaylistItem{Snippet: &youtube.PlaylistItemSnippet{
+		Title:                  title,
+		VideoOwnerChannelTitle: channelTitle,
+		ResourceId:             &youtube.ResourceId{Kind: "youtube#video", VideoId: videoId},
+	}}
+}
+
+func youtubeSearchResult(videoId string, title string, channelTitle string) *youtube.SearchResult {
+	return &youtube.SearchResult{
+		Id:      &youtube.ResourceId{Kind: "youtube#video", VideoId: videoId},
+		Snippet: &youtube.SearchResultSnippet{Title: title, ChannelTitle: channelTitle},
+	}
+}